@@ -0,0 +1,43 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+// errors
+var (
+	ErrInvalidAmount = errors.New("invalid amount")
+)
+
+const satoshisPerBitcoin = 100000000
+
+// convert a BTC amount as printed by bitcoind to satoshis
+// without going through a float
+func btcToSatoshis(amount json.Number) (int64, error) {
+	value, ok := new(big.Rat).SetString(amount.String())
+	if !ok {
+		return 0, ErrInvalidAmount
+	}
+	value.Mul(value, big.NewRat(satoshisPerBitcoin, 1))
+	if !value.IsInt() || !value.Num().IsInt64() {
+		return 0, ErrInvalidAmount
+	}
+	return value.Num().Int64(), nil
+}
+
+// convert a BTC/kvB fee rate as printed by bitcoind to sat/vB
+func feeRateToSatPerVByte(rate json.Number) (float64, error) {
+	value, ok := new(big.Rat).SetString(rate.String())
+	if !ok || value.Sign() < 0 {
+		return 0, ErrInvalidAmount
+	}
+	value.Mul(value, big.NewRat(satoshisPerBitcoin, 1000))
+	satPerVByte, _ := value.Float64()
+	return satPerVByte, nil
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrailingNullOmitted(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t)
+
+	// sent the same as the call with one argument
+	for _, arguments := range [][]string{{`"` + testTxID + `"`, `null`}, {`"` + testTxID + `"`}} {
+		_, _, err := RemoteCall("getrawtransaction", rawArguments(arguments...))
+		if nil != err {
+			t.Fatalf("error: %v", err)
+		}
+	}
+	calls := backend.receivedFor("getrawtransaction")
+	if 2 != len(calls) || calls[0] != calls[1] {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestTrailingNullStrict(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t, WithStrictArguments())
+
+	_, _, err := RemoteCall("getblockhash", rawArguments(`8`, `null`))
+	if nil == err {
+		t.Fatal("trailing null accepted")
+	}
+	if calls := backend.receivedFor("getblockhash"); 0 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestTrailingNullSharesCacheEntry(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t)
+
+	setMethodTTL("getblockhash", time.Minute)
+	defer setMethodTTL("getblockhash", 0)
+
+	for _, arguments := range [][]string{{`9`, `null`}, {`9`}, {`9`, `null`, `null`}} {
+		_, _, err := RemoteCall("getblockhash", rawArguments(arguments...))
+		if nil != err {
+			t.Fatalf("error: %v", err)
+		}
+	}
+	if calls := backend.receivedFor("getblockhash"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
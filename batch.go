@@ -0,0 +1,214 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// errors
+var (
+	ErrMissingBatchReply = errors.New("no reply for call in batch")
+)
+
+// calls sent in one batch by helpers that split large requests
+const defaultMaxBatchSize = 1000
+
+// largest number of calls a helper such as VerifyHeightRange puts in
+// one batch, longer requests are split into several batches
+func WithMaxBatchSize(n int) Option {
+	return func(conn *RemoteConnection) {
+		conn.maxBatchSize = n
+	}
+}
+
+// the batch size limit for this connection
+func (conn *RemoteConnection) batchSize() int {
+	if conn.maxBatchSize <= 0 {
+		return defaultMaxBatchSize
+	}
+	return conn.maxBatchSize
+}
+
+// one call of a batch
+type BatchCall struct {
+	Method string
+	Params []interface{}
+}
+
+// the outcome of one call of a batch
+type BatchResult struct {
+	Result json.RawMessage
+	Error  *RPCError // bitcoind rejected the call
+	Err    error     // no reply was received
+}
+
+// send calls as one JSON-RPC batch directly on this connection and
+// collect all the results in call order
+func (conn *RemoteConnection) Batch(ctx context.Context, calls []BatchCall) []BatchResult {
+
+	// buffered, as all the results are kept anyway and the replies
+	// may come in any order
+	results := make([]BatchResult, len(calls))
+	for i, result := range conn.startBatch(ctx, calls, 1) {
+		results[i] = <-result
+	}
+	return results
+}
+
+// send calls as one JSON-RPC batch directly on this connection
+//
+// the reply array is decoded one element at a time and each result is
+// handed over on the channel of its call when it is received, so the
+// decoding keeps pace with the caller and huge batches are never held
+// in memory; the results must be received in the order the replies
+// come, which for bitcoind is call order
+//
+// every channel receives exactly one result, unless ctx is done before
+// it is received, when the channel is closed instead
+func (conn *RemoteConnection) BatchStream(ctx context.Context, calls []BatchCall) []<-chan BatchResult {
+	return conn.startBatch(ctx, calls, 0)
+}
+
+// start a batch delivering on channels with the given buffer
+func (conn *RemoteConnection) startBatch(ctx context.Context, calls []BatchCall, buffer int) []<-chan BatchResult {
+
+	channels := make([]chan BatchResult, len(calls))
+	results := make([]<-chan BatchResult, len(calls))
+	for i := range calls {
+		channels[i] = make(chan BatchResult, buffer)
+		results[i] = channels[i]
+	}
+	if 0 == len(calls) {
+		return results
+	}
+
+	first := conn.nextIDs(uint64(len(calls)))
+	step := conn.idIncrement()
+
+	arguments := make([]bitcoinArguments, len(calls))
+	for i, call := range calls {
+		arguments[i] = bitcoinArguments{
+			ID:         first + uint64(i)*step,
+			Method:     conn.upstreamMethod(call.Method),
+			Parameters: call.Params,
+		}
+	}
+
+	go conn.streamBatch(ctx, first, arguments, channels)
+
+	return results
+}
+
+// hand a result to the caller, false if ctx is done first
+func deliverResult(ctx context.Context, channel chan<- BatchResult, result BatchResult) bool {
+	select {
+	case channel <- result:
+		return true
+	default:
+	}
+	select {
+	case channel <- result:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// run a batch and fail any calls that got no reply
+func (conn *RemoteConnection) streamBatch(ctx context.Context, first uint64, arguments []bitcoinArguments, channels []chan BatchResult) {
+
+	delivered := make([]bool, len(channels))
+	err := conn.ensureBootstrap(ctx)
+	if nil == err {
+		err = conn.decodeBatch(ctx, first, arguments, channels, delivered)
+
+		// as for single calls, a request that was not sent or a reply
+		// that was too large says nothing about the backend
+		if nil == ctx.Err() && !errors.Is(err, ErrRequestTooLarge) && !errors.Is(err, ErrResponseTooLarge) {
+			conn.recordOutcome(err)
+		}
+	}
+	if nil == err {
+		err = ErrMissingBatchReply
+	}
+	for i, done := range delivered {
+		if done {
+			continue
+		}
+		result := BatchResult{
+			Result: jsonNull,
+			Err:    err,
+		}
+		if !deliverResult(ctx, channels[i], result) {
+			close(channels[i])
+		}
+	}
+}
+
+// POST a batch and deliver the replies as they are decoded
+func (conn *RemoteConnection) decodeBatch(ctx context.Context, first uint64, arguments []bitcoinArguments, channels []chan BatchResult, delivered []bool) error {
+
+	response, err := conn.post(ctx, arguments)
+	if nil != err {
+		return err
+	}
+	defer response.Body.Close()
+
+	if http.StatusUnauthorized == response.StatusCode {
+		return ErrAccessDenied
+	}
+	if http.StatusOK != response.StatusCode {
+		return &HTTPError{
+			StatusCode: response.StatusCode,
+			Status:     response.Status,
+		}
+	}
+
+	decoder := json.NewDecoder(conn.limitBody(response))
+	token, err := decoder.Token()
+	if nil != err {
+		return err
+	}
+	if json.Delim('[') != token {
+		return ErrIncomprehesibleResponse
+	}
+
+	for decoder.More() {
+		var reply struct {
+			ID     json.RawMessage `json:"id"`
+			Result json.RawMessage `json:"result"`
+			Error  *RPCError       `json:"error"`
+		}
+		err := decoder.Decode(&reply)
+		if nil != err {
+			return err
+		}
+
+		// ignore replies that do not belong to a call
+		step := conn.idIncrement()
+		id, ok := parseID(reply.ID)
+		if !ok || id < first || 0 != (id-first)%step || (id-first)/step >= uint64(len(channels)) || delivered[(id-first)/step] {
+			continue
+		}
+		i := (id - first) / step
+
+		result := BatchResult{
+			Result: reply.Result,
+			Error:  reply.Error,
+		}
+		if isNull(result.Result) {
+			result.Result = jsonNull
+		}
+		if !deliverResult(ctx, channels[i], result) {
+			return ctx.Err()
+		}
+		delivered[i] = true
+	}
+	return nil
+}
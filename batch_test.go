@@ -0,0 +1,371 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// a backend sending the first reply of a batch at once and the rest
+// only after release is closed
+type streamingBackend struct {
+	*fakeBitcoind
+	release chan struct{}
+}
+
+func newStreamingBackend(t *testing.T) *streamingBackend {
+	s := &streamingBackend{
+		release: make(chan struct{}),
+		fakeBitcoind: newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+			if "getblockhash" == method {
+				return fmt.Sprintf("hash-%s", params[0]), nil
+			}
+			return nil, nil
+		}),
+	}
+	// batches are answered here, single calls by the fake
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Server.Close)
+	t.Cleanup(s.done)
+	return s
+}
+
+func (s *streamingBackend) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	if 0 == len(body) || '[' != body[0] {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		s.fakeBitcoind.serve(w, r)
+		return
+	}
+
+	var requests []fakeRequest
+	json.Unmarshal(body, &requests)
+	w.Write([]byte("["))
+	for i, request := range requests {
+		if 1 == i {
+			w.(http.Flusher).Flush()
+			<-s.release
+		}
+		if 0 != i {
+			w.Write([]byte(","))
+		}
+		reply, _ := s.reply(request)
+		w.Write(reply)
+	}
+	w.Write([]byte("]"))
+}
+
+// let the rest of the batch be sent, safe to call more than once
+func (s *streamingBackend) done() {
+	select {
+	case <-s.release:
+	default:
+		close(s.release)
+	}
+}
+
+func TestBatchStreamIncremental(t *testing.T) {
+	backend := newStreamingBackend(t)
+	conn := backend.connect(t)
+
+	calls := make([]BatchCall, 5000)
+	for i := range calls {
+		calls[i] = BatchCall{Method: "getblockhash", Params: []interface{}{i}}
+	}
+	results := conn.BatchStream(context.Background(), calls)
+	if len(calls) != len(results) {
+		t.Fatalf("%d results", len(results))
+	}
+
+	// the first result arrives while the rest is held back
+	select {
+	case result := <-results[0]:
+		if nil != result.Err || `"hash-0"` != string(result.Result) {
+			t.Errorf("result: %+v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("first result not streamed")
+	}
+	select {
+	case result := <-results[1]:
+		t.Fatalf("second result before it was sent: %+v", result)
+	default:
+	}
+
+	backend.done()
+	for i := 1; i < len(results); i += 1 {
+		result := <-results[i]
+		if nil != result.Err || nil != result.Error || fmt.Sprintf(`"hash-%d"`, i) != string(result.Result) {
+			t.Fatalf("result %d: %+v", i, result)
+		}
+	}
+}
+
+// a backend answering batches with large results, counting the bytes
+// of the reply written so far
+type largeBatchBackend struct {
+	*fakeBitcoind
+	written int64
+}
+
+func newLargeBatchBackend(t *testing.T, size int) *largeBatchBackend {
+	l := &largeBatchBackend{
+		fakeBitcoind: newFakeBitcoind(t, 200000, nil),
+	}
+	result := strings.Repeat("0", size)
+	l.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if 0 == len(body) || '[' != body[0] {
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			l.fakeBitcoind.serve(w, r)
+			return
+		}
+
+		var requests []fakeRequest
+		json.Unmarshal(body, &requests)
+		w.Write([]byte("["))
+		for i, request := range requests {
+			if 0 != i {
+				w.Write([]byte(","))
+			}
+			n, err := fmt.Fprintf(w, `{"id":%s,"error":null,"result":"%s"}`, request.ID, result)
+			if nil != err {
+				return
+			}
+			atomic.AddInt64(&l.written, int64(n))
+		}
+		w.Write([]byte("]"))
+	}))
+	t.Cleanup(l.Server.Close)
+	return l
+}
+
+func TestBatchStreamKeepsPace(t *testing.T) {
+	backend := newLargeBatchBackend(t, 256*1024)
+	conn := backend.connect(t)
+
+	calls := make([]BatchCall, 128)
+	for i := range calls {
+		calls[i] = BatchCall{Method: "getblockhash", Params: []interface{}{i}}
+	}
+	results := conn.BatchStream(context.Background(), calls)
+	if result := <-results[0]; nil != result.Err {
+		t.Fatalf("result: %v", result.Err)
+	}
+
+	// nothing is decoded ahead of the caller, so the reply stalls
+	// once the socket buffers are full
+	time.Sleep(time.Second)
+	total := int64(len(calls) * 256 * 1024)
+	if written := atomic.LoadInt64(&backend.written); written > total/4 {
+		t.Errorf("%d of %d bytes sent before the results were taken", written, total)
+	}
+
+	for i := 1; i < len(results); i += 1 {
+		if result := <-results[i]; nil != result.Err || 256*1024+2 != len(result.Result) {
+			t.Fatalf("result %d: %d bytes  error: %v", i, len(result.Result), result.Err)
+		}
+	}
+}
+
+func TestBatchStreamCancelled(t *testing.T) {
+	backend := newLargeBatchBackend(t, 64*1024)
+	conn := backend.connect(t)
+
+	calls := make([]BatchCall, 400)
+	for i := range calls {
+		calls[i] = BatchCall{Method: "getblockhash", Params: []interface{}{i}}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	results := conn.BatchStream(ctx, calls)
+	if result := <-results[0]; nil != result.Err {
+		t.Fatalf("result: %v", result.Err)
+	}
+	cancel()
+
+	// each channel still ends, with a result or closed, and the rest
+	// of the batch is not read
+	failed := 0
+	for i := 1; i < len(results); i += 1 {
+		select {
+		case result, ok := <-results[i]:
+			if !ok || nil != result.Err {
+				failed += 1
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("channel %d not ended", i)
+		}
+	}
+	if 0 == failed {
+		t.Error("whole batch decoded after cancel")
+	}
+}
+
+func TestBatchMissingReply(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockhash" == method {
+			return "00ff", nil
+		}
+		return nil, nil
+	})
+	// the reply to the second call carries an unknown id
+	replies := 0
+	backend.replyID = func(method string, id json.RawMessage) json.RawMessage {
+		if "getblockhash" != method {
+			return id
+		}
+		replies += 1
+		if 2 == replies {
+			return json.RawMessage("999999")
+		}
+		return id
+	}
+	conn := backend.connect(t)
+
+	results := conn.Batch(context.Background(), []BatchCall{
+		{Method: "getblockhash", Params: []interface{}{1}},
+		{Method: "getblockhash", Params: []interface{}{2}},
+	})
+	if nil != results[0].Err || `"00ff"` != string(results[0].Result) {
+		t.Errorf("result 0: %+v", results[0])
+	}
+	if ErrMissingBatchReply != results[1].Err {
+		t.Errorf("result 1: %+v", results[1])
+	}
+}
+
+func TestBatchRequestTooLarge(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	conn := backend.connect(t, WithMaxRequestSize(4096))
+
+	calls := make([]BatchCall, 200)
+	for i := range calls {
+		calls[i] = BatchCall{Method: "getblockhash", Params: []interface{}{i}}
+	}
+	for attempt := 0; attempt < healthUnavailableFailures; attempt += 1 {
+		for i, result := range conn.Batch(context.Background(), calls) {
+			if !errors.Is(result.Err, ErrRequestTooLarge) {
+				t.Fatalf("result %d: %+v", i, result)
+			}
+		}
+	}
+	if calls := backend.receivedFor("getblockhash"); 0 != len(calls) {
+		t.Errorf("oversized batch sent: %d calls", len(calls))
+	}
+	// refusing to send says nothing about the backend
+	if Healthy != conn.Health() {
+		t.Errorf("health: %v", conn.Health())
+	}
+
+	// a batch within the limit is sent
+	for i, result := range conn.Batch(context.Background(), calls[:10]) {
+		if nil != result.Err {
+			t.Errorf("result %d: %+v", i, result)
+		}
+	}
+}
+
+func TestRequestTooLarge(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t, WithMaxRequestSize(1024))
+
+	_, _, err := RemoteCall("sendrawtransaction", rawArguments(`"`+strings.Repeat("00", 1024)+`"`))
+	if !errors.Is(err, ErrRequestTooLarge) || !strings.Contains(err.Error(), "limit of 1024") {
+		t.Errorf("error: %v  expected: %v", err, ErrRequestTooLarge)
+	}
+	if calls := backend.receivedFor("sendrawtransaction"); 0 != len(calls) {
+		t.Errorf("oversized request sent")
+	}
+}
+
+// a backend sending getpeerinfo replies in chunks without a
+// Content-Length, holding the end of any reply of more than size bytes
+// until release is closed
+type chunkedBackend struct {
+	*fakeBitcoind
+	release chan struct{}
+	size    int
+}
+
+func newChunkedBackend(t *testing.T, size int) *chunkedBackend {
+	c := &chunkedBackend{
+		fakeBitcoind: newFakeBitcoind(t, 200000, nil),
+		release:      make(chan struct{}),
+		size:         size,
+	}
+	c.Server = httptest.NewServer(http.HandlerFunc(c.serve))
+	t.Cleanup(c.Server.Close)
+	t.Cleanup(func() { close(c.release) })
+	return c
+}
+
+func (c *chunkedBackend) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	var request fakeRequest
+	json.Unmarshal(body, &request)
+	if "getpeerinfo" != request.Method {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		c.fakeBitcoind.serve(w, r)
+		return
+	}
+
+	fmt.Fprintf(w, `{"id":%s,"error":null,"result":[`, request.ID)
+	w.(http.Flusher).Flush()
+	sent := 0
+	for i := 0; sent < c.size; i += 1 {
+		if 0 != i {
+			w.Write([]byte(","))
+		}
+		n, _ := fmt.Fprintf(w, `{"id":%d,"addr":"10.0.0.1:8333"}`, i)
+		sent += n + 1
+		w.(http.Flusher).Flush()
+	}
+	if sent > 4096 {
+		<-c.release
+	}
+	w.Write([]byte("]}"))
+}
+
+func TestChunkedResponseTooLarge(t *testing.T) {
+	backend := newChunkedBackend(t, 64*1024)
+	backend.connect(t, WithMaxResponseSize(4096))
+
+	// rejected while the rest of the reply is still held back
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := RemoteCall("getpeerinfo", nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrResponseTooLarge) || !strings.Contains(err.Error(), "limit of 4096") {
+			t.Errorf("error: %v  expected: %v", err, ErrResponseTooLarge)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("oversized chunked reply not abandoned")
+	}
+}
+
+func TestChunkedResponseWithinLimit(t *testing.T) {
+	backend := newChunkedBackend(t, 2048)
+	backend.connect(t, WithMaxResponseSize(4096))
+
+	result, _, err := RemoteCall("getpeerinfo", nil)
+	var peers []map[string]interface{}
+	if nil != err || nil != json.Unmarshal(result, &peers) || 0 == len(peers) {
+		t.Errorf("peers: %d  error: %v", len(peers), err)
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// an HTTP status for a fake backend to reply with instead of JSON
+type httpStatus int
+
+// answers a call to a fake backend, a nil result and error gives the
+// default for the bootstrap methods and null otherwise
+type fakeHandler func(method string, params []json.RawMessage) (interface{}, *RPCError)
+
+// a bitcoind replying from a handler and recording the calls it gets
+type fakeBitcoind struct {
+	*httptest.Server
+	chain   string
+	version uint64
+	handler fakeHandler
+	replyID func(method string, id json.RawMessage) json.RawMessage // if set: the id sent back for a request
+
+	sync.Mutex
+	calls []string
+	paths map[string]bool // request paths seen
+	gzip  int             // requests received gzipped
+	auth  string          // basic auth of the last request as user:password
+}
+
+// start a fake regtest backend of the given version, the chain can be
+// changed before connecting
+func newFakeBitcoind(t *testing.T, version uint64, handler fakeHandler) *fakeBitcoind {
+	f := &fakeBitcoind{
+		chain:   "regtest",
+		version: version,
+		handler: handler,
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// connect to the fake backend, destroying the connection at the end of the test
+func (f *fakeBitcoind) connect(t *testing.T, options ...Option) *RemoteConnection {
+	conn, err := NewRemoteConnection(f.URL, "user", "password", f.chain, nil, options...)
+	if nil != err {
+		t.Fatalf("connect error: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.DestroyWithTimeout(time.Second)
+	})
+	return conn
+}
+
+// the calls received other than the bootstrap, as method and params
+func (f *fakeBitcoind) received() []string {
+	f.Lock()
+	defer f.Unlock()
+	return append([]string{}, f.calls...)
+}
+
+// check if any request was posted to path
+func (f *fakeBitcoind) requested(path string) bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.paths[path]
+}
+
+// the basic auth of the last request
+func (f *fakeBitcoind) credentials() string {
+	f.Lock()
+	defer f.Unlock()
+	return f.auth
+}
+
+// the number of requests received gzipped
+func (f *fakeBitcoind) gzipped() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.gzip
+}
+
+// the calls received for one method
+func (f *fakeBitcoind) receivedFor(method string) []string {
+	calls := []string{}
+	for _, call := range f.received() {
+		if strings.HasPrefix(call, method+"[") {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+type fakeRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+func (f *fakeBitcoind) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	f.Lock()
+	if nil == f.paths {
+		f.paths = make(map[string]bool)
+	}
+	f.paths[r.URL.Path] = true
+	if username, password, ok := r.BasicAuth(); ok {
+		f.auth = username + ":" + password
+	}
+	if "gzip" == r.Header.Get("Content-Encoding") {
+		f.gzip += 1
+		reader, _ := gzip.NewReader(bytes.NewReader(body))
+		body, _ = ioutil.ReadAll(reader)
+	}
+	f.Unlock()
+
+	if 0 != len(body) && '[' == body[0] {
+		var requests []fakeRequest
+		json.Unmarshal(body, &requests)
+		replies := []json.RawMessage{}
+		for _, request := range requests {
+			reply, _ := f.reply(request)
+			replies = append(replies, reply)
+		}
+		buffer, _ := json.Marshal(replies)
+		w.Write(buffer)
+		return
+	}
+
+	var request fakeRequest
+	json.Unmarshal(body, &request)
+	reply, status := f.reply(request)
+	if 0 != status {
+		w.WriteHeader(status)
+		return
+	}
+	w.Write(reply)
+}
+
+// the encoded reply to one request, or an HTTP status
+func (f *fakeBitcoind) reply(request fakeRequest) (json.RawMessage, int) {
+
+	switch request.Method {
+	case "getblockchaininfo", "getinfo", "getnetworkinfo":
+	default:
+		params, _ := json.Marshal(request.Params)
+		if "null" == string(params) {
+			params = []byte("[]")
+		}
+		f.Lock()
+		f.calls = append(f.calls, request.Method+string(params))
+		f.Unlock()
+	}
+
+	var result interface{}
+	var rpcErr *RPCError
+	if nil != f.handler {
+		result, rpcErr = f.handler(request.Method, request.Params)
+	}
+
+	if status, ok := result.(httpStatus); ok {
+		return nil, int(status)
+	}
+	if nil == result && nil == rpcErr {
+		switch request.Method {
+		case "getblockchaininfo":
+			result = map[string]interface{}{"chain": f.chain, "blocks": 100, "pruned": false}
+		case "getinfo":
+			result = map[string]interface{}{"version": f.version, "blocks": 100}
+		case "getnetworkinfo":
+			result = map[string]interface{}{"version": f.version, "subversion": "/Satoshi:test/"}
+		}
+	}
+
+	id := request.ID
+	if nil != f.replyID {
+		id = f.replyID(request.Method, id)
+	}
+	reply := map[string]interface{}{
+		"id":     id,
+		"result": result,
+		"error":  rpcErr,
+	}
+	buffer, _ := json.Marshal(reply)
+	return buffer, 0
+}
+
+// JSON arguments from their encodings
+func rawArguments(arguments ...string) []json.RawMessage {
+	raw := make([]json.RawMessage, len(arguments))
+	for i, argument := range arguments {
+		raw[i] = json.RawMessage(argument)
+	}
+	return raw
+}
@@ -0,0 +1,236 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// getblock takes a verbosity number from this version, before it is a bool
+const bitcoinVerbosityVersion = 150000
+
+// default number of blocks fetched ahead by IterateBlocks
+const defaultBlockPrefetch = 4
+
+// errors
+var (
+	ErrInvalidBits         = errors.New("invalid compact bits")
+	ErrHeightOutOfRange    = errors.New("block height out of range")
+	ErrVerbosityNotAllowed = errors.New("verbosity not allowed")
+)
+
+// decoded block header as returned by verbose getblockheader
+//
+// Target is not part of the reply, it is expanded from Bits
+type BlockHeader struct {
+	Hash              string   `json:"hash"`
+	Confirmations     int64    `json:"confirmations"`
+	Height            uint64   `json:"height"`
+	Version           int32    `json:"version"`
+	VersionHex        string   `json:"versionHex"`
+	MerkleRoot        string   `json:"merkleroot"`
+	Time              int64    `json:"time"`
+	MedianTime        int64    `json:"mediantime"`
+	Nonce             uint32   `json:"nonce"`
+	Bits              string   `json:"bits"`
+	Difficulty        float64  `json:"difficulty"`
+	ChainWork         string   `json:"chainwork"`
+	NTx               uint64   `json:"nTx"`
+	PreviousBlockHash string   `json:"previousblockhash"`
+	NextBlockHash     string   `json:"nextblockhash"`
+	Target            *big.Int `json:"-"`
+}
+
+// fetch a decoded block header with its expanded target
+func GetBlockHeaderVerbose(hash string) (*BlockHeader, error) {
+
+	h, err := json.Marshal(hash)
+	if nil != err {
+		return nil, err
+	}
+
+	result, rpcErr, err := RemoteCall("getblockheader", []json.RawMessage{h, json.RawMessage("true")})
+	if nil != err {
+		return nil, err
+	}
+
+	var header BlockHeader
+	err = decodeResult(result, rpcErr, &header)
+	if nil != err {
+		return nil, err
+	}
+
+	header.Target, err = compactToTarget(header.Bits)
+	if nil != err {
+		return nil, err
+	}
+	return &header, nil
+}
+
+// expand the compact hex bits of a header to the 256 bit target
+//
+// bits are an exponent byte followed by a 23 bit mantissa and a sign bit,
+// target = mantissa * 256^(exponent-3); negative values are invalid
+func compactToTarget(bits string) (*big.Int, error) {
+
+	compact, err := strconv.ParseUint(bits, 16, 32)
+	if nil != err {
+		return nil, ErrInvalidBits
+	}
+	if 0 != compact&0x00800000 {
+		return nil, ErrInvalidBits
+	}
+
+	exponent := uint(compact >> 24)
+	mantissa := compact & 0x007fffff
+
+	if exponent <= 3 {
+		return new(big.Int).SetUint64(mantissa >> (8 * (3 - exponent))), nil
+	}
+	target := new(big.Int).SetUint64(mantissa)
+	return target.Lsh(target, 8*(exponent-3)), nil
+}
+
+// reject getblock calls from clients asking for a verbosity above n,
+// e.g. 1 to forbid the large decoded transactions of verbosity 2
+func WithMaxBlockVerbosity(n uint64) Option {
+	return func(conn *RemoteConnection) {
+		conn.maxBlockVerbosity = n
+		conn.limitBlockVerbosity = true
+	}
+}
+
+// check a requested getblock verbosity against the limit
+func (conn *RemoteConnection) checkBlockVerbosity(verbosity uint64) error {
+	if conn.limitBlockVerbosity && verbosity > conn.maxBlockVerbosity {
+		return fmt.Errorf("%w: getblock verbosity %d is above the maximum of %d", ErrVerbosityNotAllowed, verbosity, conn.maxBlockVerbosity)
+	}
+	return nil
+}
+
+// the wire form of a getblock verbosity, a bool before it was a number
+func (conn *RemoteConnection) verbosityFlag(verbosity uint64) interface{} {
+	if conn.version < bitcoinVerbosityVersion {
+		return 0 != verbosity
+	}
+	return verbosity
+}
+
+// fetch a block by hash directly from this connection
+func (conn *RemoteConnection) getBlock(ctx context.Context, hash string, verbosity int) (json.RawMessage, error) {
+
+	var block json.RawMessage
+	err := conn.call(ctx, "getblock", []interface{}{hash, conn.verbosityFlag(uint64(verbosity))}, &block)
+	if nil != err {
+		return nil, err
+	}
+	if 0 != verbosity {
+		err = conn.verifyHash(block, "hash", hash)
+		if nil != err {
+			return nil, err
+		}
+	}
+	return block, nil
+}
+
+// fetch the block at a height, looking up its hash first
+func (conn *RemoteConnection) GetBlockByHeight(ctx context.Context, height uint64, verbosity int) (json.RawMessage, error) {
+
+	hash, err := conn.blockHash(ctx, height)
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) && rpcInvalidParameter == rpcErr.Code {
+		return nil, ErrHeightOutOfRange
+	} else if nil != err {
+		return nil, err
+	}
+	return conn.getBlock(ctx, hash, verbosity)
+}
+
+// one block from IterateBlocks, Err is set on the last result if fetching failed
+type BlockResult struct {
+	Height uint64
+	Hash   string
+	Block  json.RawMessage
+	Err    error
+}
+
+// number of blocks IterateBlocks fetches ahead of the reader
+func WithBlockPrefetch(n int) Option {
+	return func(conn *RemoteConnection) {
+		conn.blockPrefetch = n
+	}
+}
+
+// stream the blocks from start to end inclusive in height order
+//
+// blocks are fetched concurrently a bounded number ahead of the reader;
+// the channel is closed after the last block, after an error result,
+// or once the context is done or the returned stop function is called
+func (conn *RemoteConnection) IterateBlocks(ctx context.Context, start uint64, end uint64, verbosity int) (<-chan BlockResult, func()) {
+
+	prefetch := conn.blockPrefetch
+	if prefetch <= 0 {
+		prefetch = defaultBlockPrefetch
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	results := make(chan BlockResult)
+
+	// fetches in flight, in height order
+	pending := make(chan chan BlockResult, prefetch)
+
+	go func() {
+		defer close(pending)
+		for height := start; height <= end; height += 1 {
+			fetched := make(chan BlockResult, 1)
+			select {
+			case pending <- fetched:
+			case <-ctx.Done():
+				return
+			}
+			go func(height uint64) {
+				result := BlockResult{
+					Height: height,
+				}
+				result.Hash, result.Err = conn.blockHash(ctx, height)
+				if nil == result.Err {
+					result.Block, result.Err = conn.getBlock(ctx, result.Hash, verbosity)
+				}
+				fetched <- result
+			}(height)
+			if height == end {
+				return // avoid overflow at the maximum height
+			}
+		}
+	}()
+
+	go func() {
+		defer close(results)
+		defer cancel()
+		for fetched := range pending {
+			var result BlockResult
+			select {
+			case result = <-fetched:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case results <- result:
+			case <-ctx.Done():
+				return
+			}
+			if nil != result.Err {
+				return
+			}
+		}
+	}()
+
+	return results, cancel
+}
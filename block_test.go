@@ -0,0 +1,266 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the mainnet genesis block header
+const (
+	genesisHash   = "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+	genesisHeader = `{
+  "hash": "` + genesisHash + `",
+  "confirmations": 1, "height": 0, "version": 1, "versionHex": "00000001",
+  "merkleroot": "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b",
+  "time": 1231006505, "mediantime": 1231006505, "nonce": 2083236893,
+  "bits": "1d00ffff", "difficulty": 1,
+  "chainwork": "0000000000000000000000000000000000000000000000000000000100010001",
+  "nTx": 1
+}`
+)
+
+func TestGetBlockHeaderVerbose(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockheader" == method {
+			return json.RawMessage(genesisHeader), nil
+		}
+		return nil, nil
+	})
+	backend.connect(t)
+
+	header, err := GetBlockHeaderVerbose(genesisHash)
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if genesisHash != header.Hash || 0 != header.Height || 2083236893 != header.Nonce || 1 != header.NTx {
+		t.Errorf("header: %+v", header)
+	}
+	if "ffff0000000000000000000000000000000000000000000000000000" != header.Target.Text(16) {
+		t.Errorf("target: %064x", header.Target)
+	}
+	if calls := backend.receivedFor("getblockheader"); 1 != len(calls) || `getblockheader["`+genesisHash+`",true]` != calls[0] {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestCompactToTarget(t *testing.T) {
+	for bits, expected := range map[string]string{
+		"1d00ffff": "ffff0000000000000000000000000000000000000000000000000000",
+		"1b0404cb": "404cb000000000000000000000000000000000000000000000000",
+		"207fffff": "7fffff0000000000000000000000000000000000000000000000000000000000",
+		"03123456": "123456",
+		"02123456": "1234",
+		"01123456": "12",
+	} {
+		target, err := compactToTarget(bits)
+		if nil != err {
+			t.Errorf("%s error: %v", bits, err)
+			continue
+		}
+		if expected != target.Text(16) {
+			t.Errorf("%s target: %s  expected: %s", bits, target.Text(16), expected)
+		}
+	}
+
+	for _, bits := range []string{"1d80ffff", "xyz", "1d00ffff00"} {
+		_, err := compactToTarget(bits)
+		if !errors.Is(err, ErrInvalidBits) {
+			t.Errorf("%s error: %v  expected: %v", bits, err, ErrInvalidBits)
+		}
+	}
+}
+
+// a backend with a block at every height up to tip, answering out of order
+func numberedChainBackend(t *testing.T, tip uint64) *fakeBitcoind {
+	return newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		var height uint64
+		switch method {
+		case "getblockhash":
+			json.Unmarshal(params[0], &height)
+			if height > tip {
+				return nil, &RPCError{Code: rpcInvalidParameter, Message: "Block height out of range"}
+			}
+		case "getblock":
+			var hash string
+			json.Unmarshal(params[0], &hash)
+			fmt.Sscanf(hash, "%x", &height)
+		default:
+			return nil, nil
+		}
+		time.Sleep(time.Duration(height*7%5) * time.Millisecond)
+		hash := fmt.Sprintf("%064x", height)
+		if "getblockhash" == method {
+			return hash, nil
+		}
+		return map[string]interface{}{"hash": hash, "height": height}, nil
+	})
+}
+
+// read the rest of an iteration, failing if it is not closed promptly
+func drainBlocks(t *testing.T, blocks <-chan BlockResult) int {
+	count := 0
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-blocks:
+			if !ok {
+				return count
+			}
+			count += 1
+		case <-timeout:
+			t.Fatalf("iteration not closed")
+		}
+	}
+}
+
+func TestIterateBlocksInOrder(t *testing.T) {
+	backend := numberedChainBackend(t, 1000)
+	conn := backend.connect(t, WithBlockPrefetch(3))
+
+	blocks, stop := conn.IterateBlocks(context.Background(), 5, 14, 1)
+	defer stop()
+
+	expected := uint64(5)
+	for result := range blocks {
+		if nil != result.Err {
+			t.Fatalf("height %d error: %v", result.Height, result.Err)
+		}
+		var block struct {
+			Height uint64 `json:"height"`
+		}
+		json.Unmarshal(result.Block, &block)
+		if expected != result.Height || expected != block.Height || fmt.Sprintf("%064x", expected) != result.Hash {
+			t.Errorf("result: %d %s  block: %s  expected: %d", result.Height, result.Hash, result.Block, expected)
+		}
+		expected += 1
+	}
+	if 15 != expected {
+		t.Errorf("iteration ended before height %d", expected)
+	}
+}
+
+func TestIterateBlocksStop(t *testing.T) {
+	backend := numberedChainBackend(t, 1000)
+	conn := backend.connect(t, WithBlockPrefetch(2))
+
+	blocks, stop := conn.IterateBlocks(context.Background(), 0, 1000, 1)
+	for i := uint64(0); i < 3; i += 1 {
+		if result := <-blocks; i != result.Height || nil != result.Err {
+			t.Fatalf("result: %+v", result)
+		}
+	}
+	stop()
+	drainBlocks(t, blocks)
+
+	// fetches already sent may still arrive, but no new ones start
+	time.Sleep(50 * time.Millisecond)
+	fetched := len(backend.receivedFor("getblockhash"))
+	time.Sleep(50 * time.Millisecond)
+	if later := len(backend.receivedFor("getblockhash")); later != fetched || later > 8 {
+		t.Errorf("fetched %d then %d blocks", fetched, later)
+	}
+}
+
+func TestIterateBlocksContext(t *testing.T) {
+	backend := numberedChainBackend(t, 1000)
+	conn := backend.connect(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	blocks, stop := conn.IterateBlocks(ctx, 0, 1000, 1)
+	defer stop()
+	<-blocks
+	cancel()
+	if count := drainBlocks(t, blocks); count > defaultBlockPrefetch+1 {
+		t.Errorf("%d blocks after cancel", count)
+	}
+}
+
+func TestGetBlockByHeight(t *testing.T) {
+	backend := numberedChainBackend(t, 100)
+	conn := backend.connect(t)
+
+	for i := 0; i < 2; i += 1 {
+		block, err := conn.GetBlockByHeight(context.Background(), 10, 1)
+		if nil != err {
+			t.Fatalf("error: %v", err)
+		}
+		var decoded struct {
+			Hash   string `json:"hash"`
+			Height uint64 `json:"height"`
+		}
+		json.Unmarshal(block, &decoded)
+		if 10 != decoded.Height || fmt.Sprintf("%064x", 10) != decoded.Hash {
+			t.Errorf("block: %s", block)
+		}
+	}
+
+	// the second lookup used the cached hash
+	if calls := backend.receivedFor("getblockhash"); 1 != len(calls) {
+		t.Errorf("getblockhash calls: %v", calls)
+	}
+	if calls := backend.receivedFor("getblock"); 2 != len(calls) {
+		t.Errorf("getblock calls: %v", calls)
+	}
+
+	_, err := conn.GetBlockByHeight(context.Background(), 101, 1)
+	if !errors.Is(err, ErrHeightOutOfRange) {
+		t.Errorf("error: %v  expected: %v", err, ErrHeightOutOfRange)
+	}
+	if calls := backend.receivedFor("getblock"); 2 != len(calls) {
+		t.Errorf("getblock calls: %v", calls)
+	}
+}
+
+func TestMaxBlockVerbosity(t *testing.T) {
+	for _, item := range []struct {
+		max       uint64
+		arguments []string
+		allowed   bool
+	}{
+		{1, []string{`"` + genesisHash + `"`}, true}, // defaults to 1
+		{1, []string{`"` + genesisHash + `"`, `0`}, true},
+		{1, []string{`"` + genesisHash + `"`, `1`}, true},
+		{1, []string{`"` + genesisHash + `"`, `true`}, true},
+		{1, []string{`"` + genesisHash + `"`, `2`}, false},
+		{1, []string{`"` + genesisHash + `"`, `3`}, false},
+		{0, []string{`"` + genesisHash + `"`}, false},
+		{0, []string{`"` + genesisHash + `"`, `false`}, true},
+	} {
+		t.Run(fmt.Sprint(item.max, item.arguments[1:]), func(t *testing.T) {
+			backend := genesisBackend(t)
+			conn := backend.connect(t, WithMaxBlockVerbosity(item.max))
+
+			_, _, err := conn.Do(context.Background(), "getblock", rawArguments(item.arguments...))
+			if item.allowed {
+				if nil != err {
+					t.Errorf("error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrVerbosityNotAllowed) || !strings.Contains(err.Error(), fmt.Sprintf("maximum of %d", item.max)) {
+				t.Errorf("error: %v  expected: %v", err, ErrVerbosityNotAllowed)
+			}
+			if calls := backend.receivedFor("getblock"); 0 != len(calls) {
+				t.Errorf("rejected call forwarded: %v", calls)
+			}
+		})
+	}
+}
+
+func TestBlockVerbosityUnlimited(t *testing.T) {
+	backend := genesisBackend(t)
+	conn := backend.connect(t)
+
+	if _, _, err := conn.Do(context.Background(), "getblock", rawArguments(`"`+genesisHash+`"`, `2`)); nil != err {
+		t.Errorf("error: %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// a backend answering only getblockchaininfo, for the wrong chain
+func wrongChain(method string, params []json.RawMessage) (interface{}, *RPCError) {
+	if "getblockchaininfo" == method {
+		return map[string]interface{}{"chain": "main", "blocks": 100}, nil
+	}
+	return nil, nil
+}
+
+func TestLazyBootstrapDirectHelpers(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, wrongChain)
+	ctx := context.Background()
+
+	helpers := map[string]func(conn *RemoteConnection) error{
+		"ChainInfo": func(conn *RemoteConnection) error {
+			_, err := conn.ChainInfo(ctx)
+			return err
+		},
+		"GetTxOut": func(conn *RemoteConnection) error {
+			_, err := conn.GetTxOut(ctx, "00", 0, true)
+			return err
+		},
+		"SendRawTransaction": func(conn *RemoteConnection) error {
+			_, err := conn.SendRawTransaction(ctx, "00", 0.1)
+			return err
+		},
+		"SubmitWithParents": func(conn *RemoteConnection) error {
+			return conn.SubmitWithParents(ctx, "00", []string{"01"})
+		},
+		"VerifyHeightRange": func(conn *RemoteConnection) error {
+			_, err := conn.VerifyHeightRange(ctx, 1, 10)
+			return err
+		},
+		"BatchStream": func(conn *RemoteConnection) error {
+			results := conn.BatchStream(ctx, []BatchCall{{Method: "getblockhash", Params: []interface{}{1}}})
+			return (<-results[0]).Err
+		},
+	}
+	for name, helper := range helpers {
+		conn := backend.connect(t, WithLazyBootstrap())
+		err := helper(conn)
+		if !errors.Is(err, ErrInvalidBitcoinChain) {
+			t.Errorf("%s: error: %v  expected: %v", name, err, ErrInvalidBitcoinChain)
+		}
+	}
+	if calls := backend.received(); 0 != len(calls) {
+		t.Errorf("calls sent to the wrong chain: %v", calls)
+	}
+}
+
+func TestLazyBootstrapSetsVersion(t *testing.T) {
+	backend := newFakeBitcoind(t, 150000, nil)
+	conn := backend.connect(t, WithLazyBootstrap())
+
+	// below bitcoinMaxFeeRateVersion the fee rate must not be sent
+	_, err := conn.SendRawTransaction(context.Background(), "00", 0.1)
+	if nil != err {
+		t.Fatalf("send error: %v", err)
+	}
+	calls := backend.receivedFor("sendrawtransaction")
+	if 1 != len(calls) || `sendrawtransaction["00"]` != calls[0] {
+		t.Errorf("sent: %v", calls)
+	}
+}
+
+func TestLazyWarmupWaitsForFirstCall(t *testing.T) {
+	var opened int64
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.Close()
+	backend.Server = httptest.NewUnstartedServer(http.HandlerFunc(backend.serve))
+	backend.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if http.StateNew == state {
+			atomic.AddInt64(&opened, 1)
+		}
+	}
+	backend.Start()
+	t.Cleanup(backend.Close)
+
+	conn := backend.connect(t, WithLazyBootstrap(), WithWarmup(4))
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt64(&opened); 0 != n {
+		t.Fatalf("%d connections opened before the first call", n)
+	}
+
+	_, err := conn.ChainInfo(context.Background())
+	if nil != err {
+		t.Fatalf("chain info error: %v", err)
+	}
+	if n := atomic.LoadInt64(&opened); n < 4 {
+		t.Errorf("only %d connections opened", n)
+	}
+}
+
+func TestConnectContextCancelled(t *testing.T) {
+	backend := newHeldBackend(t, "getblockchaininfo")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		conn, err := NewRemoteConnectionContext(ctx, backend.URL, "user", "password", "regtest", nil)
+		if nil == err {
+			conn.Destroy()
+		}
+		done <- err
+	}()
+
+	eventually(t, "the bootstrap probe to start", func() bool {
+		running, _ := backend.counts()
+		return 1 == running
+	})
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("error: %v  expected: %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("construction not abandoned")
+	}
+}
+
+func TestConnectContextDeadline(t *testing.T) {
+	backend := newHeldBackend(t, "getblockchaininfo")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := NewRemoteConnectionContext(ctx, backend.URL, "user", "password", "regtest", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error: %v  expected: %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"sync"
+)
+
+// reusable buffers for encoding request bodies
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buffer *bytes.Buffer) {
+	buffer.Reset()
+	bufferPool.Put(buffer)
+}
+
+// a request body backed by a pooled buffer
+//
+// the transport may still be sending the body after the response
+// has arrived, so the buffer is only released when the body is closed
+type pooledBody struct {
+	*bytes.Reader
+	buffer *bytes.Buffer
+	once   sync.Once
+}
+
+func newPooledBody(buffer *bytes.Buffer) *pooledBody {
+	return &pooledBody{
+		Reader: bytes.NewReader(buffer.Bytes()),
+		buffer: buffer,
+	}
+}
+
+func (body *pooledBody) Close() error {
+	body.once.Do(func() {
+		putBuffer(body.buffer)
+	})
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// a backend echoing the hex string of decoderawtransaction
+func echoBackend(t *testing.T) *fakeBitcoind {
+	return newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "decoderawtransaction" == method && 1 == len(params) {
+			return params[0], nil
+		}
+		return nil, nil
+	})
+}
+
+func TestPooledBuffersConcurrent(t *testing.T) {
+	for _, item := range []struct {
+		name    string
+		options []Option
+	}{
+		{"plain", nil},
+		{"compressed", []Option{WithCompressRequests()}},
+	} {
+		t.Run(item.name, func(t *testing.T) {
+			backend := echoBackend(t)
+			conn := backend.connect(t, item.options...)
+
+			var wg sync.WaitGroup
+			errs := make(chan error, 16)
+			for i := 0; i < 16; i += 1 {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < 20; j += 1 {
+						// sizes either side of the compression threshold
+						hex := strings.Repeat(fmt.Sprintf("%02x", i*20+j), 1000+(i*20+j)*20)
+						argument := `"` + hex + `"`
+						reply, _, err := conn.Do(context.Background(), "decoderawtransaction", rawArguments(argument))
+						if nil != err {
+							errs <- err
+							return
+						}
+						if argument != string(reply) {
+							errs <- fmt.Errorf("goroutine %d call %d: reply of %d bytes for %d", i, j, len(reply), len(argument))
+							return
+						}
+					}
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestPooledBodyClose(t *testing.T) {
+	buffer := getBuffer()
+	buffer.WriteString(`{"method":"getblockcount"}`)
+	body := newPooledBody(buffer)
+
+	data, err := ioutil.ReadAll(body)
+	if nil != err || `{"method":"getblockcount"}` != string(data) {
+		t.Errorf("body: %q  error: %v", data, err)
+	}
+	body.Close()
+	body.Close()
+	if 0 != buffer.Len() {
+		t.Errorf("buffer not reset: %q", buffer.String())
+	}
+}
+
+// a request the size of a typical transaction submission
+var benchmarkArguments = &bitcoinArguments{
+	ID:         1,
+	Method:     "sendrawtransaction",
+	Parameters: []interface{}{strings.Repeat("00", 2000)},
+}
+
+// the request as encoded before the pool
+func BenchmarkEncodeMarshal(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i += 1 {
+		s, err := json.Marshal(benchmarkArguments)
+		if nil != err {
+			b.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, bytes.NewBuffer(s))
+	}
+}
+
+func BenchmarkEncodePooled(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i += 1 {
+		buffer := getBuffer()
+		err := json.NewEncoder(buffer).Encode(benchmarkArguments)
+		if nil != err {
+			b.Fatal(err)
+		}
+		body := newPooledBody(buffer)
+		io.Copy(ioutil.Discard, body)
+		body.Close()
+	}
+}
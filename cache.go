@@ -0,0 +1,339 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TTL for results that never change, e.g. getblock by hash
+	CacheForever time.Duration = math.MaxInt64
+
+	defaultCacheMemory = 64 << 20 // bytes
+	cacheEntryOverhead = 64       // approximate bytes per entry beyond key and result
+)
+
+// a cached successful result, a zero expiry never expires
+type cacheEntry struct {
+	key     string
+	result  json.RawMessage
+	expires time.Time
+}
+
+// approximate memory used by an entry
+func (entry *cacheEntry) size() int64 {
+	return int64(len(entry.key) + len(entry.result) + cacheEntryOverhead)
+}
+
+// read-through cache of RemoteCall results shared by all connections
+// and by the helpers, least recently used entries are evicted to keep
+// the total size within the memory limit
+var responseCache = struct {
+	sync.Mutex
+	ttls    map[string]time.Duration
+	entries map[string]*list.Element
+	lru     *list.List // of *cacheEntry, most recent at the front
+	size    int64
+	limit   int64
+	fold    bool // lowercase hex arguments in keys
+}{
+	ttls:    make(map[string]time.Duration),
+	entries: make(map[string]*list.Element),
+	lru:     list.New(),
+	limit:   defaultCacheMemory,
+	fold:    true,
+}
+
+// set the approximate number of bytes all cached results may use
+func SetCacheMemoryLimit(limit int64) {
+	responseCache.Lock()
+	defer responseCache.Unlock()
+
+	responseCache.limit = limit
+	evictCache()
+}
+
+// cache successful results of method for ttl, CacheForever for
+// immutable results and zero to stop caching; methods not set
+// are not cached
+//
+// the cache is shared by all connections, so the TTL applies to calls
+// served by any of them and stays after this one is destroyed
+func WithMethodTTL(method string, ttl time.Duration) Option {
+	return func(conn *RemoteConnection) {
+		setMethodTTL(method, ttl)
+	}
+}
+
+// replace the cache TTL of a method
+func setMethodTTL(method string, ttl time.Duration) {
+	responseCache.Lock()
+	defer responseCache.Unlock()
+
+	if ttl <= 0 {
+		delete(responseCache.ttls, method)
+		return
+	}
+	responseCache.ttls[method] = ttl
+}
+
+// whether hex string arguments that differ only in case share a
+// cache entry, enabled by default
+func SetCacheFoldHex(fold bool) {
+	responseCache.Lock()
+	defer responseCache.Unlock()
+	responseCache.fold = fold
+}
+
+type cacheBypassKey struct{}
+
+// calls made with the context skip the cache lookup,
+// successful results still replace any cached ones
+func WithCacheBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassKey{}, true)
+}
+
+// check if a context asks to skip the cache
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(cacheBypassKey{}).(bool)
+	return bypass
+}
+
+// the cache TTL of a method, zero if not cached
+func methodTTL(method string) time.Duration {
+	responseCache.Lock()
+	defer responseCache.Unlock()
+	return responseCache.ttls[method]
+}
+
+// the cache key for a call
+//
+// trailing nulls are dropped, arguments are compacted, objects have
+// their keys sorted and (optionally) hex strings are lowercased so that
+// equivalent calls share a key, the arguments sent are not changed
+func callKey(method string, arguments []json.RawMessage) string {
+	responseCache.Lock()
+	fold := responseCache.fold
+	responseCache.Unlock()
+
+	arguments = trimNulls(arguments)
+
+	var buffer bytes.Buffer
+	buffer.WriteString(method)
+	for _, argument := range arguments {
+		buffer.WriteByte(0)
+		buffer.Write(canonicalJSON(argument, fold))
+	}
+	return buffer.String()
+}
+
+// canonical encoding of a JSON value
+func canonicalJSON(value json.RawMessage, fold bool) []byte {
+
+	trimmed := bytes.TrimSpace(value)
+	if 0 == len(trimmed) {
+		return trimmed
+	}
+	if '{' != trimmed[0] && '[' != trimmed[0] {
+		quoted := len(trimmed) >= 2 && '"' == trimmed[0] && '"' == trimmed[len(trimmed)-1]
+		if fold && quoted && isHexString(trimmed[1:len(trimmed)-1]) {
+			return bytes.ToLower(trimmed)
+		}
+		return trimmed
+	}
+
+	// re-encoding a decoded map sorts its keys at every level
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.UseNumber()
+	var decoded interface{}
+	if nil != decoder.Decode(&decoded) {
+		return trimmed
+	}
+	if fold {
+		decoded = foldHex(decoded)
+	}
+	canonical, err := json.Marshal(decoded)
+	if nil != err {
+		return trimmed
+	}
+	return canonical
+}
+
+// lowercase every hex string within a decoded value
+func foldHex(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if isHexString([]byte(v)) {
+			return strings.ToLower(v)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = foldHex(item)
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = foldHex(item)
+		}
+	}
+	return value
+}
+
+// check for a non-empty even length string of hex digits
+func isHexString(s []byte) bool {
+	if 0 == len(s) || 0 != len(s)%2 {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+		case c >= 'a' && c <= 'f':
+		case c >= 'A' && c <= 'F':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// find an unexpired result
+func cacheLookup(key string) (json.RawMessage, bool) {
+	responseCache.Lock()
+	defer responseCache.Unlock()
+
+	element, ok := responseCache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		removeCacheElement(element)
+		return nil, false
+	}
+	responseCache.lru.MoveToFront(element)
+	return entry.result, true
+}
+
+// save a result
+func cacheStore(key string, result json.RawMessage, ttl time.Duration) {
+	responseCache.Lock()
+	defer responseCache.Unlock()
+
+	if element, ok := responseCache.entries[key]; ok {
+		removeCacheElement(element)
+	}
+
+	entry := &cacheEntry{
+		key:    key,
+		result: result,
+	}
+	if CacheForever != ttl {
+		entry.expires = time.Now().Add(ttl)
+	}
+	if entry.size() > responseCache.limit {
+		return
+	}
+
+	responseCache.entries[key] = responseCache.lru.PushFront(entry)
+	responseCache.size += entry.size()
+	evictCache()
+}
+
+// drop least recently used entries until within the limit
+// only call while the cache is locked
+func evictCache() {
+	for responseCache.size > responseCache.limit {
+		element := responseCache.lru.Back()
+		if nil == element {
+			return
+		}
+		removeCacheElement(element)
+	}
+}
+
+// only call while the cache is locked
+func removeCacheElement(element *list.Element) {
+	entry := responseCache.lru.Remove(element).(*cacheEntry)
+	delete(responseCache.entries, entry.key)
+	responseCache.size -= entry.size()
+}
+
+// a cache entry as written by ExportCache, one JSON object per line
+type cacheDumpEntry struct {
+	Key    string          `json:"key"`
+	Result json.RawMessage `json:"result"`
+}
+
+// write the cached results that never expire, e.g. blocks by hash,
+// so that a restarted service can reload them with ImportCache
+//
+// the cache is shared so this includes results from all connections
+func (conn *RemoteConnection) ExportCache(w io.Writer) error {
+
+	responseCache.Lock()
+	entries := make([]cacheDumpEntry, 0, len(responseCache.entries))
+	for element := responseCache.lru.Back(); nil != element; element = element.Prev() {
+		entry := element.Value.(*cacheEntry)
+		if entry.expires.IsZero() {
+			entries = append(entries, cacheDumpEntry{
+				Key:    entry.key,
+				Result: entry.result,
+			})
+		}
+	}
+	responseCache.Unlock()
+
+	// least recently used first so that importing keeps the order
+	encoder := json.NewEncoder(w)
+	for _, entry := range entries {
+		err := encoder.Encode(entry)
+		if nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// load results written by ExportCache
+//
+// corrupt entries and entries for methods not currently cached
+// forever are skipped
+func (conn *RemoteConnection) ImportCache(r io.Reader) error {
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if 0 != len(bytes.TrimSpace(line)) {
+			importCacheEntry(line)
+		}
+		if io.EOF == err {
+			return nil
+		} else if nil != err {
+			return err
+		}
+	}
+}
+
+// check and store one exported entry
+func importCacheEntry(line []byte) {
+	var entry cacheDumpEntry
+	if nil != json.Unmarshal(line, &entry) || !json.Valid(entry.Result) {
+		return
+	}
+	method, _, _ := strings.Cut(entry.Key, "\x00")
+	if CacheForever != methodTTL(method) {
+		return
+	}
+	cacheStore(entry.Key, entry.Result, CacheForever)
+}
@@ -0,0 +1,271 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallKeyMalformedArguments(t *testing.T) {
+	for _, argument := range []string{`"`, `"ab`, `ab"`, ` " `, `{`, `[`, ``} {
+		for _, fold := range []bool{true, false} {
+			canonicalJSON(json.RawMessage(argument), fold)
+		}
+		callKey("getblock", rawArguments(argument))
+	}
+}
+
+func TestCallKeyFoldsHex(t *testing.T) {
+	if callKey("getblock", rawArguments(`"ABCD"`)) != callKey("getblock", rawArguments(`"abcd"`)) {
+		t.Error("hex case not folded")
+	}
+	if callKey("getblock", rawArguments(`{"b":1,"a":2}`)) != callKey("getblock", rawArguments(`{"a":2, "b":1}`)) {
+		t.Error("object keys not sorted")
+	}
+}
+
+func TestCallKeyNestedObjects(t *testing.T) {
+	key := callKey("scanblocks", rawArguments(`"start"`, `[{"desc":"addr(x)","range":[0,10]}]`, `1`, `2`, `"basic"`, `{"filter_false_positives":true}`))
+	same := callKey("scanblocks", rawArguments(`"start"`, ` [ { "range": [0, 10], "desc": "addr(x)" } ]`, `1`, `2`, `"basic"`, `{"filter_false_positives": true}`))
+	if key != same {
+		t.Errorf("equivalent arguments: %q and %q", key, same)
+	}
+
+	// array order and values still count
+	for _, scanObjects := range []string{`[{"desc":"addr(x)","range":[10,0]}]`, `[{"desc":"addr(y)","range":[0,10]}]`} {
+		if other := callKey("scanblocks", rawArguments(`"start"`, scanObjects, `1`, `2`, `"basic"`, `{"filter_false_positives":true}`)); key == other {
+			t.Errorf("%s has the same key", scanObjects)
+		}
+	}
+}
+
+func TestCachedObjectArgumentsForwarded(t *testing.T) {
+	emptyCache(t)
+	backend := newFakeBitcoind(t, 250000, nil)
+	backend.connect(t, WithMethodTTL("scanblocks", time.Minute))
+	t.Cleanup(func() {
+		setMethodTTL("scanblocks", 0)
+	})
+
+	for _, scanObjects := range []string{`[{"range":10,"desc":"addr(x)"}]`, `[{"desc":"addr(x)","range":10}]`} {
+		_, _, err := RemoteCall("scanblocks", rawArguments(`"start"`, scanObjects))
+		if nil != err {
+			t.Fatalf("error: %v", err)
+		}
+	}
+
+	// the first call went out as given and the second was a cache hit
+	expected := `scanblocks["start",[{"range":10,"desc":"addr(x)"}]]`
+	if calls := backend.receivedFor("scanblocks"); 1 != len(calls) || expected != calls[0] {
+		t.Errorf("forwarded: %v  expected: %s", calls, expected)
+	}
+}
+
+func TestCachedMethodMalformedArgument(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t)
+
+	setMethodTTL("getblock", time.Minute)
+	defer setMethodTTL("getblock", 0)
+
+	_, _, err := RemoteCall("getblock", rawArguments(`"`))
+	if nil == err {
+		t.Fatal("malformed argument accepted")
+	}
+	if calls := backend.receivedFor("getblock"); 0 != len(calls) {
+		t.Errorf("malformed argument forwarded: %v", calls)
+	}
+}
+
+func TestMethodTTLs(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t,
+		WithMethodTTL("getblockhash", 50*time.Millisecond),
+		WithMethodTTL("getblock", CacheForever),
+	)
+	t.Cleanup(func() {
+		setMethodTTL("getblockhash", 0)
+		setMethodTTL("getblock", 0)
+	})
+
+	hash := `"` + testTxID + `"`
+	call := func() {
+		for _, method := range []string{"getblockhash", "getblock", "getpeerinfo"} {
+			arguments := rawArguments(hash)
+			switch method {
+			case "getblockhash":
+				arguments = rawArguments(`11`)
+			case "getpeerinfo":
+				arguments = nil
+			}
+			_, _, err := RemoteCall(method, arguments)
+			if nil != err {
+				t.Fatalf("%s error: %v", method, err)
+			}
+		}
+	}
+	count := func(method string, expected int) {
+		t.Helper()
+		if calls := backend.receivedFor(method); expected != len(calls) {
+			t.Errorf("%s calls: %v  expected: %d", method, calls, expected)
+		}
+	}
+
+	call()
+	call()
+	count("getblockhash", 1)
+	count("getblock", 1)
+	count("getpeerinfo", 2)
+
+	time.Sleep(100 * time.Millisecond)
+	call()
+	count("getblockhash", 2)
+	count("getblock", 1)
+	count("getpeerinfo", 3)
+}
+
+// start with an empty result cache and leave one behind
+func emptyCache(t *testing.T) {
+	empty := func() {
+		responseCache.Lock()
+		for element := responseCache.lru.Front(); nil != element; element = responseCache.lru.Front() {
+			removeCacheElement(element)
+		}
+		responseCache.Unlock()
+	}
+	empty()
+	t.Cleanup(empty)
+}
+
+// the cached size and number of entries
+func cacheUsage() (int64, int) {
+	responseCache.Lock()
+	defer responseCache.Unlock()
+	return responseCache.size, responseCache.lru.Len()
+}
+
+func TestCacheMemoryLimit(t *testing.T) {
+	emptyCache(t)
+	t.Cleanup(func() {
+		SetCacheMemoryLimit(defaultCacheMemory)
+	})
+
+	// results padded so that every entry has the same size
+	result := func(key string) json.RawMessage {
+		return json.RawMessage(`"` + strings.Repeat("0", 400-len(key)) + `"`)
+	}
+	entry := &cacheEntry{key: "getblock", result: result("getblock")}
+	limit := 4 * entry.size()
+	SetCacheMemoryLimit(limit)
+
+	// blocks, transactions and info share the one budget
+	keys := []string{}
+	for i := 0; i < 12; i += 1 {
+		var key string
+		switch i % 3 {
+		case 0:
+			key = callKey("getblock", rawArguments(fmt.Sprintf(`"%064x"`, i)))
+		case 1:
+			key = callKey("getrawtransaction", rawArguments(fmt.Sprintf(`"%064x"`, i)))
+		case 2:
+			key = callKey("getblockheader", rawArguments(fmt.Sprintf(`"%064x"`, i)))
+		}
+		keys = append(keys, key)
+		cacheStore(key, result(key), CacheForever)
+
+		// keep the first entry in use
+		if _, ok := cacheLookup(keys[0]); !ok {
+			t.Fatalf("recently used entry evicted after %d stores", i+1)
+		}
+		if size, _ := cacheUsage(); size > limit {
+			t.Fatalf("size %d exceeds the limit of %d", size, limit)
+		}
+	}
+
+	if _, count := cacheUsage(); 4 != count {
+		t.Errorf("%d entries cached", count)
+	}
+	for _, key := range keys[1:9] {
+		if _, ok := cacheLookup(key); ok {
+			t.Errorf("least recently used entry %q kept", key)
+		}
+	}
+	for _, key := range keys[9:] {
+		if _, ok := cacheLookup(key); !ok {
+			t.Errorf("recent entry %q evicted", key)
+		}
+	}
+
+	// lowering the limit evicts at once and oversized results are not kept
+	SetCacheMemoryLimit(2 * entry.size())
+	if size, count := cacheUsage(); 2 != count || size > 2*entry.size() {
+		t.Errorf("size %d of %d entries", size, count)
+	}
+	large := json.RawMessage(`"` + strings.Repeat("00", 1000) + `"`)
+	cacheStore(callKey("getblock", rawArguments(`"large"`)), large, CacheForever)
+	if _, count := cacheUsage(); 2 != count {
+		t.Errorf("%d entries after an oversized store", count)
+	}
+}
+
+func TestExportImportCache(t *testing.T) {
+	emptyCache(t)
+	backend := genesisBackend(t)
+	conn := backend.connect(t,
+		WithMethodTTL("getblock", CacheForever),
+		WithMethodTTL("getblockhash", time.Minute),
+	)
+	t.Cleanup(func() {
+		setMethodTTL("getblock", 0)
+		setMethodTTL("getblockhash", 0)
+	})
+
+	block := rawArguments(`"` + genesisHash + `"`)
+	first, _, err := RemoteCall("getblock", block)
+	if nil != err {
+		t.Fatalf("getblock error: %v", err)
+	}
+	_, _, err = RemoteCall("getblockhash", rawArguments(`0`))
+	if nil != err {
+		t.Fatalf("getblockhash error: %v", err)
+	}
+
+	// only the result that never expires is written
+	var dump bytes.Buffer
+	err = conn.ExportCache(&dump)
+	if nil != err {
+		t.Fatalf("export error: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(dump.String()), "\n"); 1 != len(lines) || !strings.Contains(lines[0], genesisHash) {
+		t.Fatalf("dump: %s", dump.String())
+	}
+
+	// corrupt entries and methods not cached forever are skipped
+	dump.WriteString("{\"key\":\"getblock\\u0000\\\"00\\\"\",\"result\":{\n")
+	dump.WriteString(`{"key":"getblockhash\u00001","result":"00ff"}` + "\n")
+	dump.WriteString("not json\n")
+
+	emptyCache(t)
+	err = conn.ImportCache(&dump)
+	if nil != err {
+		t.Fatalf("import error: %v", err)
+	}
+	if _, count := cacheUsage(); 1 != count {
+		t.Errorf("%d entries imported", count)
+	}
+
+	again, _, err := RemoteCall("getblock", block)
+	if nil != err || !bytes.Equal(first, again) {
+		t.Errorf("result: %s  error: %v", again, err)
+	}
+	if calls := backend.receivedFor("getblock"); 1 != len(calls) {
+		t.Errorf("imported result not used: %v", calls)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// details of how a call was carried out
+type CallInfo struct {
+	Cached        bool              // answered from the result cache
+	Method        string            // method sent upstream
+	ForwardedArgs []json.RawMessage // arguments as sent, including defaults filled in
+	Upstream      time.Duration     // round trip of the request to bitcoind, zero if none was made
+}
+
+// collects the info of a call from the background
+type callRecorder struct {
+	sync.Mutex
+	info CallInfo
+}
+
+type callRecorderKey struct{}
+
+// RPC call that also reports what was actually sent, e.g. optional
+// arguments given their defaults; for a retried call this is the
+// last attempt
+func RemoteCallWithInfo(ctx context.Context, method string, arguments []json.RawMessage) (json.RawMessage, json.RawMessage, *CallInfo, error) {
+
+	recorder := &callRecorder{}
+	ctx = context.WithValue(ctx, callRecorderKey{}, recorder)
+
+	result, rpcErr, err := RemoteCallContext(ctx, method, arguments)
+
+	recorder.Lock()
+	info := recorder.info
+	recorder.Unlock()
+	return result, rpcErr, &info, err
+}
+
+// note a cache hit for RemoteCallWithInfo
+func recordCached(ctx context.Context) {
+	if recorder, ok := ctx.Value(callRecorderKey{}).(*callRecorder); ok {
+		recorder.Lock()
+		recorder.info.Cached = true
+		recorder.Unlock()
+	}
+}
+
+// note the request sent upstream for RemoteCallWithInfo
+func recordForwarded(ctx context.Context, method string, params []interface{}) {
+	recorder, ok := ctx.Value(callRecorderKey{}).(*callRecorder)
+	if !ok {
+		return
+	}
+
+	forwarded := make([]json.RawMessage, 0, len(params))
+	for _, param := range params {
+		argument, err := json.Marshal(param)
+		if nil != err {
+			return
+		}
+		forwarded = append(forwarded, argument)
+	}
+
+	recorder.Lock()
+	recorder.info.Method = method
+	recorder.info.ForwardedArgs = forwarded
+	recorder.Unlock()
+}
+
+// note the round trip time of the request to bitcoind for RemoteCallWithInfo
+func recordUpstream(ctx context.Context, duration time.Duration) {
+	if recorder, ok := ctx.Value(callRecorderKey{}).(*callRecorder); ok {
+		recorder.Lock()
+		recorder.info.Upstream = duration
+		recorder.Unlock()
+	}
+}
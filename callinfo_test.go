@@ -0,0 +1,59 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// the forwarded arguments as a single string
+func forwardedArgs(info *CallInfo) string {
+	buffer, _ := json.Marshal(info.ForwardedArgs)
+	return string(buffer)
+}
+
+func TestCallInfoDefaultedArguments(t *testing.T) {
+	emptyCache(t)
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockfilter" == method {
+			return map[string]string{"filter": "01", "header": genesisHash}, nil
+		}
+		return nil, nil
+	})
+	backend.connect(t)
+
+	hash, _ := json.Marshal(genesisHash)
+	_, rpcErr, info, err := RemoteCallWithInfo(context.Background(), "getblockfilter", []json.RawMessage{hash})
+	if nil != err || "null" != string(rpcErr) {
+		t.Fatalf("error: %v  rpc error: %s", err, rpcErr)
+	}
+
+	// the filter type left out by the caller is filled in
+	if expected := `["` + genesisHash + `","basic"]`; expected != forwardedArgs(info) {
+		t.Errorf("forwarded: %s  expected: %s", forwardedArgs(info), expected)
+	}
+	if "getblockfilter" != info.Method || info.Cached || 0 == info.Upstream {
+		t.Errorf("info: %+v", info)
+	}
+	if calls := backend.receivedFor("getblockfilter"); 1 != len(calls) || `getblockfilter`+forwardedArgs(info) != calls[0] {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestCallInfoExplicitArguments(t *testing.T) {
+	emptyCache(t)
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t)
+
+	_, _, info, err := RemoteCallWithInfo(context.Background(), "getblockhash", rawArguments("7"))
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if "[7]" != forwardedArgs(info) || "getblockhash" != info.Method {
+		t.Errorf("info: %+v", info)
+	}
+}
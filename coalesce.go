@@ -0,0 +1,178 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// height queries shared between concurrent callers
+var tipMethods = map[string]bool{
+	"getblockcount":     true,
+	"getblockchaininfo": true,
+}
+
+// one outstanding or recently completed height query
+type tipQuery struct {
+	done      chan struct{}
+	result    json.RawMessage
+	rpcErr    json.RawMessage
+	err       error
+	completed time.Time
+
+	waiters int            // callers still waiting
+	shared  *sharedContext // of the upstream call
+}
+
+// the context of a shared upstream call, with the values of the caller
+// starting it and the latest deadline of all its callers, none if any
+// caller has no deadline
+type sharedContext struct {
+	context.Context
+	cancel context.CancelCauseFunc
+
+	sync.Mutex
+	deadline  time.Time
+	unbounded bool
+	timer     *time.Timer
+}
+
+func newSharedContext(ctx context.Context) *sharedContext {
+	s := &sharedContext{}
+	s.Context, s.cancel = context.WithCancelCause(context.WithoutCancel(ctx))
+	s.extend(ctx)
+	return s
+}
+
+// let the call run until the deadline of another caller, if later
+func (s *sharedContext) extend(ctx context.Context) {
+	deadline, ok := ctx.Deadline()
+
+	s.Lock()
+	defer s.Unlock()
+
+	switch {
+	case s.unbounded || nil != s.Context.Err():
+	case !ok:
+		s.unbounded = true
+		if nil != s.timer {
+			s.timer.Stop()
+		}
+	case deadline.After(s.deadline):
+		s.deadline = deadline
+		if nil == s.timer {
+			s.timer = time.AfterFunc(time.Until(deadline), s.expire)
+		} else {
+			s.timer.Reset(time.Until(deadline))
+		}
+	}
+}
+
+// the latest deadline has passed
+func (s *sharedContext) expire() {
+	s.cancel(context.DeadlineExceeded)
+}
+
+// end the call, e.g. once it has completed or all callers gave up
+func (s *sharedContext) stop() {
+	s.Lock()
+	if nil != s.timer {
+		s.timer.Stop()
+	}
+	s.Unlock()
+	s.cancel(nil)
+}
+
+func (s *sharedContext) Deadline() (time.Time, bool) {
+	s.Lock()
+	defer s.Unlock()
+	if s.unbounded {
+		return time.Time{}, false
+	}
+	return s.deadline, true
+}
+
+// coalesced height queries, at most one upstream call per method
+// is outstanding and a result is reused for the freshness interval
+var tipTracker = struct {
+	sync.Mutex
+	freshness time.Duration
+	queries   map[string]*tipQuery
+}{
+	freshness: time.Second,
+	queries:   make(map[string]*tipQuery),
+}
+
+// set how long a height query result is reused,
+// zero only shares calls that are in flight
+func SetTipFreshness(freshness time.Duration) {
+	tipTracker.Lock()
+	tipTracker.freshness = freshness
+	tipTracker.Unlock()
+}
+
+// join an outstanding or fresh height query or start a new one
+//
+// the upstream call has the priority, metadata and call timeout of
+// the caller starting it and the latest deadline of its callers, so a
+// caller without a deadline lets it run until it completes; once all
+// callers have given up it is cancelled and the next caller starts a
+// new one
+func coalescedCall(ctx context.Context, queues *chainQueues, method string) (json.RawMessage, json.RawMessage, error) {
+
+	tipTracker.Lock()
+	query := tipTracker.queries[method]
+	if nil != query && !query.completed.IsZero() {
+		if nil != query.err || time.Since(query.completed) >= tipTracker.freshness {
+			query = nil
+		}
+	}
+	if nil == query {
+		query = &tipQuery{
+			done:   make(chan struct{}),
+			shared: newSharedContext(ctx),
+		}
+		tipTracker.queries[method] = query
+
+		go func() {
+			defer query.shared.stop()
+			result, rpcErr, err := queueCall(query.shared, queues.reads.in, queues.reads.done(), method, []json.RawMessage{})
+			if nil != err && nil != query.shared.Err() {
+				err = context.Cause(query.shared)
+			}
+			tipTracker.Lock()
+			query.result = result
+			query.rpcErr = rpcErr
+			query.err = err
+			query.completed = time.Now()
+			tipTracker.Unlock()
+			close(query.done)
+		}()
+	}
+	if query.completed.IsZero() {
+		query.shared.extend(ctx)
+	}
+	query.waiters += 1
+	tipTracker.Unlock()
+
+	select {
+	case <-query.done:
+		return query.result, query.rpcErr, query.err
+	case <-ctx.Done():
+		tipTracker.Lock()
+		query.waiters -= 1
+		if 0 == query.waiters && query.completed.IsZero() {
+			query.shared.stop()
+			if query == tipTracker.queries[method] {
+				delete(tipTracker.queries, method)
+			}
+		}
+		tipTracker.Unlock()
+		return jsonNull, jsonNull, ctx.Err()
+	}
+}
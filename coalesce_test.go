@@ -0,0 +1,161 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// forget earlier height queries and only share those in flight
+func noTipFreshness(t *testing.T) {
+	SetTipFreshness(0)
+	t.Cleanup(func() {
+		SetTipFreshness(time.Second)
+	})
+}
+
+func TestCoalescedCallsShareOneQuery(t *testing.T) {
+	noTipFreshness(t)
+	release := make(chan struct{})
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockcount" == method {
+			<-release
+			return 100, nil
+		}
+		return nil, nil
+	})
+	backend.connect(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, _, err := RemoteCall("getblockcount", nil)
+			if nil != err || "100" != string(result) {
+				t.Errorf("result: %s  error: %v", result, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls := backend.receivedFor("getblockcount"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestCoalescedCallAbandonedWhenCallersLeave(t *testing.T) {
+	noTipFreshness(t)
+	hang := make(chan struct{})
+	defer close(hang)
+	var queries int64
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockcount" != method {
+			return nil, nil
+		}
+		if 1 == atomic.AddInt64(&queries, 1) {
+			<-hang
+		}
+		return 100, nil
+	})
+	backend.connect(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err := RemoteCallContext(ctx, "getblockcount", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error: %v", err)
+	}
+
+	// a later caller must not join the stuck query
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result, _, err := RemoteCallContext(ctx, "getblockcount", nil)
+	if nil != err || "100" != string(result) {
+		t.Errorf("result: %s  error: %v", result, err)
+	}
+}
+
+func TestCoalescedCallLatestDeadline(t *testing.T) {
+	noTipFreshness(t)
+	release := make(chan struct{})
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockcount" == method {
+			<-release
+			return 100, nil
+		}
+		return nil, nil
+	})
+	backend.connect(t)
+
+	deadline := func() (time.Time, bool) {
+		tipTracker.Lock()
+		defer tipTracker.Unlock()
+		return tipTracker.queries["getblockcount"].shared.Deadline()
+	}
+	call := func(ctx context.Context, wg *sync.WaitGroup) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, _, err := RemoteCallContext(ctx, "getblockcount", nil)
+			if nil != err || "100" != string(result) {
+				t.Errorf("result: %s  error: %v", result, err)
+			}
+		}()
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	var wg sync.WaitGroup
+	later, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	earlier, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	call(later, &wg)
+	call(earlier, &wg)
+	expected, _ := later.Deadline()
+	if d, ok := deadline(); !ok || !expected.Equal(d) {
+		t.Errorf("deadline: %v  expected: %v", d, expected)
+	}
+
+	// a caller without a deadline lets the call run until it completes
+	call(context.Background(), &wg)
+	if d, ok := deadline(); ok {
+		t.Errorf("deadline: %v", d)
+	}
+
+	close(release)
+	wg.Wait()
+}
+
+func TestSharedContextExpires(t *testing.T) {
+	first, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	shared := newSharedContext(first)
+	defer shared.stop()
+
+	// extended by a later deadline
+	second, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	shared.extend(second)
+	time.Sleep(50 * time.Millisecond)
+	if nil != shared.Err() {
+		t.Fatalf("expired at the first deadline: %v", shared.Err())
+	}
+
+	select {
+	case <-shared.Done():
+	case <-time.After(time.Second):
+		t.Fatal("not expired at the latest deadline")
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+)
+
+// request bodies smaller than this are never compressed
+const compressMinimumSize = 4096
+
+// gzip request bodies of at least compressMinimumSize bytes and send
+// them with "Content-Encoding: gzip"
+//
+// bitcoind itself does not accept compressed requests, so only use
+// this for a gateway in front of bitcoind that decompresses them
+func WithCompressRequests() Option {
+	return func(conn *RemoteConnection) {
+		conn.compressRequests = true
+	}
+}
+
+// compress a request body into buffer
+func gzipData(buffer *bytes.Buffer, data []byte) error {
+	w := gzip.NewWriter(buffer)
+	_, err := w.Write(data)
+	if nil != err {
+		return err
+	}
+	return w.Close()
+}
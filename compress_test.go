@@ -0,0 +1,49 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompressRequests(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t, WithCompressRequests())
+
+	// a small body is sent as it is
+	_, _, err := RemoteCall("getblockhash", rawArguments(`8`))
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if 0 != backend.gzipped() {
+		t.Errorf("%d requests gzipped", backend.gzipped())
+	}
+
+	// a large transaction is compressed and still arrives intact
+	large := testTransaction + strings.Repeat("00", compressMinimumSize)
+	_, _, err = RemoteCall("sendrawtransaction", rawArguments(`"`+large+`"`))
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if 1 != backend.gzipped() {
+		t.Errorf("%d requests gzipped", backend.gzipped())
+	}
+	sent := broadcasts(backend)
+	if !sent[large] {
+		t.Error("large transaction not received")
+	}
+}
+
+func TestCompressRequestsOff(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t)
+
+	large := testTransaction + strings.Repeat("00", compressMinimumSize)
+	RemoteCall("sendrawtransaction", rawArguments(`"`+large+`"`))
+	if 0 != backend.gzipped() {
+		t.Errorf("%d requests gzipped without the option", backend.gzipped())
+	}
+}
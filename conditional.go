@@ -0,0 +1,44 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// errors
+var (
+	ErrNotModified = errors.New("not modified")
+)
+
+type knownHashKey struct{}
+
+// mark calls made with the context as conditional on the caller not
+// already having the result for hash
+//
+// a call to a method cached forever (see WithMethodTTL) whose first
+// argument is that hash then fails with ErrNotModified without
+// reaching the cache or the backend, since hash-addressed immutable
+// data cannot have changed
+func WithKnownHash(ctx context.Context, hash string) context.Context {
+	return context.WithValue(ctx, knownHashKey{}, hash)
+}
+
+// check if a call is for data the caller already has
+func notModified(ctx context.Context, ttl time.Duration, arguments []json.RawMessage) bool {
+	known, ok := ctx.Value(knownHashKey{}).(string)
+	if !ok || CacheForever != ttl || 0 == len(arguments) {
+		return false
+	}
+	var hash string
+	if nil != json.Unmarshal(arguments[0], &hash) {
+		return false
+	}
+	return strings.EqualFold(hash, known)
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKnownHashNotModified(t *testing.T) {
+	emptyCache(t)
+	backend := genesisBackend(t)
+	backend.connect(t, WithMethodTTL("getblockheader", CacheForever))
+	t.Cleanup(func() {
+		setMethodTTL("getblockheader", 0)
+	})
+
+	arguments := rawArguments(`"` + genesisHash + `"`)
+	for _, known := range []string{genesisHash, strings.ToUpper(genesisHash)} {
+		ctx := WithKnownHash(context.Background(), known)
+		if _, _, err := RemoteCallContext(ctx, "getblockheader", arguments); !errors.Is(err, ErrNotModified) {
+			t.Errorf("known: %s  error: %v  expected: %v", known, err, ErrNotModified)
+		}
+	}
+	if calls := backend.receivedFor("getblockheader"); 0 != len(calls) {
+		t.Errorf("backend reached: %v", calls)
+	}
+
+	// some other hash is fetched as usual
+	ctx := WithKnownHash(context.Background(), strings.Repeat("0", 64))
+	result, _, err := RemoteCallContext(ctx, "getblockheader", arguments)
+	if nil != err || !strings.Contains(string(result), genesisHash) {
+		t.Errorf("result: %s  error: %v", result, err)
+	}
+	if calls := backend.receivedFor("getblockheader"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestKnownHashMutableMethod(t *testing.T) {
+	emptyCache(t)
+	backend := genesisBackend(t)
+	backend.connect(t, WithMethodTTL("getblockheader", time.Minute))
+	t.Cleanup(func() {
+		setMethodTTL("getblockheader", 0)
+	})
+
+	// only data cached forever is known not to change
+	ctx := WithKnownHash(context.Background(), genesisHash)
+	_, _, err := RemoteCallContext(ctx, "getblockheader", rawArguments(`"`+genesisHash+`"`))
+	if nil != err {
+		t.Errorf("error: %v", err)
+	}
+	if calls := backend.receivedFor("getblockheader"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestHandlerIfNoneMatch(t *testing.T) {
+	emptyCache(t)
+	backend := genesisBackend(t)
+	backend.connect(t, WithMethodTTL("getblockheader", CacheForever))
+	t.Cleanup(func() {
+		setMethodTTL("getblockheader", 0)
+	})
+	handler := pageHandler("test", "", "", false, false)
+
+	body := `{"id":1,"method":"getblockheader","params":["` + genesisHash + `"]}`
+	w := postCall(handler, body, "If-None-Match", `"`+genesisHash+`"`)
+	if http.StatusNotModified != w.Code || 0 != w.Body.Len() {
+		t.Errorf("status: %d  body: %s", w.Code, w.Body)
+	}
+
+	w = postCall(handler, body)
+	var reply struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); nil != err || !strings.Contains(string(reply.Result), genesisHash) {
+		t.Errorf("status: %d  body: %s", w.Code, w.Body)
+	}
+	if calls := backend.receivedFor("getblockheader"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+)
+
+// longest argument summary kept in a dead letter
+const deadLetterArgumentLimit = 1024
+
+// a RemoteCall given up on after its last attempt failed
+type DeadLetter struct {
+	Method    string
+	Arguments string // redacted as for call logging and truncated
+	Err       error
+	Attempts  int
+	Metadata  CallMetadata
+}
+
+// the dead letter handler for all connections
+var deadLetters = struct {
+	sync.RWMutex
+	handler func(letter DeadLetter)
+}{}
+
+// call handler once for every call that fails on all the attempts
+// allowed by SetTries, e.g. to store it durably for later analysis;
+// calls ending on an error that is not retried, a cancelled context or
+// shut down are not reported and nil removes the handler
+//
+// the handler runs on the calling goroutine and should not block
+func SetDeadLetterHandler(handler func(letter DeadLetter)) {
+	deadLetters.Lock()
+	deadLetters.handler = handler
+	deadLetters.Unlock()
+}
+
+// pass a failed call to the handler, if any
+func reportDeadLetter(call *Call, err error) {
+	deadLetters.RLock()
+	handler := deadLetters.handler
+	deadLetters.RUnlock()
+	if nil == handler {
+		return
+	}
+
+	arguments := redactedText
+	if !redactedMethods[call.Method] {
+		arguments = truncatedText(redactJSON(argumentList(call.Arguments)), deadLetterArgumentLimit)
+	}
+	handler(DeadLetter{
+		Method:    call.Method,
+		Arguments: arguments,
+		Err:       err,
+		Attempts:  call.Tries,
+		Metadata:  CallMetadataFrom(call.Context),
+	})
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// collect dead letters for the duration of a test
+func collectDeadLetters(t *testing.T) func() []DeadLetter {
+	var mu sync.Mutex
+	letters := []DeadLetter{}
+	SetDeadLetterHandler(func(letter DeadLetter) {
+		mu.Lock()
+		letters = append(letters, letter)
+		mu.Unlock()
+	})
+	t.Cleanup(func() {
+		SetDeadLetterHandler(nil)
+	})
+	return func() []DeadLetter {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]DeadLetter{}, letters...)
+	}
+}
+
+// a backend failing getblockhash with status while failures remain
+func failingBackend(t *testing.T, status int, failures *int64) *fakeBitcoind {
+	return newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockhash" != method {
+			return nil, nil
+		}
+		if atomic.AddInt64(failures, -1) >= 0 {
+			return httpStatus(status), nil
+		}
+		return "00", nil
+	})
+}
+
+func setTestTries(t *testing.T, tries int) {
+	SetTries(tries)
+	t.Cleanup(func() {
+		SetTries(totalTries)
+	})
+}
+
+func TestDeadLetterOnExhaustion(t *testing.T) {
+	failures := int64(100)
+	failingBackend(t, http.StatusServiceUnavailable, &failures).connect(t)
+	letters := collectDeadLetters(t)
+	setTestTries(t, 3)
+
+	_, _, err := RemoteCall("getblockhash", rawArguments(`8`))
+	if nil == err {
+		t.Fatal("no error")
+	}
+	found := letters()
+	if 1 != len(found) {
+		t.Fatalf("dead letters: %+v", found)
+	}
+	letter := found[0]
+	if "getblockhash" != letter.Method || "[8]" != letter.Arguments || 3 != letter.Attempts || nil == letter.Err {
+		t.Errorf("dead letter: %+v", letter)
+	}
+}
+
+func TestDeadLetterNotOnSuccess(t *testing.T) {
+	failures := int64(1)
+	failingBackend(t, http.StatusServiceUnavailable, &failures).connect(t)
+	letters := collectDeadLetters(t)
+	setTestTries(t, 3)
+
+	_, _, err := RemoteCall("getblockhash", rawArguments(`7`))
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if found := letters(); 0 != len(found) {
+		t.Errorf("dead letters: %+v", found)
+	}
+}
+
+func TestDeadLetterNotOnPermanentError(t *testing.T) {
+	failures := int64(100)
+	backend := failingBackend(t, http.StatusInternalServerError, &failures)
+	backend.connect(t)
+	letters := collectDeadLetters(t)
+	setTestTries(t, 1)
+
+	_, _, err := RemoteCall("getblockhash", rawArguments(`7`))
+	if nil == err {
+		t.Fatal("no error")
+	}
+
+	// validation errors are not retried either
+	for _, call := range []struct {
+		method    string
+		arguments []json.RawMessage
+	}{
+		{"nosuchmethod", nil},
+		{"getblockhash", rawArguments(`"x"`)},
+		{"getblockhash", rawArguments(`1`, `2`)},
+	} {
+		_, _, err := RemoteCall(call.method, call.arguments)
+		if !retryable(err) {
+			continue
+		}
+		t.Errorf("%s error: %v is retryable", call.method, err)
+	}
+	if found := letters(); 0 != len(found) {
+		t.Errorf("dead letters: %+v", found)
+	}
+	if calls := backend.receivedFor("getblockhash"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestDeadLetterRedacted(t *testing.T) {
+	letters := collectDeadLetters(t)
+	reportDeadLetter(&Call{
+		Method:    "walletpassphrase",
+		Arguments: rawArguments(`"secret"`, `10`),
+		Tries:     5,
+	}, ErrShuttingDown)
+	reportDeadLetter(&Call{
+		Method:    "getblock",
+		Arguments: rawArguments(`{"passphrase":"secret"}`),
+		Tries:     5,
+	}, ErrShuttingDown)
+
+	for _, letter := range letters() {
+		if strings.Contains(letter.Arguments, "secret") {
+			t.Errorf("dead letter not redacted: %+v", letter)
+		}
+	}
+}
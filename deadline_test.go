@@ -0,0 +1,79 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func setTestExpectedDuration(t *testing.T, method string, d time.Duration) {
+	SetMethodExpectedDuration(method, d)
+	t.Cleanup(func() {
+		SetMethodExpectedDuration(method, 0)
+	})
+}
+
+func TestDeadlineExpiredContext(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	_, _, err := RemoteCallContext(ctx, "getblockhash", rawArguments(`8`))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error: %v  expected: %v", err, context.DeadlineExceeded)
+	}
+	if calls := backend.receivedFor("getblockhash"); 0 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestDeadlineTooClose(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t)
+	setTestExpectedDuration(t, "getblockhash", time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, _, err := RemoteCallContext(ctx, "getblockhash", rawArguments(`8`))
+	if !errors.Is(err, ErrDeadlineExceeded) {
+		t.Errorf("error: %v  expected: %v", err, ErrDeadlineExceeded)
+	}
+	if calls := backend.receivedFor("getblockhash"); 0 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestDeadlineSkipsFinalAttempt(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockhash" == method {
+			time.Sleep(150 * time.Millisecond)
+			return httpStatus(http.StatusServiceUnavailable), nil
+		}
+		return nil, nil
+	})
+	backend.connect(t)
+	setTestTries(t, 3)
+	setTestExpectedDuration(t, "getblockhash", 100*time.Millisecond)
+
+	// the first attempt leaves too little of the deadline for another
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	_, _, err := RemoteCallContext(ctx, "getblockhash", rawArguments(`8`))
+	if !errors.Is(err, ErrDeadlineExceeded) {
+		t.Errorf("error: %v  expected: %v", err, ErrDeadlineExceeded)
+	}
+	if calls := backend.receivedFor("getblockhash"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	neturl "net/url"
+	"strconv"
+	"strings"
+)
+
+// check and normalise a connection URL
+//
+// the URL must be absolute http or https with a host, IPv6 literals
+// must be bracketed, e.g. http://[::1]:8332, and any port numeric;
+// the scheme and host are lowercased and any sub-path is kept
+func parseEndpoint(url string) (*neturl.URL, error) {
+
+	u, err := neturl.Parse(strings.TrimSpace(url))
+	if nil != err {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if "http" != u.Scheme && "https" != u.Scheme {
+		return nil, fmt.Errorf("%w: scheme %q", ErrInvalidURL, u.Scheme)
+	}
+	if "" == u.Hostname() {
+		return nil, fmt.Errorf("%w: missing host in %q", ErrInvalidURL, u.Redacted())
+	}
+	if strings.Contains(u.Hostname(), ":") && !strings.HasPrefix(u.Host, "[") {
+		return nil, fmt.Errorf("%w: unbracketed IPv6 host %q", ErrInvalidURL, u.Host)
+	}
+	if port := u.Port(); "" != port {
+		n, err := strconv.ParseUint(port, 10, 16)
+		if nil != err || 0 == n {
+			return nil, fmt.Errorf("%w: port %q", ErrInvalidURL, port)
+		}
+	}
+	u.Host = strings.ToLower(u.Host)
+	return u, nil
+}
+
+// remove credentials from the URL, returning them if none were given
+func takeCredentials(u *neturl.URL, username string, password string) (string, string) {
+	if nil == u.User {
+		return username, password
+	}
+	if "" == username && "" == password {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	u.User = nil
+	return username, password
+}
+
+// send calls to the named wallet's endpoint below the base URL
+func WithWallet(name string) Option {
+	return func(conn *RemoteConnection) {
+		conn.wallet = name
+	}
+}
+
+// the URL for a wallet below a base URL, the name is escaped
+// as a single path segment
+func walletEndpoint(base *neturl.URL, wallet string) *neturl.URL {
+	return base.JoinPath("wallet", neturl.PathEscape(wallet))
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// the outcome of a fan-out call on one connection
+//
+// Name is the registered name, empty for an unregistered connection
+type FanOutResult struct {
+	Name   string
+	Result json.RawMessage
+	Error  *RPCError // bitcoind rejected the call
+	Err    error     // no reply was received
+}
+
+// send the same call to this connection and every registered one in
+// parallel and collect all their results, e.g. to compare best block
+// hashes for a node on a different fork
+//
+// each call is validated and queued on its own connection, this
+// connection comes first and the rest are in name order; the error
+// is only set if the context ended before all results were in
+func (conn *RemoteConnection) FanOut(ctx context.Context, method string, arguments []json.RawMessage) ([]FanOutResult, error) {
+
+	targets := []*RemoteConnection{conn}
+	names := []string{""}
+
+	registry.RLock()
+	registered := make([]string, 0, len(registry.connections))
+	for name := range registry.connections {
+		registered = append(registered, name)
+	}
+	sort.Strings(registered)
+	for _, name := range registered {
+		c := registry.connections[name]
+		if c == conn {
+			names[0] = name
+			continue
+		}
+		targets = append(targets, c)
+		names = append(names, name)
+	}
+	registry.RUnlock()
+
+	results := make([]FanOutResult, len(targets))
+	var wg sync.WaitGroup
+	for i, c := range targets {
+		wg.Add(1)
+		go func(i int, c *RemoteConnection) {
+			defer wg.Done()
+			result, rpcErr, err := queueCall(ctx, c.queue, c.shutdown, method, arguments)
+			if nil == err && !isNull(rpcErr) {
+				err = rpcErrorFrom(rpcErr)
+			}
+			var rejected *RPCError
+			if errors.As(err, &rejected) {
+				err = nil
+			}
+			results[i] = FanOutResult{
+				Name:   names[i],
+				Result: result,
+				Error:  rejected,
+				Err:    err,
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	return results, ctx.Err()
+}
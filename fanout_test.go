@@ -0,0 +1,98 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// a backend whose best block is hash, or that rejects the call if empty
+func bestBlockBackend(t *testing.T, hash string) *fakeBitcoind {
+	return newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getbestblockhash" != method {
+			return nil, nil
+		}
+		if "" == hash {
+			return nil, &RPCError{Code: -28, Message: "Loading block index..."}
+		}
+		return hash, nil
+	})
+}
+
+func TestFanOut(t *testing.T) {
+	emptyCache(t)
+	forkA := fmt.Sprintf("%064x", 0xa)
+	forkB := fmt.Sprintf("%064x", 0xb)
+	primary := bestBlockBackend(t, forkA)
+	other := bestBlockBackend(t, forkB)
+	loading := bestBlockBackend(t, "")
+
+	conn := primary.connect(t)
+	register(t, "other", other.connect(t))
+	register(t, "loading", loading.connect(t))
+
+	results, err := conn.FanOut(context.Background(), "getbestblockhash", nil)
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if 3 != len(results) {
+		t.Fatalf("results: %+v", results)
+	}
+
+	// this connection first then by name
+	if "" != results[0].Name || `"`+forkA+`"` != string(results[0].Result) || nil != results[0].Error || nil != results[0].Err {
+		t.Errorf("primary: %+v", results[0])
+	}
+	if "loading" != results[1].Name || nil == results[1].Error || -28 != results[1].Error.Code || nil != results[1].Err {
+		t.Errorf("loading: %+v", results[1])
+	}
+	if "other" != results[2].Name || `"`+forkB+`"` != string(results[2].Result) || nil != results[2].Err {
+		t.Errorf("other: %+v", results[2])
+	}
+
+	// each upstream got the call once
+	for _, backend := range []*fakeBitcoind{primary, other, loading} {
+		if calls := backend.receivedFor("getbestblockhash"); 1 != len(calls) {
+			t.Errorf("calls: %v", calls)
+		}
+	}
+}
+
+func TestFanOutRegisteredSelf(t *testing.T) {
+	backend := bestBlockBackend(t, genesisHash)
+	conn := backend.connect(t)
+	register(t, "self", conn)
+
+	// named, and not called twice
+	results, err := conn.FanOut(context.Background(), "getbestblockhash", nil)
+	if nil != err || 1 != len(results) || "self" != results[0].Name {
+		t.Errorf("results: %+v  error: %v", results, err)
+	}
+}
+
+func TestFanOutTimeout(t *testing.T) {
+	held := newHeldBackend(t, "getbestblockhash")
+	fast := bestBlockBackend(t, genesisHash)
+	conn := fast.connect(t)
+	register(t, "held", held.connect(t))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	results, err := conn.FanOut(ctx, "getbestblockhash", nil)
+	if !errors.Is(err, context.DeadlineExceeded) || 2 != len(results) {
+		t.Fatalf("results: %+v  error: %v", results, err)
+	}
+	if `"`+genesisHash+`"` != string(results[0].Result) || nil != results[0].Err {
+		t.Errorf("fast: %+v", results[0])
+	}
+	if "held" != results[1].Name || !errors.Is(results[1].Err, context.DeadlineExceeded) {
+		t.Errorf("held: %+v", results[1])
+	}
+}
@@ -0,0 +1,377 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+// errors
+var (
+	ErrInvalidFilter = errors.New("invalid block filter")
+)
+
+// BIP158 basic filter parameters
+const (
+	filterP = 19
+	filterM = 784931
+)
+
+// an output paying to one of the scripts, or an input spending one
+//
+// Index is the output number, or for a spend the input number
+// in the spending transaction; Value is in satoshis
+type ScriptActivity struct {
+	Height    uint64 `json:"height"`
+	BlockHash string `json:"blockhash"`
+	TxID      string `json:"txid"`
+	Index     uint32 `json:"index"`
+	Script    string `json:"script"`
+	Value     int64  `json:"value"`
+	Spent     bool   `json:"spent"`
+}
+
+// find the activity of output scripts from start to end inclusive
+//
+// the basic block filter of each block is checked first and only
+// blocks that may match are fetched, a bounded number at a time;
+// descriptors must be expanded to their scripts by the caller
+func (conn *RemoteConnection) ScriptActivity(ctx context.Context, scripts [][]byte, start uint64, end uint64) ([]ScriptActivity, error) {
+
+	if start > end {
+		return nil, nil
+	}
+
+	err := conn.ensureBootstrap(ctx)
+	if nil != err {
+		return nil, err
+	}
+	err = conn.requireVersion("getblockfilter")
+	if nil != err {
+		return nil, err
+	}
+	err = conn.requireIndex("getblockfilter", blockFilterIndex)
+	if nil != err {
+		return nil, err
+	}
+
+	concurrency := conn.blockPrefetch
+	if concurrency <= 0 {
+		concurrency = defaultBlockPrefetch
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make([][]ScriptActivity, end-start+1)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var failure error
+	limit := make(chan struct{}, concurrency)
+
+	for height := start; height <= end && nil == ctx.Err(); height += 1 {
+		limit <- struct{}{}
+		wg.Add(1)
+		go func(height uint64) {
+			defer wg.Done()
+			defer func() { <-limit }()
+
+			activity, err := conn.blockActivity(ctx, height, scripts)
+			if nil != err {
+				once.Do(func() {
+					failure = err
+					cancel()
+				})
+				return
+			}
+			found[height-start] = activity
+		}(height)
+		if height == end {
+			break // avoid overflow at the maximum height
+		}
+	}
+	wg.Wait()
+
+	if nil != failure {
+		return nil, failure
+	}
+	if nil != ctx.Err() {
+		return nil, ctx.Err()
+	}
+
+	activity := []ScriptActivity{}
+	for _, a := range found {
+		activity = append(activity, a...)
+	}
+	return activity, nil
+}
+
+// the activity in a single block, fetching it only if its filter matches
+func (conn *RemoteConnection) blockActivity(ctx context.Context, height uint64, scripts [][]byte) ([]ScriptActivity, error) {
+
+	hash, err := conn.blockHash(ctx, height)
+	if nil != err {
+		return nil, err
+	}
+
+	var reply struct {
+		Filter string `json:"filter"`
+	}
+	err = conn.call(ctx, "getblockfilter", []interface{}{hash, "basic"}, &reply)
+	if nil != err {
+		return nil, err
+	}
+	filter, err := hex.DecodeString(reply.Filter)
+	if nil != err {
+		return nil, ErrInvalidFilter
+	}
+	match, err := matchFilter(filter, hash, scripts)
+	if nil != err || !match {
+		return nil, err
+	}
+
+	// verbosity 3 includes the scripts of spent outputs
+	raw, err := conn.getBlock(ctx, hash, 3)
+	if nil != err {
+		return nil, err
+	}
+	var block struct {
+		Tx []struct {
+			TxID string `json:"txid"`
+			Vin  []struct {
+				Prevout *struct {
+					Value        json.Number  `json:"value"`
+					ScriptPubKey ScriptPubKey `json:"scriptPubKey"`
+				} `json:"prevout"`
+			} `json:"vin"`
+			Vout []TransactionOutput `json:"vout"`
+		} `json:"tx"`
+	}
+	err = json.Unmarshal(raw, &block)
+	if nil != err {
+		return nil, ErrIncomprehesibleResponse
+	}
+
+	wanted := make(map[string]bool, len(scripts))
+	for _, script := range scripts {
+		wanted[hex.EncodeToString(script)] = true
+	}
+
+	activity := []ScriptActivity{}
+	add := func(txid string, index uint32, script ScriptPubKey, value json.Number, spent bool) error {
+		if !wanted[script.Hex] {
+			return nil
+		}
+		satoshis, err := btcToSatoshis(value)
+		if nil != err {
+			return err
+		}
+		activity = append(activity, ScriptActivity{
+			Height:    height,
+			BlockHash: hash,
+			TxID:      txid,
+			Index:     index,
+			Script:    script.Hex,
+			Value:     satoshis,
+			Spent:     spent,
+		})
+		return nil
+	}
+	for _, tx := range block.Tx {
+		for i, input := range tx.Vin {
+			if nil == input.Prevout {
+				continue // coinbase, or a daemon without prevout
+			}
+			err = add(tx.TxID, uint32(i), input.Prevout.ScriptPubKey, input.Prevout.Value, true)
+			if nil != err {
+				return nil, err
+			}
+		}
+		for _, output := range tx.Vout {
+			err = add(tx.TxID, output.N, output.ScriptPubKey, output.Value, false)
+			if nil != err {
+				return nil, err
+			}
+		}
+	}
+	return activity, nil
+}
+
+// check if any of the scripts may be in a BIP158 basic filter
+//
+// the filter is a compact size element count followed by the
+// Golomb-Rice coded deltas of the sorted element hashes
+func matchFilter(filter []byte, blockHash string, scripts [][]byte) (bool, error) {
+
+	n, size := compactSize(filter)
+	if 0 == size {
+		return false, ErrInvalidFilter
+	}
+	if 0 == n || 0 == len(scripts) {
+		return false, nil
+	}
+
+	// the key is the first 16 bytes of the block hash in byte order
+	hash, err := hex.DecodeString(blockHash)
+	if nil != err || 32 != len(hash) {
+		return false, ErrInvalidFilter
+	}
+	for i, j := 0, len(hash)-1; i < j; i, j = i+1, j-1 {
+		hash[i], hash[j] = hash[j], hash[i]
+	}
+	k0 := binary.LittleEndian.Uint64(hash[0:8])
+	k1 := binary.LittleEndian.Uint64(hash[8:16])
+
+	modulus := n * filterM
+	targets := make([]uint64, 0, len(scripts))
+	for _, script := range scripts {
+		if 0 == len(script) {
+			continue
+		}
+		h, _ := bits.Mul64(sipHash(k0, k1, script), modulus)
+		targets = append(targets, h)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i] < targets[j] })
+
+	reader := bitReader{data: filter[size:]}
+	value := uint64(0)
+	next := 0
+	for i := uint64(0); i < n && next < len(targets); i += 1 {
+		delta, ok := reader.golombRice()
+		if !ok {
+			return false, ErrInvalidFilter
+		}
+		value += delta
+		for next < len(targets) && targets[next] < value {
+			next += 1
+		}
+		if next < len(targets) && targets[next] == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// decode a Bitcoin compact size integer, zero size if truncated
+func compactSize(data []byte) (uint64, int) {
+	if 0 == len(data) {
+		return 0, 0
+	}
+	switch data[0] {
+	case 0xfd:
+		if len(data) < 3 {
+			return 0, 0
+		}
+		return uint64(binary.LittleEndian.Uint16(data[1:3])), 3
+	case 0xfe:
+		if len(data) < 5 {
+			return 0, 0
+		}
+		return uint64(binary.LittleEndian.Uint32(data[1:5])), 5
+	case 0xff:
+		if len(data) < 9 {
+			return 0, 0
+		}
+		return binary.LittleEndian.Uint64(data[1:9]), 9
+	default:
+		return uint64(data[0]), 1
+	}
+}
+
+// most significant bit first reader
+type bitReader struct {
+	data []byte
+	bit  int
+}
+
+func (r *bitReader) readBit() (uint64, bool) {
+	if r.bit >= 8*len(r.data) {
+		return 0, false
+	}
+	b := r.data[r.bit/8] >> (7 - uint(r.bit%8)) & 1
+	r.bit += 1
+	return uint64(b), true
+}
+
+// a unary quotient followed by a filterP bit remainder
+func (r *bitReader) golombRice() (uint64, bool) {
+	quotient := uint64(0)
+	for {
+		b, ok := r.readBit()
+		if !ok {
+			return 0, false
+		}
+		if 0 == b {
+			break
+		}
+		quotient += 1
+	}
+	remainder := uint64(0)
+	for i := 0; i < filterP; i += 1 {
+		b, ok := r.readBit()
+		if !ok {
+			return 0, false
+		}
+		remainder = remainder<<1 | b
+	}
+	return quotient<<filterP | remainder, true
+}
+
+// SipHash-2-4 as used by BIP158
+func sipHash(k0 uint64, k1 uint64, data []byte) uint64 {
+
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	length := len(data)
+	for ; len(data) >= 8; data = data[8:] {
+		m := binary.LittleEndian.Uint64(data)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+
+	var last [8]byte
+	copy(last[:], data)
+	m := binary.LittleEndian.Uint64(last[:]) | uint64(length)<<56
+	v3 ^= m
+	round()
+	round()
+	v0 ^= m
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+	return v0 ^ v1 ^ v2 ^ v3
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"sort"
+	"testing"
+)
+
+// most significant bit first writer
+type bitWriter struct {
+	data []byte
+	bit  int
+}
+
+func (w *bitWriter) writeBit(b uint64) {
+	if 0 == w.bit%8 {
+		w.data = append(w.data, 0)
+	}
+	w.data[len(w.data)-1] |= byte(b&1) << (7 - uint(w.bit%8))
+	w.bit += 1
+}
+
+// build a BIP158 basic filter holding the scripts for a block
+func encodeFilter(blockHash string, scripts [][]byte) []byte {
+	hash, _ := hex.DecodeString(blockHash)
+	for i, j := 0, len(hash)-1; i < j; i, j = i+1, j-1 {
+		hash[i], hash[j] = hash[j], hash[i]
+	}
+	k0 := binary.LittleEndian.Uint64(hash[0:8])
+	k1 := binary.LittleEndian.Uint64(hash[8:16])
+
+	n := uint64(len(scripts))
+	values := make([]uint64, 0, n)
+	for _, script := range scripts {
+		h, _ := bits.Mul64(sipHash(k0, k1, script), n*filterM)
+		values = append(values, h)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	w := &bitWriter{}
+	last := uint64(0)
+	for _, value := range values {
+		delta := value - last
+		last = value
+		for q := delta >> filterP; q > 0; q -= 1 {
+			w.writeBit(1)
+		}
+		w.writeBit(0)
+		for i := filterP - 1; i >= 0; i -= 1 {
+			w.writeBit(delta >> uint(i))
+		}
+	}
+	return append([]byte{byte(n)}, w.data...)
+}
+
+func TestMatchFilterVector(t *testing.T) {
+	// BIP158 test vector for the testnet genesis block
+	blockHash := "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943"
+	filter, _ := hex.DecodeString("019dfca8")
+	script, _ := hex.DecodeString("4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac")
+
+	match, err := matchFilter(filter, blockHash, [][]byte{script})
+	if nil != err || !match {
+		t.Errorf("match: %v  error: %v", match, err)
+	}
+	match, err = matchFilter(filter, blockHash, [][]byte{{0x51}})
+	if nil != err || match {
+		t.Errorf("unrelated script match: %v  error: %v", match, err)
+	}
+	if encoded := hex.EncodeToString(encodeFilter(blockHash, [][]byte{script})); "019dfca8" != encoded {
+		t.Errorf("encoded: %s", encoded)
+	}
+}
+
+func TestMatchFilterInvalid(t *testing.T) {
+	if _, err := matchFilter(nil, genesisHash, [][]byte{{0x51}}); ErrInvalidFilter != err {
+		t.Errorf("empty filter error: %v", err)
+	}
+	if _, err := matchFilter([]byte{5, 0xff}, genesisHash, [][]byte{{0x51}}); ErrInvalidFilter != err {
+		t.Errorf("truncated filter error: %v", err)
+	}
+}
+
+// the hash of a block for the activity tests
+func activityHash(height uint64) string {
+	return fmt.Sprintf("%062x%02x", height, 0xab)
+}
+
+func TestScriptActivity(t *testing.T) {
+	wanted := []byte{0x00, 0x14, 0xaa}
+	other := []byte{0x00, 0x14, 0xbb}
+
+	// block 3 pays the wanted script, block 7 spends it
+	backend := newFakeBitcoind(t, 250000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		var height uint64
+		var hash string
+		switch method {
+		case "getblockhash":
+			json.Unmarshal(params[0], &height)
+			return activityHash(height), nil
+		case "getblockfilter", "getblock":
+			json.Unmarshal(params[0], &hash)
+			fmt.Sscanf(hash[:62], "%x", &height)
+		default:
+			return nil, nil
+		}
+
+		scripts := [][]byte{other}
+		if 3 == height || 7 == height {
+			scripts = append(scripts, wanted)
+		}
+		if "getblockfilter" == method {
+			return map[string]string{"filter": hex.EncodeToString(encodeFilter(hash, scripts)), "header": hash}, nil
+		}
+
+		output := func(n int, script []byte, value string) map[string]interface{} {
+			return map[string]interface{}{"n": n, "value": json.Number(value), "scriptPubKey": map[string]string{"hex": hex.EncodeToString(script)}}
+		}
+		tx := map[string]interface{}{
+			"txid": fmt.Sprintf("%064x", 100+height),
+			"vin":  []interface{}{map[string]interface{}{"coinbase": "00"}},
+			"vout": []interface{}{output(0, other, "1.5"), output(1, wanted, "0.00012345")},
+		}
+		if 7 == height {
+			tx["vin"] = []interface{}{
+				map[string]interface{}{"txid": fmt.Sprintf("%064x", 103), "vout": 1, "prevout": output(1, wanted, "0.00012345")},
+			}
+			tx["vout"] = []interface{}{output(0, other, "0.0001")}
+		}
+		return map[string]interface{}{"hash": hash, "height": height, "tx": []interface{}{tx}}, nil
+	})
+	conn := backend.connect(t)
+
+	activity, err := conn.ScriptActivity(context.Background(), [][]byte{wanted}, 1, 10)
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	expected := []ScriptActivity{
+		{Height: 3, BlockHash: activityHash(3), TxID: fmt.Sprintf("%064x", 103), Index: 1, Script: "0014aa", Value: 12345},
+		{Height: 7, BlockHash: activityHash(7), TxID: fmt.Sprintf("%064x", 107), Index: 0, Script: "0014aa", Value: 12345, Spent: true},
+	}
+	if len(expected) != len(activity) {
+		t.Fatalf("activity: %+v", activity)
+	}
+	for i := range expected {
+		if expected[i] != activity[i] {
+			t.Errorf("%d: %+v  expected: %+v", i, activity[i], expected[i])
+		}
+	}
+
+	// every filter is checked but only the matching blocks fetched
+	if calls := backend.receivedFor("getblockfilter"); 10 != len(calls) {
+		t.Errorf("filter calls: %d", len(calls))
+	}
+	fetched := backend.receivedFor("getblock")
+	sort.Strings(fetched)
+	if 2 != len(fetched) || `getblock["`+activityHash(3)+`",3]` != fetched[0] || `getblock["`+activityHash(7)+`",3]` != fetched[1] {
+		t.Errorf("blocks fetched: %v", fetched)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// errors
+var (
+	ErrBeyondFrozenHeight = errors.New("data beyond frozen height")
+)
+
+// answer as of a fixed block height for reproducible queries:
+// blocks and transactions above it (or unconfirmed) are refused and
+// getblockcount and getbestblockhash report it as the tip
+//
+// other calls, e.g. gettxout or getblockchaininfo, still reflect
+// the current chain
+func WithFrozenHeight(height uint64) Option {
+	return func(conn *RemoteConnection) {
+		conn.frozen = true
+		conn.frozenHeight = height
+	}
+}
+
+// refuse a block above the frozen height
+//
+// a lookup failing with an RPC error is left for the forwarded
+// call to report in the usual way
+func (conn *RemoteConnection) checkFrozenBlock(ctx context.Context, hash string) error {
+	if !conn.frozen {
+		return nil
+	}
+
+	var header struct {
+		Height uint64 `json:"height"`
+	}
+	err := conn.call(ctx, "getblockheader", []interface{}{hash, true}, &header)
+	if errors.Is(err, ErrRpcError) {
+		return nil
+	} else if nil != err {
+		return err
+	}
+	return conn.checkFrozenHeight(header.Height)
+}
+
+// refuse a transaction not confirmed at or below the frozen height,
+// checking the block hash given with the call if there is one
+//
+// a failed lookup refuses the call, with the RPC error as an *RPCError,
+// since the forwarded call might still find the transaction
+func (conn *RemoteConnection) checkFrozenTransaction(ctx context.Context, txid string, blockHash string) error {
+	if !conn.frozen {
+		return nil
+	}
+	if "" != blockHash {
+		return conn.checkFrozenBlock(ctx, blockHash)
+	}
+
+	var transaction struct {
+		BlockHash string `json:"blockhash"`
+	}
+	err := conn.call(ctx, "getrawtransaction", []interface{}{txid, conn.verboseFlag(true)}, &transaction)
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) && rpcErr.txIndexRequired() && conn.blockHashRetry {
+		if hash, ok := conn.blockHashFor(ctx, txid); ok {
+			return conn.checkFrozenBlock(ctx, hash)
+		}
+	}
+	if nil != err {
+		return err
+	}
+	if "" == transaction.BlockHash {
+		return ErrBeyondFrozenHeight
+	}
+	return conn.checkFrozenBlock(ctx, transaction.BlockHash)
+}
+
+// refuse a height above the frozen height
+func (conn *RemoteConnection) checkFrozenHeight(height uint64) error {
+	if conn.frozen && height > conn.frozenHeight {
+		return ErrBeyondFrozenHeight
+	}
+	return nil
+}
+
+// limit a getblockcount result to the frozen height
+func (conn *RemoteConnection) clampBlockCount(reply *json.RawMessage) error {
+	if !conn.frozen || isNull(*reply) {
+		return nil
+	}
+
+	var count uint64
+	err := json.Unmarshal(*reply, &count)
+	if nil != err {
+		return ErrIncomprehesibleResponse
+	}
+	if count > conn.frozenHeight {
+		*reply, err = json.Marshal(conn.frozenHeight)
+	}
+	return err
+}
+
+// the best block hash, or the hash at the frozen height once the
+// chain has grown past it
+func (conn *RemoteConnection) bestBlockHash(ctx context.Context, reply *json.RawMessage, rpcErr *json.RawMessage) error {
+	if conn.frozen {
+		var count uint64
+		err := conn.call(ctx, "getblockcount", []interface{}{}, &count)
+		if nil != err && !errors.Is(err, ErrRpcError) {
+			return err
+		}
+		if nil == err && count > conn.frozenHeight {
+			return conn.remoteCall(ctx, "getblockhash", []interface{}{conn.frozenHeight}, reply, rpcErr)
+		}
+	}
+	return conn.remoteCall(ctx, "getbestblockhash", []interface{}{}, reply, rpcErr)
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	frozenTestTxID  = "1111111111111111111111111111111111111111111111111111111111111111"
+	frozenTestAbove = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	frozenTestBelow = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+// a backend without the transaction index with blocks at heights 80 and 10
+func frozenBackend(method string, params []json.RawMessage) (interface{}, *RPCError) {
+	switch method {
+	case "getblockheader":
+		if `"`+frozenTestAbove+`"` == string(params[0]) {
+			return map[string]interface{}{"height": 80}, nil
+		}
+		return map[string]interface{}{"height": 10}, nil
+	case "getrawtransaction":
+		if len(params) < 3 {
+			return nil, &RPCError{Code: rpcInvalidAddressOrKey, Message: "No such mempool transaction. Use -txindex or provide a block hash"}
+		}
+		return map[string]interface{}{"txid": frozenTestTxID}, nil
+	}
+	return nil, nil
+}
+
+func TestFrozenTransactionBlockHash(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, frozenBackend)
+	conn := backend.connect(t, WithFrozenHeight(50))
+	ctx := context.Background()
+
+	var reply, rpcErr json.RawMessage
+	err := conn.processCall(ctx, "getrawtransaction", rawArguments(`"`+frozenTestTxID+`"`, `true`, `"`+frozenTestAbove+`"`), &reply, &rpcErr)
+	if !errors.Is(err, ErrBeyondFrozenHeight) {
+		t.Errorf("block above the frozen height: error: %v", err)
+	}
+	if calls := backend.receivedFor("getrawtransaction"); 0 != len(calls) {
+		t.Errorf("forwarded: %v", calls)
+	}
+
+	err = conn.processCall(ctx, "getrawtransaction", rawArguments(`"`+frozenTestTxID+`"`, `true`, `"`+frozenTestBelow+`"`), &reply, &rpcErr)
+	if nil != err || !isNull(rpcErr) {
+		t.Errorf("block below the frozen height: error: %v  RPC error: %s", err, rpcErr)
+	}
+	if calls := backend.receivedFor("getrawtransaction"); 1 != len(calls) {
+		t.Errorf("not forwarded: %v", calls)
+	}
+}
+
+func TestFrozenTransactionFailsClosed(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, frozenBackend)
+	conn := backend.connect(t, WithFrozenHeight(50))
+
+	var reply, rpcErr json.RawMessage
+	err := conn.processCall(context.Background(), "getrawtransaction", rawArguments(`"`+frozenTestTxID+`"`, `true`), &reply, &rpcErr)
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if !strings.Contains(string(rpcErr), "txindex") {
+		t.Errorf("RPC error: %s", rpcErr)
+	}
+
+	// only the preflight lookup
+	if calls := backend.receivedFor("getrawtransaction"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestFrozenTransactionBlockHashRetry(t *testing.T) {
+	for _, hash := range []string{frozenTestAbove, frozenTestBelow} {
+		backend := newFakeBitcoind(t, 200000, frozenBackend)
+		lookup := func(ctx context.Context, txid string) (string, bool) {
+			return hash, true
+		}
+		conn := backend.connect(t, WithFrozenHeight(50), WithBlockHashRetry(lookup))
+
+		var reply, rpcErr json.RawMessage
+		err := conn.processCall(context.Background(), "getrawtransaction", rawArguments(`"`+frozenTestTxID+`"`, `true`), &reply, &rpcErr)
+		if frozenTestAbove == hash {
+			if !errors.Is(err, ErrBeyondFrozenHeight) {
+				t.Errorf("block above the frozen height: error: %v", err)
+			}
+			continue
+		}
+		if nil != err || !isNull(rpcErr) || !strings.Contains(string(reply), frozenTestTxID) {
+			t.Errorf("block below the frozen height: error: %v  RPC error: %s  reply: %s", err, rpcErr, reply)
+		}
+	}
+}
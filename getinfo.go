@@ -0,0 +1,119 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// answer getinfo for legacy clients when the daemon no longer has it,
+// building the old reply from getnetworkinfo, getblockchaininfo and,
+// if a wallet is loaded, getwalletinfo
+func WithGetInfoShim() Option {
+	return func(conn *RemoteConnection) {
+		conn.getInfoShim = true
+	}
+}
+
+// the getinfo reply of daemons before 0.16
+//
+// the wallet fields are omitted when there is no wallet
+type legacyInfo struct {
+	Version         json.Number `json:"version"`
+	ProtocolVersion json.Number `json:"protocolversion"`
+	WalletVersion   json.Number `json:"walletversion,omitempty"`
+	Balance         json.Number `json:"balance,omitempty"`
+	Blocks          json.Number `json:"blocks"`
+	TimeOffset      json.Number `json:"timeoffset"`
+	Connections     json.Number `json:"connections"`
+	Proxy           string      `json:"proxy"`
+	Difficulty      json.Number `json:"difficulty"`
+	Testnet         bool        `json:"testnet"`
+	KeypoolOldest   json.Number `json:"keypoololdest,omitempty"`
+	KeypoolSize     json.Number `json:"keypoolsize,omitempty"`
+	UnlockedUntil   json.Number `json:"unlocked_until,omitempty"`
+	PayTxFee        json.Number `json:"paytxfee,omitempty"`
+	RelayFee        json.Number `json:"relayfee"`
+	Errors          string      `json:"errors"`
+}
+
+// check if a getinfo call failed because the daemon does not have it
+func getInfoRemoved(err error, rpcErr *RPCError) bool {
+	var deprecated *MethodDeprecatedError
+	if errors.As(err, &deprecated) {
+		return true
+	}
+	return nil == err && nil != rpcErr && rpcMethodNotFound == rpcErr.Code
+}
+
+// build a getinfo reply from the methods that replaced it
+func (conn *RemoteConnection) synthesizeInfo(ctx context.Context) (json.RawMessage, error) {
+
+	var network struct {
+		Version         json.Number     `json:"version"`
+		ProtocolVersion json.Number     `json:"protocolversion"`
+		TimeOffset      json.Number     `json:"timeoffset"`
+		Connections     json.Number     `json:"connections"`
+		RelayFee        json.Number     `json:"relayfee"`
+		Warnings        json.RawMessage `json:"warnings"`
+		Networks        []struct {
+			Name  string `json:"name"`
+			Proxy string `json:"proxy"`
+		} `json:"networks"`
+	}
+	err := conn.directCall(ctx, "getnetworkinfo", []interface{}{}, &network)
+	if nil != err {
+		return nil, err
+	}
+
+	var chain ChainInfo
+	err = conn.directCall(ctx, "getblockchaininfo", []interface{}{}, &chain)
+	if nil != err {
+		return nil, err
+	}
+
+	info := legacyInfo{
+		Version:         network.Version,
+		ProtocolVersion: network.ProtocolVersion,
+		Blocks:          chain.Blocks,
+		TimeOffset:      network.TimeOffset,
+		Connections:     network.Connections,
+		Difficulty:      chain.Difficulty,
+		Testnet:         "test" == chain.Chain,
+		RelayFee:        network.RelayFee,
+		Errors:          strings.Join(parseWarnings(network.Warnings), "; "),
+	}
+	for _, n := range network.Networks {
+		if "ipv4" == n.Name {
+			info.Proxy = n.Proxy
+		}
+	}
+
+	// no loaded wallet is an RPC error, the wallet fields are then left out
+	var wallet struct {
+		WalletVersion json.Number `json:"walletversion"`
+		Balance       json.Number `json:"balance"`
+		KeypoolOldest json.Number `json:"keypoololdest"`
+		KeypoolSize   json.Number `json:"keypoolsize"`
+		UnlockedUntil json.Number `json:"unlocked_until"`
+		PayTxFee      json.Number `json:"paytxfee"`
+	}
+	err = conn.directCall(ctx, "getwalletinfo", []interface{}{}, &wallet)
+	if nil == err {
+		info.WalletVersion = wallet.WalletVersion
+		info.Balance = wallet.Balance
+		info.KeypoolOldest = wallet.KeypoolOldest
+		info.KeypoolSize = wallet.KeypoolSize
+		info.UnlockedUntil = wallet.UnlockedUntil
+		info.PayTxFee = wallet.PayTxFee
+	} else if !errors.Is(err, ErrRpcError) {
+		return nil, err
+	}
+
+	return json.Marshal(info)
+}
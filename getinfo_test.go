@@ -0,0 +1,86 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// a daemon without getinfo, with a wallet loaded if wallet is set
+func modernBackend(t *testing.T, wallet bool) *fakeBitcoind {
+	return newFakeBitcoind(t, 270000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		switch method {
+		case "getinfo":
+			return nil, &RPCError{Code: rpcMethodNotFound, Message: "Method not found"}
+		case "getnetworkinfo":
+			return json.RawMessage(`{"version": 270000, "subversion": "/Satoshi:27.0.0/", "protocolversion": 70016,
+			  "timeoffset": -2, "connections": 8, "relayfee": 0.00001000, "warnings": "",
+			  "networks": [{"name": "onion", "proxy": "127.0.0.1:9050"}, {"name": "ipv4", "proxy": "10.0.0.1:1080"}]}`), nil
+		case "getblockchaininfo":
+			return json.RawMessage(`{"chain": "regtest", "blocks": 2204, "difficulty": 4.656542373906925e-10}`), nil
+		case "getwalletinfo":
+			if !wallet {
+				return nil, &RPCError{Code: -18, Message: "No wallet is loaded."}
+			}
+			return json.RawMessage(`{"walletversion": 169900, "balance": 1.25000000, "keypoololdest": 1700000000,
+			  "keypoolsize": 1000, "paytxfee": 0.00000000}`), nil
+		}
+		return nil, nil
+	})
+}
+
+func TestGetInfoShim(t *testing.T) {
+	backend := modernBackend(t, true)
+	backend.connect(t, WithGetInfoShim())
+
+	result, _, err := RemoteCall("getinfo", nil)
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	expected := `{"version":270000,"protocolversion":70016,"walletversion":169900,"balance":1.25000000,` +
+		`"blocks":2204,"timeoffset":-2,"connections":8,"proxy":"10.0.0.1:1080","difficulty":4.656542373906925e-10,` +
+		`"testnet":false,"keypoololdest":1700000000,"keypoolsize":1000,"paytxfee":0.00000000,"relayfee":0.00001000,"errors":""}`
+	if expected != string(result) {
+		t.Errorf("info: %s\nexpected: %s", result, expected)
+	}
+	if calls := backend.receivedFor("getwalletinfo"); 0 == len(calls) {
+		t.Error("wallet not queried")
+	}
+}
+
+func TestGetInfoShimNoWallet(t *testing.T) {
+	backend := modernBackend(t, false)
+	backend.connect(t, WithGetInfoShim())
+
+	result, _, err := RemoteCall("getinfo", nil)
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	var fields map[string]json.RawMessage
+	json.Unmarshal(result, &fields)
+	for _, field := range []string{"walletversion", "balance", "keypoololdest", "keypoolsize", "paytxfee", "unlocked_until"} {
+		if _, ok := fields[field]; ok {
+			t.Errorf("wallet field %s present", field)
+		}
+	}
+	if "2204" != string(fields["blocks"]) || "270000" != string(fields["version"]) {
+		t.Errorf("info: %s", result)
+	}
+}
+
+func TestGetInfoShimOff(t *testing.T) {
+	backend := modernBackend(t, true)
+
+	// the daemon version cannot be found without the shim
+	conn, err := NewRemoteConnection(backend.URL, "user", "password", "regtest", nil)
+	if nil == err {
+		conn.Destroy()
+	}
+	if !errors.Is(err, ErrInvalidBitcoinVersion) {
+		t.Errorf("error: %v  expected: %v", err, ErrInvalidBitcoinVersion)
+	}
+}
@@ -5,12 +5,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Request struct {
@@ -32,14 +36,22 @@ type errorResult struct {
 }
 
 type aHandler struct {
-	arg string
+	arg           string
+	latencyHeader string // if not empty: response header for upstream round-trip time
+	noCacheHeader string // if not empty: request header to bypass the result cache
+	verbatim      bool   // write result and error bytes exactly as bitcoind sent them
+	sanitize      bool   // hide error detail from clients
 }
 
 // set up the HTTP handling
-func pageHandler(arg string) http.Handler {
+func pageHandler(arg string, latencyHeader string, noCacheHeader string, verbatim bool, sanitize bool) http.Handler {
 
 	return &aHandler{
-		arg: arg,
+		arg:           arg,
+		latencyHeader: latencyHeader,
+		noCacheHeader: noCacheHeader,
+		verbatim:      verbatim,
+		sanitize:      sanitize,
 	}
 }
 
@@ -73,6 +85,9 @@ func (f aHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	case "/rpc-call":
 		result = f.send(w, r, &data)
+		if nil == result {
+			return // not modified, no body
+		}
 
 	default:
 		err = errors.New("invalid path")
@@ -83,6 +98,13 @@ func (f aHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// json.Marshal would compact the raw messages
+	if reply, ok := result.(*theResult); ok && f.verbatim {
+		r.Header.Add("Content-Type", "application/json")
+		w.Write(verbatimReply(reply))
+		return
+	}
+
 	buffer, err := json.Marshal(result)
 	if nil != err {
 		log.Printf("json.Marshal result error: %v\n", err)
@@ -98,17 +120,41 @@ func (f aHandler) send(w http.ResponseWriter, r *http.Request, data *Request) in
 
 	//log.Printf("data: %v\n", data)
 
-	resp, rpcerr, err := RemoteCall(data.Method, data.Parameters)
+	ctx := context.Background()
+	if "" != f.noCacheHeader && noCache(r.Header.Get(f.noCacheHeader)) {
+		ctx = WithCacheBypass(ctx)
+	}
+	if etag := r.Header.Get("If-None-Match"); "" != etag {
+		ctx = WithKnownHash(ctx, strings.Trim(etag, `"`))
+	}
+	if f.sanitize {
+		ctx = WithSanitizedErrors(ctx)
+	}
+
+	resp, rpcerr, info, err := RemoteCallWithInfo(ctx, data.Method, data.Parameters)
+	if "" != f.latencyHeader && 0 != info.Upstream {
+		elapsed := info.Upstream / time.Millisecond
+		w.Header().Set(f.latencyHeader, strconv.FormatInt(int64(elapsed), 10))
+	}
 	//log.Printf("resp: %v\n", resp)
 	//log.Printf("resp: %s\n", resp)
 	//log.Printf("RPC error: %v\n", rpcerr)
 	//log.Printf("error: %v\n", err)
 
+	if errors.Is(err, ErrNotModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
 	if nil != err {
+		message := err.Error()
+		if f.sanitize {
+			log.Printf("%s error: %v\n", data.Method, err)
+			message = sanitizedDefault
+		}
 		return &errorResult{
 			ID:     data.ID,
 			Result: []byte{},
-			Error:  err.Error(),
+			Error:  message,
 		}
 	}
 
@@ -118,3 +164,31 @@ func (f aHandler) send(w http.ResponseWriter, r *http.Request, data *Request) in
 		Error:  rpcerr,
 	}
 }
+
+// encode a reply keeping the bytes of its raw messages unchanged
+func verbatimReply(reply *theResult) []byte {
+	raw := func(message json.RawMessage) []byte {
+		if 0 == len(message) {
+			return jsonNull
+		}
+		return message
+	}
+
+	buffer := []byte(`{"id":`)
+	buffer = append(buffer, raw(reply.ID)...)
+	buffer = append(buffer, `,"result":`...)
+	buffer = append(buffer, raw(reply.Result)...)
+	buffer = append(buffer, `,"error":`...)
+	buffer = append(buffer, raw(reply.Error)...)
+	return append(buffer, "}\n"...)
+}
+
+// check if a bypass header value asks to skip the cache
+func noCache(value string) bool {
+	switch value {
+	case "", "0", "false":
+		return false
+	default:
+		return true
+	}
+}
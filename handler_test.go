@@ -0,0 +1,173 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// post a call to the handler, with headers given as name and value pairs
+func postCall(handler http.Handler, body string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/rpc-call", strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestHandlerLatencyHeader(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		switch method {
+		case "getpeerinfo":
+			time.Sleep(200 * time.Millisecond)
+		case "getblockhash":
+			time.Sleep(50 * time.Millisecond)
+		}
+		return nil, nil
+	})
+	backend.connect(t)
+	handler := pageHandler("test", "X-Upstream-Duration-Ms", "", false, false)
+
+	// the call waits in the queue behind this one, which is not counted
+	go RemoteCall("getpeerinfo", nil)
+	eventually(t, "the first call to be sent", func() bool {
+		return 1 == len(backend.receivedFor("getpeerinfo"))
+	})
+
+	w := postCall(handler, `{"id":1,"method":"getblockhash","params":[8]}`)
+	header := w.Header().Get("X-Upstream-Duration-Ms")
+	elapsed, err := strconv.Atoi(header)
+	if nil != err {
+		t.Fatalf("header: %q", header)
+	}
+	if elapsed < 50 || elapsed >= 200 {
+		t.Errorf("upstream duration: %d ms", elapsed)
+	}
+}
+
+func TestHandlerLatencyHeaderCached(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t, WithMethodTTL("getblockhash", time.Minute))
+	t.Cleanup(func() {
+		setMethodTTL("getblockhash", 0)
+	})
+	handler := pageHandler("test", "X-Upstream-Duration-Ms", "", false, false)
+
+	body := `{"id":1,"method":"getblockhash","params":[12]}`
+	if w := postCall(handler, body); "" == w.Header().Get("X-Upstream-Duration-Ms") {
+		t.Error("no header for a forwarded call")
+	}
+	if w := postCall(handler, body); "" != w.Header().Get("X-Upstream-Duration-Ms") {
+		t.Errorf("header for a cached result: %q", w.Header().Get("X-Upstream-Duration-Ms"))
+	}
+}
+
+func TestHandlerNoCacheHeader(t *testing.T) {
+	emptyCache(t)
+	fetches := int64(0)
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockhash" == method {
+			return fmt.Sprintf("%064x", atomic.AddInt64(&fetches, 1)), nil
+		}
+		return nil, nil
+	})
+	backend.connect(t, WithMethodTTL("getblockhash", time.Minute))
+	t.Cleanup(func() {
+		setMethodTTL("getblockhash", 0)
+	})
+	handler := pageHandler("test", "", "X-No-Cache", false, false)
+	disabled := pageHandler("test", "", "", false, false)
+
+	body := `{"id":1,"method":"getblockhash","params":[21]}`
+	for i, item := range []struct {
+		handler  http.Handler
+		headers  []string
+		expected int64 // the fetch whose result is returned
+	}{
+		{handler, nil, 1},
+		{handler, nil, 1},                          // cached
+		{handler, []string{"X-No-Cache", "1"}, 2},  // bypassed
+		{handler, nil, 2},                          // repopulated
+		{handler, []string{"X-No-Cache", "0"}, 2},  // not a bypass
+		{disabled, []string{"X-No-Cache", "1"}, 2}, // not enabled
+	} {
+		w := postCall(item.handler, body, item.headers...)
+		var reply struct {
+			Result string `json:"result"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &reply)
+		if fmt.Sprintf("%064x", item.expected) != reply.Result {
+			t.Errorf("post %d: %s", i, w.Body.String())
+		}
+	}
+	if calls := backend.receivedFor("getblockhash"); 2 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+// a backend answering two methods with replies formatted unlike
+// json.Marshal, a result for getpeerinfo and an error for getblockhash
+func formattedBackend(t *testing.T) *fakeBitcoind {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var request fakeRequest
+		json.Unmarshal(body, &request)
+		switch request.Method {
+		case "getpeerinfo":
+			fmt.Fprintf(w, `{"result": [ {"id" : 7,  "addr": "10.0.0.1:8333", "conntime": 1.50} ], "error": null, "id": %s}`, request.ID)
+		case "getblockhash":
+			fmt.Fprintf(w, `{"result": null, "error": { "message" : "Block height out of range",  "code": -8 }, "id": %s}`, request.ID)
+		default:
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			backend.serve(w, r)
+		}
+	}))
+	t.Cleanup(backend.Server.Close)
+	return backend
+}
+
+func TestHandlerVerbatim(t *testing.T) {
+	backend := formattedBackend(t)
+	backend.connect(t)
+	verbatim := pageHandler("test", "", "", true, false)
+	reencoded := pageHandler("test", "", "", false, false)
+
+	for _, item := range []struct {
+		body      string
+		verbatim  string
+		reencoded string
+	}{
+		{
+			`{"id":"a","method":"getpeerinfo","params":[]}`,
+			`{"id":"a","result":[ {"id" : 7,  "addr": "10.0.0.1:8333", "conntime": 1.50} ],"error":null}` + "\n",
+			`{"id":"a","result":[{"id":7,"addr":"10.0.0.1:8333","conntime":1.50}],"error":null}` + "\n",
+		},
+		{
+			`{"id":2,"method":"getblockhash","params":[500]}`,
+			`{"id":2,"result":null,"error":{ "message" : "Block height out of range",  "code": -8 }}` + "\n",
+			`{"id":2,"result":null,"error":{"message":"Block height out of range","code":-8}}` + "\n",
+		},
+	} {
+		if w := postCall(verbatim, item.body); item.verbatim != w.Body.String() {
+			t.Errorf("verbatim: %q\nexpected: %q", w.Body.String(), item.verbatim)
+		}
+		if w := postCall(reencoded, item.body); item.reencoded != w.Body.String() {
+			t.Errorf("reencoded: %q\nexpected: %q", w.Body.String(), item.reencoded)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// coarse health of a connection
+type HealthStatus int
+
+const (
+	Healthy HealthStatus = iota
+	Degraded
+	Unavailable
+)
+
+func (status HealthStatus) String() string {
+	switch status {
+	case Healthy:
+		return "healthy"
+	case Degraded:
+		return "degraded"
+	case Unavailable:
+		return "unavailable"
+	default:
+		return "unknown"
+	}
+}
+
+// thresholds for deriving the status
+const (
+	healthWindow              = 20              // number of recent calls considered
+	healthDegradedFailures    = 2               // failures within the window
+	healthUnavailableFailures = 3               // consecutive failures
+	healthStaleTime           = 5 * time.Minute // failing with no success for this long
+)
+
+// record of recent call outcomes
+type health struct {
+	failed      [healthWindow]bool
+	next        int
+	failures    int
+	consecutive int
+	lastSuccess time.Time
+	status      HealthStatus
+	onChange    func(previous HealthStatus, current HealthStatus)
+}
+
+// call handler on every change of health status
+func WithHealthChangeHandler(handler func(previous HealthStatus, current HealthStatus)) Option {
+	return func(conn *RemoteConnection) {
+		conn.health.onChange = handler
+	}
+}
+
+// probe the backend with a no-argument method every interval
+// independently of live calls; while probes fail the connection is
+// marked unavailable and leaves the shared queue to the others
+func WithHealthProbe(method string, interval time.Duration) Option {
+	return func(conn *RemoteConnection) {
+		conn.probeMethod = method
+		conn.probeInterval = interval
+	}
+}
+
+// probe the backend with a no-argument method every interval and
+// take the connection out of rotation, so that it serves no shared
+// calls, from the first failed probe until one passes again
+//
+// stricter than WithHealthProbe which waits for repeated failures
+func WithHealthCheck(method string, interval time.Duration) Option {
+	return func(conn *RemoteConnection) {
+		conn.probeMethod = method
+		conn.probeInterval = interval
+		conn.rotationCheck = true
+	}
+}
+
+// check if the connection is serving the shared queues, false while
+// withdrawn by a failing health probe or health check
+func (conn *RemoteConnection) InRotation() bool {
+	conn.RLock()
+	defer conn.RUnlock()
+	return conn.inRotation()
+}
+
+// InRotation with the lock held
+func (conn *RemoteConnection) inRotation() bool {
+	return !conn.outOfRotation && !(conn.probeInterval > 0 && Unavailable == conn.health.status)
+}
+
+// call handler on each reconnect attempt made by the health probe
+// while the connection is out of rotation, recovered is true (and err nil)
+// for the attempt that brings it back; attempts continue until the
+// probe succeeds or the connection is destroyed so there is no final
+// failure and the attempt count restarts after each recovery
+//
+// has no effect without WithHealthProbe
+func WithReconnectHandler(handler func(attempt int, err error, recovered bool)) Option {
+	return func(conn *RemoteConnection) {
+		conn.onReconnect = handler
+	}
+}
+
+// current health status
+func (conn *RemoteConnection) Health() HealthStatus {
+	conn.RLock()
+	defer conn.RUnlock()
+	return conn.health.status
+}
+
+// update health from the result of a backend call
+func (conn *RemoteConnection) recordOutcome(err error) {
+
+	conn.Lock()
+
+	h := &conn.health
+	failed := nil != err
+	if h.failed[h.next] {
+		h.failures -= 1
+	}
+	h.failed[h.next] = failed
+	h.next = (h.next + 1) % healthWindow
+
+	if failed {
+		h.failures += 1
+		h.consecutive += 1
+	} else {
+		h.consecutive = 0
+		h.lastSuccess = time.Now()
+	}
+
+	status := Healthy
+	if h.consecutive >= healthUnavailableFailures {
+		status = Unavailable
+	} else if h.consecutive > 0 && !h.lastSuccess.IsZero() && time.Since(h.lastSuccess) > healthStaleTime {
+		status = Unavailable
+	} else if h.failures >= healthDegradedFailures {
+		status = Degraded
+	}
+
+	previous := h.status
+	h.status = status
+	onChange := h.onChange
+
+	conn.Unlock()
+
+	if nil != onChange && previous != status {
+		onChange(previous, status)
+	}
+}
+
+// run a single health probe, the outcome is recorded by remoteCall
+// (or here on a timeout) and, for a health check, decides whether the connection is in rotation
+//
+// limited by the request timeout, or else the probe interval, so a
+// hung backend cannot stall the background
+func (conn *RemoteConnection) probe() error {
+	timeout := conn.requestTimeout
+	if timeout <= 0 {
+		timeout = conn.probeInterval
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(conn.ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(conn.ctx)
+	}
+	defer cancel()
+
+	var reply json.RawMessage
+	var rpcErr json.RawMessage
+	start := time.Now()
+	err := conn.remoteCall(ctx, conn.probeMethod, []interface{}{}, &reply, &rpcErr)
+	conn.recordLatency(time.Since(start))
+
+	// remoteCall leaves out cancelled calls, a probe timing out is a failure
+	if nil != err && nil != ctx.Err() && nil == conn.ctx.Err() {
+		conn.recordOutcome(err)
+	}
+	if conn.rotationCheck {
+		conn.Lock()
+		conn.outOfRotation = nil != err
+		conn.Unlock()
+	}
+	return err
+}
+
+// probe a backend out of rotation and report the attempt
+// only called from the background
+func (conn *RemoteConnection) reconnect() {
+	conn.reconnectAttempt += 1
+	attempt := conn.reconnectAttempt
+
+	err := conn.probe()
+	recovered := nil == err
+	if recovered {
+		conn.reconnectAttempt = 0
+		go conn.warmUp(conn.ctx)
+	}
+
+	if nil != conn.onReconnect {
+		conn.onReconnect(attempt, err, recovered)
+	}
+}
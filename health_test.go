@@ -0,0 +1,212 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// wait for a condition, failing the test after a second
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !condition(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHealthProbeMarksDown(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockcount" == method {
+			return httpStatus(http.StatusServiceUnavailable), nil
+		}
+		return nil, nil
+	})
+	conn := backend.connect(t, WithHealthProbe("getblockcount", 10*time.Millisecond))
+
+	eventually(t, "probe to mark the backend down", func() bool {
+		return Unavailable == conn.Health()
+	})
+	if conn.InRotation() {
+		t.Error("unavailable backend still in rotation")
+	}
+	for _, call := range backend.received() {
+		if "getblockcount[]" != call {
+			t.Errorf("unexpected call: %s", call)
+		}
+	}
+}
+
+func TestHealthProbeTimeout(t *testing.T) {
+	release := make(chan struct{})
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockcount" == method {
+			<-release
+		}
+		return nil, nil
+	})
+	t.Cleanup(func() { close(release) })
+
+	conn, err := NewRemoteConnection(backend.URL, "user", "password", "regtest", nil,
+		WithHealthProbe("getblockcount", 10*time.Millisecond),
+		WithRequestTimeout(50*time.Millisecond),
+	)
+	if nil != err {
+		t.Fatalf("connect error: %v", err)
+	}
+
+	// a hung probe fails at the request timeout rather than stalling
+	eventually(t, "hung probes to mark the backend down", func() bool {
+		return Unavailable == conn.Health()
+	})
+
+	destroyed := make(chan struct{})
+	go func() {
+		conn.Destroy()
+		close(destroyed)
+	}()
+	select {
+	case <-destroyed:
+	case <-time.After(time.Second):
+		t.Fatal("destroy blocked by a hung probe")
+	}
+}
+
+func TestHealthTransitions(t *testing.T) {
+	failures := int64(3)
+	backend := failingBackend(t, http.StatusServiceUnavailable, &failures)
+	setTestTries(t, 1)
+
+	var mu sync.Mutex
+	changes := []string{}
+	conn := backend.connect(t, WithHealthChangeHandler(func(previous HealthStatus, current HealthStatus) {
+		mu.Lock()
+		changes = append(changes, previous.String()+"->"+current.String())
+		mu.Unlock()
+	}))
+	if Healthy != conn.Health() {
+		t.Fatalf("initial health: %s", conn.Health())
+	}
+
+	expected := []HealthStatus{Healthy, Degraded, Unavailable, Degraded}
+	for i, status := range expected {
+		RemoteCall("getblockhash", rawArguments(`8`))
+		if status != conn.Health() {
+			t.Errorf("after call %d health: %s  expected: %s", i+1, conn.Health(), status)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if 3 != len(changes) || "healthy->degraded" != changes[0] || "degraded->unavailable" != changes[1] || "unavailable->degraded" != changes[2] {
+		t.Errorf("changes: %v", changes)
+	}
+}
+
+// one call of a reconnect handler
+type reconnectEvent struct {
+	attempt   int
+	err       error
+	recovered bool
+}
+
+func TestReconnectHandler(t *testing.T) {
+	down := int64(6)
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockcount" == method && atomic.AddInt64(&down, -1) >= 0 {
+			return httpStatus(http.StatusServiceUnavailable), nil
+		}
+		return nil, nil
+	})
+
+	var lock sync.Mutex
+	events := []reconnectEvent{}
+	conn := backend.connect(t,
+		WithHealthProbe("getblockcount", 10*time.Millisecond),
+		WithReconnectHandler(func(attempt int, err error, recovered bool) {
+			lock.Lock()
+			events = append(events, reconnectEvent{attempt, err, recovered})
+			lock.Unlock()
+		}),
+	)
+
+	eventually(t, "a recovery", func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return 0 != len(events) && events[len(events)-1].recovered
+	})
+	if !conn.InRotation() {
+		t.Error("recovered connection out of rotation")
+	}
+
+	// three probes mark it down, the other three fail as reconnects
+	lock.Lock()
+	defer lock.Unlock()
+	if 4 != len(events) {
+		t.Fatalf("events: %+v", events)
+	}
+	for i, event := range events {
+		var httpErr *HTTPError
+		last := len(events)-1 == i
+		if i+1 != event.attempt || last != event.recovered {
+			t.Errorf("event %d: %+v", i, event)
+		}
+		if !last && (!errors.As(event.err, &httpErr) || http.StatusServiceUnavailable != httpErr.StatusCode) {
+			t.Errorf("event %d error: %v", i, event.err)
+		}
+		if last && nil != event.err {
+			t.Errorf("recovery error: %v", event.err)
+		}
+	}
+}
+
+func TestHealthCheckFlapping(t *testing.T) {
+	up := int32(1)
+	flapping := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockcount" == method && 0 == atomic.LoadInt32(&up) {
+			return httpStatus(http.StatusServiceUnavailable), nil
+		}
+		return nil, nil
+	})
+	stable := newFakeBitcoind(t, 200000, nil)
+	conn := flapping.connect(t, WithHealthCheck("getblockcount", 10*time.Millisecond))
+	stable.connect(t)
+
+	served := 0
+	for round := 0; round < 2; round += 1 {
+		atomic.StoreInt32(&up, 0)
+		eventually(t, "the endpoint to leave rotation", func() bool {
+			return !conn.InRotation()
+		})
+		before := len(flapping.receivedFor("getpeerinfo"))
+		for i := 0; i < 5; i += 1 {
+			_, _, err := RemoteCall("getpeerinfo", nil)
+			if nil != err {
+				t.Fatalf("error: %v", err)
+			}
+		}
+		if after := len(flapping.receivedFor("getpeerinfo")); before != after {
+			t.Errorf("round %d: endpoint out of rotation served %d calls", round, after-before)
+		}
+
+		atomic.StoreInt32(&up, 1)
+		eventually(t, "the endpoint to return to rotation", func() bool {
+			return conn.InRotation()
+		})
+		eventually(t, "the endpoint to serve a call", func() bool {
+			RemoteCall("getpeerinfo", nil)
+			return len(flapping.receivedFor("getpeerinfo")) > served
+		})
+		served = len(flapping.receivedFor("getpeerinfo"))
+	}
+}
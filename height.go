@@ -0,0 +1,256 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errors
+var (
+	ErrTimeAfterTip = errors.New("no block at or after time")
+	ErrLookupLimit  = errors.New("block time search exceeded lookup limit")
+)
+
+const (
+	heightPollInterval     = 2 * time.Second // delay between getblockcount polls in WaitForHeight
+	blockHashCacheSize     = 10000           // maximum cached height to hash entries
+	blockHashConfirmations = 6               // only cache hashes this far below the tip
+	blockHashByTimeLookups = 64              // bound on header fetches in BlockHashByTime
+)
+
+// height to hash cache
+type blockHashCache struct {
+	sync.Mutex
+	hashes map[uint64]string
+}
+
+// the most recently seen block height
+func (conn *RemoteConnection) LatestBlockNumber() uint64 {
+	conn.RLock()
+	defer conn.RUnlock()
+	return conn.latestBlockNumber
+}
+
+// time since the block height was last updated
+func (conn *RemoteConnection) LatestBlockAge() time.Duration {
+	conn.RLock()
+	defer conn.RUnlock()
+	return time.Since(conn.latestBlockTime)
+}
+
+// record a newly seen block height
+func (conn *RemoteConnection) setLatestBlockNumber(height uint64) {
+	conn.Lock()
+	conn.latestBlockNumber = height
+	conn.latestBlockTime = time.Now()
+	conn.Unlock()
+}
+
+// poll every interval to keep the latest height and chain status fresh
+func WithTipPoller(interval time.Duration) Option {
+	return func(conn *RemoteConnection) {
+		conn.pollInterval = interval
+	}
+}
+
+// background height polling
+func (conn *RemoteConnection) poller() {
+
+	ticker := time.NewTicker(conn.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-conn.shutdown:
+			return
+		case <-ticker.C:
+			conn.pollTip()
+		}
+	}
+}
+
+// a single poll, failures are left to the health tracking
+func (conn *RemoteConnection) pollTip() {
+	var height uint64
+	err := conn.call(conn.ctx, "getblockcount", []interface{}{}, &height)
+	if nil == err {
+		conn.setLatestBlockNumber(height)
+	}
+	info, err := conn.ChainInfo(conn.ctx)
+	if nil == err {
+		conn.setChainInfo(info)
+		conn.trackTip(conn.ctx, info)
+	}
+}
+
+// block until the backend tip reaches at least target
+// or the context is done
+func (conn *RemoteConnection) WaitForHeight(ctx context.Context, target uint64) error {
+
+	if conn.LatestBlockNumber() >= target {
+		return nil
+	}
+
+	ticker := time.NewTicker(heightPollInterval)
+	defer ticker.Stop()
+
+	for {
+		var height uint64
+		err := conn.call(ctx, "getblockcount", []interface{}{}, &height)
+		if nil != err {
+			return err
+		}
+		conn.setLatestBlockNumber(height)
+		if height >= target {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// the hash of the block at height, cached once it is deep enough
+func (conn *RemoteConnection) blockHash(ctx context.Context, height uint64) (string, error) {
+
+	cache := &conn.blockHashes
+	cache.Lock()
+	hash, ok := cache.hashes[height]
+	cache.Unlock()
+	if ok {
+		return hash, nil
+	}
+
+	err := conn.call(ctx, "getblockhash", []interface{}{height}, &hash)
+	if nil != err {
+		return "", err
+	}
+
+	if height+blockHashConfirmations <= conn.LatestBlockNumber() {
+		cache.Lock()
+		if nil == cache.hashes || len(cache.hashes) >= blockHashCacheSize {
+			cache.hashes = make(map[uint64]string)
+		}
+		cache.hashes[height] = hash
+		cache.Unlock()
+	}
+	return hash, nil
+}
+
+// the first block with a timestamp at or after unixTime
+//
+// a binary search over block header times between genesis and tip;
+// block times are not strictly increasing so near the boundary the
+// result is the one the search settles on
+func (conn *RemoteConnection) BlockHashByTime(ctx context.Context, unixTime int64) (uint64, string, error) {
+
+	var tip uint64
+	err := conn.call(ctx, "getblockcount", []interface{}{}, &tip)
+	if nil != err {
+		return 0, "", err
+	}
+	conn.setLatestBlockNumber(tip)
+
+	lookups := 0
+	blockTime := func(height uint64) (int64, string, error) {
+		lookups += 1
+		if lookups > blockHashByTimeLookups {
+			return 0, "", ErrLookupLimit
+		}
+		hash, err := conn.blockHash(ctx, height)
+		if nil != err {
+			return 0, "", err
+		}
+		var header struct {
+			Time int64 `json:"time"`
+		}
+		err = conn.call(ctx, "getblockheader", []interface{}{hash, true}, &header)
+		if nil != err {
+			return 0, "", err
+		}
+		return header.Time, hash, nil
+	}
+
+	t, hash, err := blockTime(tip)
+	if nil != err {
+		return 0, "", err
+	}
+	if t < unixTime {
+		return 0, "", ErrTimeAfterTip
+	}
+
+	// invariant: block at high, with hash, is at or after unixTime
+	low, high := uint64(0), tip
+	for low < high {
+		middle := low + (high-low)/2
+		t, h, err := blockTime(middle)
+		if nil != err {
+			return 0, "", err
+		}
+		if t >= unixTime {
+			high = middle
+			hash = h
+		} else {
+			low = middle + 1
+		}
+	}
+	return high, hash, nil
+}
+
+// find the heights from start to end inclusive that have no block hash,
+// e.g. above the tip or missing from a damaged node
+//
+// getblockhash is sent in batches of at most the connection's batch
+// size; a height is a gap if bitcoind rejected it, any failure to get
+// a reply at all is returned as an error
+func (conn *RemoteConnection) VerifyHeightRange(ctx context.Context, start uint64, end uint64) ([]uint64, error) {
+
+	err := conn.ensureBootstrap(ctx)
+	if nil != err {
+		return nil, err
+	}
+
+	gaps := []uint64{}
+	if start > end {
+		return gaps, nil
+	}
+
+	size := uint64(conn.batchSize())
+	for first := start; first <= end; first += size {
+		last := end
+		if end-first >= size {
+			last = first + size - 1
+		}
+
+		calls := make([]BatchCall, 0, last-first+1)
+		for height := first; height <= last; height += 1 {
+			calls = append(calls, BatchCall{
+				Method: "getblockhash",
+				Params: []interface{}{height},
+			})
+		}
+
+		for i, result := range conn.Batch(ctx, calls) {
+			if nil != result.Err {
+				return nil, result.Err
+			}
+			if nil != result.Error {
+				gaps = append(gaps, first+uint64(i))
+			}
+		}
+
+		if last == end {
+			break // avoid overflow at the maximum height
+		}
+	}
+	return gaps, nil
+}
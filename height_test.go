@@ -0,0 +1,232 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// a backend whose tip rises by step on each getblockcount
+func risingBackend(t *testing.T, start int64, step int64) *fakeBitcoind {
+	height := start - step
+	return newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockcount" == method {
+			return atomic.AddInt64(&height, step), nil
+		}
+		return nil, nil
+	})
+}
+
+func TestWaitForHeight(t *testing.T) {
+	backend := risingBackend(t, 100, 2)
+	conn := backend.connect(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := conn.WaitForHeight(ctx, 101)
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if 102 != conn.LatestBlockNumber() {
+		t.Errorf("latest block: %d", conn.LatestBlockNumber())
+	}
+	if calls := backend.receivedFor("getblockcount"); 2 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+
+	// a height already seen needs no call
+	err = conn.WaitForHeight(ctx, 102)
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if calls := backend.receivedFor("getblockcount"); 2 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestWaitForHeightContext(t *testing.T) {
+	backend := risingBackend(t, 100, 0)
+	conn := backend.connect(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := conn.WaitForHeight(ctx, 200)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error: %v  expected: %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestLatestBlockAge(t *testing.T) {
+	backend := risingBackend(t, 100, 1)
+	conn := backend.connect(t, WithTipPoller(200*time.Millisecond))
+
+	eventually(t, "the bootstrap height", func() bool {
+		return 100 == conn.LatestBlockNumber()
+	})
+	first := conn.LatestBlockAge()
+	time.Sleep(50 * time.Millisecond)
+	second := conn.LatestBlockAge()
+	if second < first+50*time.Millisecond {
+		t.Errorf("age %v then %v between polls", first, second)
+	}
+
+	eventually(t, "a polled height", func() bool {
+		return 101 == conn.LatestBlockNumber()
+	})
+	if age := conn.LatestBlockAge(); age >= second {
+		t.Errorf("age %v not reset from %v", age, second)
+	}
+}
+
+// a chain of 100 blocks where block h has time 1000+10h
+func timedChainBackend(t *testing.T) *fakeBitcoind {
+	return newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		switch method {
+		case "getblockcount":
+			return 100, nil
+		case "getblockhash":
+			height, _ := strconv.Atoi(string(params[0]))
+			return fmt.Sprintf("%064x", height), nil
+		case "getblockheader":
+			var hash string
+			json.Unmarshal(params[0], &hash)
+			height, _ := strconv.ParseInt(hash, 16, 64)
+			return map[string]interface{}{"hash": hash, "height": height, "time": 1000 + 10*height}, nil
+		}
+		return nil, nil
+	})
+}
+
+func TestBlockHashByTime(t *testing.T) {
+	backend := timedChainBackend(t)
+	conn := backend.connect(t)
+
+	for _, item := range []struct {
+		time   int64
+		height uint64
+	}{
+		{1505, 51},
+		{1500, 50},
+		{0, 0},
+		{1000, 0},
+		{2000, 100},
+	} {
+		height, hash, err := conn.BlockHashByTime(context.Background(), item.time)
+		if nil != err {
+			t.Errorf("time %d error: %v", item.time, err)
+			continue
+		}
+		if item.height != height || fmt.Sprintf("%064x", item.height) != hash {
+			t.Errorf("time %d found: %d %s  expected: %d", item.time, height, hash, item.height)
+		}
+	}
+
+	// a binary search, not a scan
+	if headers := len(backend.receivedFor("getblockheader")); headers > 5*(1+8) {
+		t.Errorf("%d headers fetched", headers)
+	}
+
+	_, _, err := conn.BlockHashByTime(context.Background(), 2001)
+	if !errors.Is(err, ErrTimeAfterTip) {
+		t.Errorf("error: %v  expected: %v", err, ErrTimeAfterTip)
+	}
+}
+
+func TestBlockHashByTimeCachesHashes(t *testing.T) {
+	backend := timedChainBackend(t)
+	conn := backend.connect(t)
+
+	for i := 0; i < 2; i += 1 {
+		_, _, err := conn.BlockHashByTime(context.Background(), 1505)
+		if nil != err {
+			t.Fatalf("error: %v", err)
+		}
+	}
+
+	// the second search finds the deep hashes cached
+	hashes := backend.receivedFor("getblockhash")
+	headers := backend.receivedFor("getblockheader")
+	if len(hashes) >= len(headers) {
+		t.Errorf("%d hashes fetched for %d headers", len(hashes), len(headers))
+	}
+}
+
+func TestVerifyHeightRange(t *testing.T) {
+	pruned := map[uint64]bool{3: true, 4: true, 9: true}
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockhash" != method {
+			return nil, nil
+		}
+		height, _ := strconv.ParseUint(string(params[0]), 10, 64)
+		if pruned[height] {
+			return nil, &RPCError{Code: rpcInvalidParameter, Message: "Block not available (pruned data)"}
+		}
+		return fmt.Sprintf("%064x", height), nil
+	})
+
+	// record the size of each batch before the fake answers it
+	batches := []int{}
+	backend.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var requests []fakeRequest
+		if nil == json.Unmarshal(body, &requests) {
+			backend.Lock()
+			batches = append(batches, len(requests))
+			backend.Unlock()
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		backend.serve(w, r)
+	}))
+	t.Cleanup(backend.Server.Close)
+	conn := backend.connect(t, WithMaxBatchSize(4))
+
+	gaps, err := conn.VerifyHeightRange(context.Background(), 1, 10)
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if "[3 4 9]" != fmt.Sprint(gaps) {
+		t.Errorf("gaps: %v", gaps)
+	}
+	backend.Lock()
+	sizes := fmt.Sprint(batches)
+	backend.Unlock()
+	if "[4 4 2]" != sizes {
+		t.Errorf("batch sizes: %s", sizes)
+	}
+	if calls := backend.receivedFor("getblockhash"); 10 != len(calls) {
+		t.Errorf("calls: %d", len(calls))
+	}
+
+	// an empty range sends nothing
+	gaps, err = conn.VerifyHeightRange(context.Background(), 5, 4)
+	if nil != err || 0 != len(gaps) {
+		t.Errorf("gaps: %v  error: %v", gaps, err)
+	}
+	if calls := backend.receivedFor("getblockhash"); 10 != len(calls) {
+		t.Errorf("calls: %d", len(calls))
+	}
+}
+
+func TestVerifyHeightRangeFailure(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	conn := backend.connect(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := conn.VerifyHeightRange(ctx, 1, 10); !errors.Is(err, context.Canceled) {
+		t.Errorf("error: %v  expected: %v", err, context.Canceled)
+	}
+}
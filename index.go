@@ -0,0 +1,84 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// errors
+var (
+	ErrIndexNotAvailable = errors.New("index not available")
+)
+
+// getindexinfo is available from this version
+const bitcoinIndexInfoVersion = 210000
+
+// index names as reported by getindexinfo
+const (
+	txIndex          = "txindex"
+	blockFilterIndex = "basic block filter index"
+)
+
+// a call was rejected locally because the daemon lacks an index it needs
+type IndexNotAvailableError struct {
+	Method string
+	Index  string
+}
+
+func (e *IndexNotAvailableError) Error() string {
+	return fmt.Sprintf("method %q needs %s which is not enabled on the daemon", e.Method, e.Index)
+}
+
+func (e *IndexNotAvailableError) Unwrap() error {
+	return ErrIndexNotAvailable
+}
+
+// query getindexinfo at bootstrap and reject calls that need an
+// index the daemon does not have instead of forwarding them
+//
+// daemons before 0.21 cannot report their indexes and are not gated
+func WithIndexGating() Option {
+	return func(conn *RemoteConnection) {
+		conn.indexGating = true
+	}
+}
+
+// fetch the set of enabled indexes from a daemon of the given version
+// older daemons lack getindexinfo so their indexes stay unknown
+func (conn *RemoteConnection) loadIndexes(ctx context.Context, version uint64) error {
+	if version < bitcoinIndexInfoVersion {
+		conn.indexes = nil
+		return nil
+	}
+
+	var reply map[string]struct {
+		Synced bool `json:"synced"`
+	}
+	err := conn.directCall(ctx, "getindexinfo", []interface{}{}, &reply)
+	if nil != err {
+		return fmt.Errorf("getindexinfo: %w", err)
+	}
+
+	conn.indexes = make(map[string]bool, len(reply))
+	for name := range reply {
+		conn.indexes[name] = true
+	}
+	return nil
+}
+
+// check that an index required by a method is present
+// always succeeds when gating is not enabled or the indexes are unknown
+func (conn *RemoteConnection) requireIndex(method string, index string) error {
+	if !conn.indexGating || nil == conn.indexes || conn.indexes[index] {
+		return nil
+	}
+	return &IndexNotAvailableError{
+		Method: method,
+		Index:  index,
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// a backend reporting the named indexes
+func indexBackend(t *testing.T, version uint64, indexes ...string) *fakeBitcoind {
+	return newFakeBitcoind(t, version, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getindexinfo" == method {
+			reply := map[string]interface{}{}
+			for _, index := range indexes {
+				reply[index] = map[string]interface{}{"synced": true, "best_block_height": 100}
+			}
+			return reply, nil
+		}
+		return nil, nil
+	})
+}
+
+func TestIndexGatingMissingTxIndex(t *testing.T) {
+	backend := indexBackend(t, 210000, blockFilterIndex)
+	backend.connect(t, WithIndexGating())
+
+	_, _, err := RemoteCall("getrawtransaction", rawArguments(`"`+testTxID+`"`))
+	if !errors.Is(err, ErrIndexNotAvailable) {
+		t.Fatalf("error: %v  expected: %v", err, ErrIndexNotAvailable)
+	}
+	var indexErr *IndexNotAvailableError
+	if !errors.As(err, &indexErr) || txIndex != indexErr.Index {
+		t.Errorf("error does not name %s: %v", txIndex, err)
+	}
+	if calls := backend.receivedFor("getrawtransaction"); 0 != len(calls) {
+		t.Errorf("rejected call forwarded: %v", calls)
+	}
+}
+
+func TestIndexGatingPresentTxIndex(t *testing.T) {
+	backend := indexBackend(t, 210000, txIndex)
+	backend.connect(t, WithIndexGating())
+
+	_, _, err := RemoteCall("getrawtransaction", rawArguments(`"`+testTxID+`"`))
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if calls := backend.receivedFor("getrawtransaction"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestIndexGatingOldDaemon(t *testing.T) {
+	backend := indexBackend(t, 200000)
+	backend.connect(t, WithIndexGating())
+
+	if calls := backend.receivedFor("getindexinfo"); 0 != len(calls) {
+		t.Errorf("getindexinfo sent to a daemon without it: %v", calls)
+	}
+
+	// unknown indexes do not reject calls
+	_, _, err := RemoteCall("getrawtransaction", rawArguments(`"`+testTxID+`"`))
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if calls := backend.receivedFor("getrawtransaction"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
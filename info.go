@@ -0,0 +1,175 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// how long a recorded blockchain status answers IsBlockAvailable
+const chainInfoFreshness = 10 * time.Second
+
+// decoded getblockchaininfo
+//
+// numbers are kept as given to avoid loss of precision,
+// PruneHeight is empty unless the node is pruned
+type ChainInfo struct {
+	Chain                string      `json:"chain"`
+	Blocks               json.Number `json:"blocks"`
+	Headers              json.Number `json:"headers"`
+	BestBlockHash        string      `json:"bestblockhash"`
+	Difficulty           json.Number `json:"difficulty"`
+	VerificationProgress json.Number `json:"verificationprogress"`
+	InitialBlockDownload bool        `json:"initialblockdownload"`
+	Pruned               bool        `json:"pruned"`
+	PruneHeight          json.Number `json:"pruneheight"`
+	SizeOnDisk           json.Number `json:"size_on_disk"`
+}
+
+// fetch the blockchain status from this connection
+func (conn *RemoteConnection) ChainInfo(ctx context.Context) (*ChainInfo, error) {
+	var info ChainInfo
+	err := conn.call(ctx, "getblockchaininfo", []interface{}{}, &info)
+	if nil != err {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// the blockchain status from bootstrap or the latest tip poll,
+// nil before bootstrap
+func (conn *RemoteConnection) LatestChainInfo() *ChainInfo {
+	conn.RLock()
+	defer conn.RUnlock()
+	if nil == conn.chainInfo {
+		return nil
+	}
+	info := *conn.chainInfo
+	return &info
+}
+
+// record a newly fetched blockchain status
+func (conn *RemoteConnection) setChainInfo(info *ChainInfo) {
+	conn.Lock()
+	conn.chainInfo = info
+	conn.chainInfoTime = time.Now()
+	conn.Unlock()
+}
+
+// true if the node has discarded old blocks
+func (conn *RemoteConnection) IsPruned() bool {
+	info := conn.LatestChainInfo()
+	return nil != info && info.Pruned
+}
+
+// lowest height with a stored block, zero for an unpruned node
+func (conn *RemoteConnection) PruneHeight() uint64 {
+	info := conn.LatestChainInfo()
+	if nil == info || !info.Pruned {
+		return 0
+	}
+	height, err := strconv.ParseUint(info.PruneHeight.String(), 10, 64)
+	if nil != err {
+		return 0
+	}
+	return height
+}
+
+// check if the block at a height is stored, i.e. at or below the tip
+// and not discarded by pruning
+//
+// the recorded blockchain status is used while it is fresh and
+// otherwise it is fetched again
+func (conn *RemoteConnection) IsBlockAvailable(ctx context.Context, height uint64) (bool, error) {
+
+	conn.RLock()
+	info := conn.chainInfo
+	fresh := time.Since(conn.chainInfoTime) < chainInfoFreshness
+	conn.RUnlock()
+
+	if nil == info || !fresh {
+		var err error
+		info, err = conn.ChainInfo(ctx)
+		if nil != err {
+			return false, err
+		}
+		conn.setChainInfo(info)
+	}
+
+	blocks, err := strconv.ParseUint(info.Blocks.String(), 10, 64)
+	if nil != err {
+		return false, ErrIncomprehesibleResponse
+	}
+	if height > blocks {
+		return false, nil
+	}
+	if !info.Pruned {
+		return true, nil
+	}
+	pruneHeight, err := strconv.ParseUint(info.PruneHeight.String(), 10, 64)
+	if nil != err {
+		return false, ErrIncomprehesibleResponse
+	}
+	return height >= pruneHeight, nil
+}
+
+// info methods that report a warnings field
+var warningMethods = []string{
+	"getblockchaininfo",
+	"getnetworkinfo",
+	"getmininginfo",
+}
+
+// collect the distinct warnings reported by the info methods
+//
+// a method the daemon does not support is skipped
+func (conn *RemoteConnection) Warnings(ctx context.Context) ([]string, error) {
+
+	warnings := []string{}
+	seen := make(map[string]bool)
+
+	for _, method := range warningMethods {
+		var reply struct {
+			Warnings json.RawMessage `json:"warnings"`
+		}
+		err := conn.call(ctx, method, []interface{}{}, &reply)
+		if errors.Is(err, ErrRpcError) {
+			continue
+		} else if nil != err {
+			return nil, err
+		}
+
+		for _, warning := range parseWarnings(reply.Warnings) {
+			if !seen[warning] {
+				seen[warning] = true
+				warnings = append(warnings, warning)
+			}
+		}
+	}
+	return warnings, nil
+}
+
+// warnings are a single string, empty for none, before Core 25
+// and an array of strings from Core 25
+func parseWarnings(raw json.RawMessage) []string {
+
+	var single string
+	if nil == json.Unmarshal(raw, &single) {
+		if "" == single {
+			return nil
+		}
+		return []string{single}
+	}
+
+	var list []string
+	if nil == json.Unmarshal(raw, &list) {
+		return list
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// a backend whose info methods report the given warnings, nil to
+// not support getmininginfo
+func warningBackend(t *testing.T, chain interface{}, network interface{}, mining interface{}) *fakeBitcoind {
+	return newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		switch method {
+		case "getblockchaininfo":
+			return map[string]interface{}{"chain": "regtest", "blocks": 100, "warnings": chain}, nil
+		case "getnetworkinfo":
+			return map[string]interface{}{"version": 200000, "subversion": "/Satoshi:test/", "warnings": network}, nil
+		case "getmininginfo":
+			if nil == mining {
+				return nil, &RPCError{Code: -32601, Message: "Method not found"}
+			}
+			return map[string]interface{}{"blocks": 100, "warnings": mining}, nil
+		}
+		return nil, nil
+	})
+}
+
+func TestWarningsString(t *testing.T) {
+	backend := warningBackend(t, "This is a pre-release test build", "", nil)
+	conn := backend.connect(t)
+
+	warnings, err := conn.Warnings(context.Background())
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if !reflect.DeepEqual([]string{"This is a pre-release test build"}, warnings) {
+		t.Errorf("warnings: %q", warnings)
+	}
+}
+
+func TestWarningsArray(t *testing.T) {
+	backend := warningBackend(t,
+		[]string{"unknown new rules activated", "pre-release build"},
+		[]string{"pre-release build"},
+		[]string{},
+	)
+	conn := backend.connect(t)
+
+	warnings, err := conn.Warnings(context.Background())
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if !reflect.DeepEqual([]string{"unknown new rules activated", "pre-release build"}, warnings) {
+		t.Errorf("warnings: %q", warnings)
+	}
+}
+
+func TestWarningsNone(t *testing.T) {
+	backend := warningBackend(t, "", []string{}, "")
+	conn := backend.connect(t)
+
+	warnings, err := conn.Warnings(context.Background())
+	if nil != err || 0 != len(warnings) {
+		t.Errorf("warnings: %q  error: %v", warnings, err)
+	}
+}
+
+// getblockchaininfo from a pruned regtest node
+const prunedChainInfo = `{
+  "chain": "regtest", "blocks": 2204, "headers": 2204,
+  "bestblockhash": "3fd3b8a4a6ad2a32ed4a7a8e5ab1bd1ec6bc8b2cd8f9b9b9f4e8e4e5d5c5b5a5",
+  "difficulty": 4.656542373906925e-10, "time": 1700000000, "mediantime": 1699999000,
+  "verificationprogress": 0.99999987654321012345, "initialblockdownload": false,
+  "chainwork": "00000000000000000000000000000000000000000000000000000000000011ba",
+  "size_on_disk": 123456789012345678, "pruned": true, "pruneheight": 1650,
+  "automatic_pruning": true, "prune_target_size": 576716800, "warnings": ""
+}`
+
+func TestChainInfoPruned(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockchaininfo" == method {
+			return json.RawMessage(prunedChainInfo), nil
+		}
+		return nil, nil
+	})
+	conn := backend.connect(t)
+
+	info, err := conn.ChainInfo(context.Background())
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	expected := ChainInfo{
+		Chain:                "regtest",
+		Blocks:               "2204",
+		Headers:              "2204",
+		BestBlockHash:        "3fd3b8a4a6ad2a32ed4a7a8e5ab1bd1ec6bc8b2cd8f9b9b9f4e8e4e5d5c5b5a5",
+		Difficulty:           "4.656542373906925e-10",
+		VerificationProgress: "0.99999987654321012345",
+		InitialBlockDownload: false,
+		Pruned:               true,
+		PruneHeight:          "1650",
+		SizeOnDisk:           "123456789012345678",
+	}
+	if expected != *info {
+		t.Errorf("info: %+v\nexpected: %+v", *info, expected)
+	}
+}
+
+func TestChainInfoUnpruned(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	conn := backend.connect(t)
+
+	info, err := conn.ChainInfo(context.Background())
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if "regtest" != info.Chain || "100" != info.Blocks || info.Pruned || "" != info.PruneHeight {
+		t.Errorf("info: %+v", *info)
+	}
+}
+
+func TestPruningRefreshed(t *testing.T) {
+	pruned := int32(1)
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockchaininfo" == method && 1 == atomic.LoadInt32(&pruned) {
+			return json.RawMessage(prunedChainInfo), nil
+		}
+		return nil, nil
+	})
+	conn := backend.connect(t, WithTipPoller(10*time.Millisecond))
+
+	// captured at bootstrap
+	if !conn.IsPruned() || 1650 != conn.PruneHeight() || "123456789012345678" != conn.LatestChainInfo().SizeOnDisk.String() {
+		t.Errorf("pruned: %v  prune height: %d  info: %+v", conn.IsPruned(), conn.PruneHeight(), conn.LatestChainInfo())
+	}
+	for _, item := range []struct {
+		height    uint64
+		available bool
+	}{
+		{1649, false},
+		{1650, true},
+		{2204, true},
+		{2205, false},
+	} {
+		available, err := conn.IsBlockAvailable(context.Background(), item.height)
+		if nil != err || item.available != available {
+			t.Errorf("height: %d  available: %v  error: %v", item.height, available, err)
+		}
+	}
+
+	// and refreshed by the poller
+	atomic.StoreInt32(&pruned, 0)
+	eventually(t, "the unpruned status to be polled", func() bool {
+		return !conn.IsPruned()
+	})
+	if 0 != conn.PruneHeight() {
+		t.Errorf("prune height: %d", conn.PruneHeight())
+	}
+}
+
+func TestBlockAvailability(t *testing.T) {
+	for _, item := range []struct {
+		name    string
+		info    string
+		heights map[uint64]bool
+	}{
+		{"unpruned", "", map[uint64]bool{0: true, 1: true, 100: true, 101: false}},
+		{"pruned", prunedChainInfo, map[uint64]bool{0: false, 1649: false, 1650: true, 2204: true, 2205: false}},
+	} {
+		t.Run(item.name, func(t *testing.T) {
+			fetches := int64(0)
+			backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+				if "getblockchaininfo" != method {
+					return nil, nil
+				}
+				atomic.AddInt64(&fetches, 1)
+				if "" == item.info {
+					return nil, nil
+				}
+				return json.RawMessage(item.info), nil
+			})
+			conn := backend.connect(t)
+
+			// answered from the status recorded at bootstrap
+			before := atomic.LoadInt64(&fetches)
+			for height, expected := range item.heights {
+				available, err := conn.IsBlockAvailable(context.Background(), height)
+				if nil != err || expected != available {
+					t.Errorf("height: %d  available: %v  error: %v", height, available, err)
+				}
+			}
+			if fetched := atomic.LoadInt64(&fetches) - before; 0 != fetched {
+				t.Errorf("%d fetches while fresh", fetched)
+			}
+
+			// and fetched again once stale
+			conn.Lock()
+			conn.chainInfoTime = time.Now().Add(-chainInfoFreshness)
+			conn.Unlock()
+			if _, err := conn.IsBlockAvailable(context.Background(), 1); nil != err {
+				t.Errorf("error: %v", err)
+			}
+			if fetched := atomic.LoadInt64(&fetches) - before; 1 != fetched {
+				t.Errorf("%d fetches when stale", fetched)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// errors
+var (
+	ErrTooManyInFlight = errors.New("too many calls in flight")
+	ErrMethodBusy      = errors.New("method concurrency limit reached")
+)
+
+// a concurrency limit, a max of zero is no limit
+type limit struct {
+	max      int
+	failFast bool
+}
+
+// counts the slots taken so that changing the limit never lets more
+// calls run than the new limit allows, held slots are still counted
+//
+// limits are set directly or by connections made with an option,
+// which only count while the connection exists; the lowest applies
+type limiter struct {
+	sync.Mutex
+	limits map[*RemoteConnection]limit // the nil key is the direct limit
+	limit  limit                       // the one in effect
+	used   int
+	freed  chan struct{} // closed when a slot is freed or the limit changes
+}
+
+func newLimiter() *limiter {
+	return &limiter{
+		limits: make(map[*RemoteConnection]limit),
+		freed:  make(chan struct{}),
+	}
+}
+
+// set or, with a max of zero, remove the limit of an owner
+func (l *limiter) set(owner *RemoteConnection, max int, failFast bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	if max <= 0 {
+		delete(l.limits, owner)
+	} else {
+		l.limits[owner] = limit{max: max, failFast: failFast}
+	}
+
+	l.limit = limit{}
+	for _, lim := range l.limits {
+		if 0 == l.limit.max || lim.max < l.limit.max {
+			l.limit = lim
+		} else if lim.max == l.limit.max && lim.failFast {
+			l.limit.failFast = true
+		}
+	}
+	l.wake()
+}
+
+// let waiting calls check the limit again
+func (l *limiter) wake() {
+	close(l.freed)
+	l.freed = make(chan struct{})
+}
+
+// take a slot, failing with busy or waiting for one as the limit says
+func (l *limiter) acquire(ctx context.Context, busy error) error {
+	for {
+		l.Lock()
+		if 0 == l.limit.max || l.used < l.limit.max {
+			l.used += 1
+			l.Unlock()
+			return nil
+		}
+		if l.limit.failFast {
+			l.Unlock()
+			return busy
+		}
+		freed := l.freed
+		l.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// give back a slot
+func (l *limiter) release() {
+	l.Lock()
+	defer l.Unlock()
+	l.used -= 1
+	l.wake()
+}
+
+// limit on calls in flight across all connections
+var inFlightLimit = newLimiter()
+
+// current number of calls in flight
+var inFlight int64
+
+// limit the number of RemoteCalls in flight to max, calls over the limit
+// either fail with ErrTooManyInFlight or wait for a slot
+//
+// the limit covers the calls to all connections while this one exists,
+// as calls are limited before a connection is chosen; with several
+// limits the lowest applies
+func WithMaxInFlight(max int, failFast bool) Option {
+	return func(conn *RemoteConnection) {
+		conn.maxInFlight = limit{max: max, failFast: failFast}
+	}
+}
+
+// set the in-flight limit not tied to a connection, a max of zero
+// removes it
+func setMaxInFlight(max int, failFast bool) {
+	inFlightLimit.set(nil, max, failFast)
+}
+
+// the number of RemoteCalls currently in flight
+func InFlight() int {
+	return int(atomic.LoadInt64(&inFlight))
+}
+
+// take an in-flight slot, the returned function releases it
+func acquireInFlight(ctx context.Context) (func(), error) {
+	err := inFlightLimit.acquire(ctx, ErrTooManyInFlight)
+	if nil != err {
+		return nil, err
+	}
+
+	reportInFlight(atomic.AddInt64(&inFlight, 1))
+	return func() {
+		reportInFlight(atomic.AddInt64(&inFlight, -1))
+		inFlightLimit.release()
+	}, nil
+}
+
+// per-method concurrency limits shared by all connections
+var methodLimits = struct {
+	sync.Mutex
+	limiters map[string]*limiter
+	inFlight map[string]int
+}{
+	limiters: make(map[string]*limiter),
+	inFlight: make(map[string]int),
+}
+
+// limit the number of concurrent RemoteCalls of a method to max
+// calls over the limit wait for a slot
+//
+// calls are limited before a connection is chosen, so the limit is
+// shared by all connections while this one exists; with several
+// limits for the method the lowest applies
+func WithMethodConcurrency(method string, max int) Option {
+	return func(conn *RemoteConnection) {
+		if nil == conn.methodLimits {
+			conn.methodLimits = make(map[string]limit)
+		}
+		conn.methodLimits[method] = limit{max: max}
+	}
+}
+
+// limit the number of concurrent RemoteCalls of a method to max, e.g.
+// 1 for an expensive scan; calls over the limit either fail with
+// ErrMethodBusy or wait for a slot, as for WithMaxInFlight; a max of
+// zero removes the limit
+func SetMethodLimit(method string, max int, failFast bool) {
+	methodLimiter(method).set(nil, max, failFast)
+}
+
+// the limiter of a method, kept once made so held slots stay counted
+func methodLimiter(method string) *limiter {
+	methodLimits.Lock()
+	defer methodLimits.Unlock()
+
+	l := methodLimits.limiters[method]
+	if nil == l {
+		l = newLimiter()
+		methodLimits.limiters[method] = l
+	}
+	return l
+}
+
+// start applying the limits set by the connection's options
+func (conn *RemoteConnection) joinLimits() {
+	if conn.maxInFlight.max > 0 {
+		inFlightLimit.set(conn, conn.maxInFlight.max, conn.maxInFlight.failFast)
+	}
+	for method, lim := range conn.methodLimits {
+		methodLimiter(method).set(conn, lim.max, lim.failFast)
+	}
+}
+
+// stop applying the limits set by the connection's options
+func (conn *RemoteConnection) leaveLimits() {
+	if conn.maxInFlight.max > 0 {
+		inFlightLimit.set(conn, 0, false)
+	}
+	for method := range conn.methodLimits {
+		methodLimiter(method).set(conn, 0, false)
+	}
+}
+
+// the number of RemoteCalls of a method currently in flight
+func MethodInFlight(method string) int {
+	methodLimits.Lock()
+	defer methodLimits.Unlock()
+	return methodLimits.inFlight[method]
+}
+
+// take a slot for the method, the returned function releases it
+func acquireMethod(ctx context.Context, method string) (func(), error) {
+	methodLimits.Lock()
+	l := methodLimits.limiters[method]
+	methodLimits.Unlock()
+
+	if nil != l {
+		err := l.acquire(ctx, fmt.Errorf("%w: %s", ErrMethodBusy, method))
+		if nil != err {
+			return nil, err
+		}
+	}
+
+	methodLimits.Lock()
+	methodLimits.inFlight[method] += 1
+	methodLimits.Unlock()
+
+	return func() {
+		methodLimits.Lock()
+		methodLimits.inFlight[method] -= 1
+		if 0 == methodLimits.inFlight[method] {
+			delete(methodLimits.inFlight, method)
+		}
+		methodLimits.Unlock()
+		if nil != l {
+			l.release()
+		}
+	}, nil
+}
@@ -0,0 +1,396 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a backend holding calls of one method until released, counting how
+// many of them run at once
+type heldBackend struct {
+	*fakeBitcoind
+	release chan struct{}
+
+	sync.Mutex
+	running int
+	most    int
+}
+
+func newHeldBackend(t *testing.T, method string) *heldBackend {
+	h := &heldBackend{
+		release: make(chan struct{}),
+	}
+	h.fakeBitcoind = newFakeBitcoind(t, 200000, func(m string, params []json.RawMessage) (interface{}, *RPCError) {
+		if method != m {
+			return nil, nil
+		}
+		h.Lock()
+		h.running += 1
+		if h.running > h.most {
+			h.most = h.running
+		}
+		h.Unlock()
+		<-h.release
+		h.Lock()
+		h.running -= 1
+		h.Unlock()
+		return nil, nil
+	})
+	t.Cleanup(h.done)
+	return h
+}
+
+// let the held calls finish, safe to call more than once
+func (h *heldBackend) done() {
+	select {
+	case <-h.release:
+	default:
+		close(h.release)
+	}
+}
+
+// the current and largest number of held calls running at once
+func (h *heldBackend) counts() (int, int) {
+	h.Lock()
+	defer h.Unlock()
+	return h.running, h.most
+}
+
+func TestMethodConcurrency(t *testing.T) {
+	backend := newHeldBackend(t, "getpeerinfo")
+	backend.connect(t, WithMethodConcurrency("getpeerinfo", 1))
+	backend.connect(t)
+	backend.connect(t)
+	t.Cleanup(func() {
+		SetMethodLimit("getpeerinfo", 0, false)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			RemoteCall("getpeerinfo", nil)
+		}()
+	}
+	eventually(t, "a call to start", func() bool {
+		running, _ := backend.counts()
+		return 1 == running
+	})
+
+	// other methods still have free connections
+	_, _, err := RemoteCall("getblockhash", rawArguments(`8`))
+	if nil != err {
+		t.Errorf("error: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if running, _ := backend.counts(); 1 != running {
+		t.Errorf("%d calls running", running)
+	}
+
+	backend.done()
+	wg.Wait()
+	if _, most := backend.counts(); 1 != most {
+		t.Errorf("%d calls ran at once", most)
+	}
+	if calls := backend.receivedFor("getpeerinfo"); 3 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestMethodConcurrencyNewConnection(t *testing.T) {
+	backend := newHeldBackend(t, "getpeerinfo")
+	backend.connect(t, WithMethodConcurrency("getpeerinfo", 1))
+	for i := 0; i < 3; i += 1 {
+		backend.connect(t)
+	}
+
+	var wg sync.WaitGroup
+	call := func() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			RemoteCall("getpeerinfo", nil)
+		}()
+	}
+	call()
+	eventually(t, "a call to start", func() bool {
+		running, _ := backend.counts()
+		return 1 == running
+	})
+
+	// the same limit from another connection keeps the held slot
+	backend.connect(t, WithMethodConcurrency("getpeerinfo", 1))
+	call()
+	call()
+	time.Sleep(50 * time.Millisecond)
+	if running, _ := backend.counts(); 1 != running {
+		t.Errorf("%d calls running", running)
+	}
+
+	backend.done()
+	wg.Wait()
+	if _, most := backend.counts(); 1 != most {
+		t.Errorf("%d calls ran at once", most)
+	}
+}
+
+func TestMethodConcurrencyDestroyed(t *testing.T) {
+	backend := newHeldBackend(t, "getpeerinfo")
+	limited, err := NewRemoteConnection(backend.URL, "user", "password", backend.chain, nil, WithMethodConcurrency("getpeerinfo", 1))
+	if nil != err {
+		t.Fatalf("connect error: %v", err)
+	}
+	backend.connect(t)
+	backend.connect(t)
+
+	// the limit goes with the connection that set it
+	limited.DestroyWithTimeout(time.Second)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			RemoteCall("getpeerinfo", nil)
+		}()
+	}
+	eventually(t, "both calls to start", func() bool {
+		running, _ := backend.counts()
+		return 2 == running
+	})
+	backend.done()
+	wg.Wait()
+}
+
+func TestMaxInFlightFailFast(t *testing.T) {
+	backend := newHeldBackend(t, "getpeerinfo")
+	backend.connect(t, WithMaxInFlight(1, true))
+	backend.connect(t)
+	t.Cleanup(func() {
+		setMaxInFlight(0, false)
+		SetMetrics(Metrics{})
+	})
+
+	var gauge sync.Mutex
+	counts := []int{}
+	SetMetrics(Metrics{
+		InFlight: func(count int) {
+			gauge.Lock()
+			counts = append(counts, count)
+			gauge.Unlock()
+		},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		RemoteCall("getpeerinfo", nil)
+		close(done)
+	}()
+	eventually(t, "a call to start", func() bool {
+		running, _ := backend.counts()
+		return 1 == running
+	})
+	if 1 != InFlight() {
+		t.Errorf("%d calls in flight", InFlight())
+	}
+
+	_, _, err := RemoteCall("getblockhash", rawArguments(`8`))
+	if !errors.Is(err, ErrTooManyInFlight) {
+		t.Errorf("error: %v  expected: %v", err, ErrTooManyInFlight)
+	}
+	if calls := backend.receivedFor("getblockhash"); 0 != len(calls) {
+		t.Errorf("rejected call forwarded: %v", calls)
+	}
+
+	backend.done()
+	<-done
+	gauge.Lock()
+	defer gauge.Unlock()
+	if 2 != len(counts) || 1 != counts[0] || 0 != counts[1] {
+		t.Errorf("in-flight gauge: %v", counts)
+	}
+}
+
+func TestMaxInFlightQueues(t *testing.T) {
+	backend := newHeldBackend(t, "getpeerinfo")
+	backend.connect(t, WithMaxInFlight(1, false))
+	backend.connect(t)
+	t.Cleanup(func() {
+		setMaxInFlight(0, false)
+	})
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, errs[i] = RemoteCall("getpeerinfo", nil)
+		}(i)
+	}
+	eventually(t, "a call to start", func() bool {
+		running, _ := backend.counts()
+		return 1 == running
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	backend.done()
+	wg.Wait()
+	for _, err := range errs {
+		if nil != err {
+			t.Errorf("error: %v", err)
+		}
+	}
+	if _, most := backend.counts(); 1 != most {
+		t.Errorf("%d calls ran at once", most)
+	}
+}
+
+func TestMaxInFlightNewConnection(t *testing.T) {
+	backend := newHeldBackend(t, "getpeerinfo")
+	backend.connect(t, WithMaxInFlight(1, true))
+	backend.connect(t)
+
+	done := make(chan struct{})
+	go func() {
+		RemoteCall("getpeerinfo", nil)
+		close(done)
+	}()
+	eventually(t, "a call to start", func() bool {
+		running, _ := backend.counts()
+		return 1 == running
+	})
+
+	// neither the same limit again nor a connection without one
+	// frees a slot
+	backend.connect(t, WithMaxInFlight(1, true))
+	backend.connect(t)
+	_, _, err := RemoteCall("getblockhash", rawArguments(`8`))
+	if !errors.Is(err, ErrTooManyInFlight) {
+		t.Errorf("error: %v  expected: %v", err, ErrTooManyInFlight)
+	}
+
+	// nor does a looser limit
+	backend.connect(t, WithMaxInFlight(5, true))
+	_, _, err = RemoteCall("getblockhash", rawArguments(`9`))
+	if !errors.Is(err, ErrTooManyInFlight) {
+		t.Errorf("error: %v  expected: %v", err, ErrTooManyInFlight)
+	}
+
+	backend.done()
+	<-done
+}
+
+func TestMaxInFlightDestroyed(t *testing.T) {
+	backend := newHeldBackend(t, "getpeerinfo")
+	limited, err := NewRemoteConnection(backend.URL, "user", "password", backend.chain, nil, WithMaxInFlight(1, true))
+	if nil != err {
+		t.Fatalf("connect error: %v", err)
+	}
+	backend.connect(t)
+	backend.connect(t)
+
+	// the limit goes with the connection that set it
+	limited.DestroyWithTimeout(time.Second)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			RemoteCall("getpeerinfo", nil)
+		}()
+	}
+	eventually(t, "both calls to start", func() bool {
+		running, _ := backend.counts()
+		return 2 == running
+	})
+	backend.done()
+	wg.Wait()
+}
+
+func TestMethodLimitBusy(t *testing.T) {
+	emptyCache(t)
+	backend := newHeldBackend(t, "getblockhash")
+	backend.connect(t)
+	backend.connect(t)
+	SetMethodLimit("getblockhash", 1, true)
+	t.Cleanup(func() {
+		SetMethodLimit("getblockhash", 0, false)
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := RemoteCall("getblockhash", rawArguments(`1`))
+		done <- err
+	}()
+	eventually(t, "a call to start", func() bool {
+		running, _ := backend.counts()
+		return 1 == running
+	})
+	if 1 != MethodInFlight("getblockhash") {
+		t.Errorf("%d in flight", MethodInFlight("getblockhash"))
+	}
+
+	// the limited method is busy
+	_, _, err := RemoteCall("getblockhash", rawArguments(`2`))
+	if !errors.Is(err, ErrMethodBusy) {
+		t.Errorf("error: %v  expected: %v", err, ErrMethodBusy)
+	}
+	if calls := backend.receivedFor("getblockhash"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+
+	// while others flow freely
+	if _, _, err := RemoteCall("getpeerinfo", nil); nil != err {
+		t.Errorf("error: %v", err)
+	}
+
+	backend.done()
+	if err := <-done; nil != err {
+		t.Errorf("error: %v", err)
+	}
+	if 0 != MethodInFlight("getblockhash") {
+		t.Errorf("%d in flight", MethodInFlight("getblockhash"))
+	}
+	if _, _, err := RemoteCall("getblockhash", rawArguments(`3`)); nil != err {
+		t.Errorf("error: %v", err)
+	}
+}
+
+func TestMethodLimitWaitCancelled(t *testing.T) {
+	emptyCache(t)
+	backend := newHeldBackend(t, "getblockhash")
+	backend.connect(t)
+	backend.connect(t)
+	SetMethodLimit("getblockhash", 1, false)
+	t.Cleanup(func() {
+		SetMethodLimit("getblockhash", 0, false)
+	})
+
+	go RemoteCall("getblockhash", rawArguments(`1`))
+	eventually(t, "a call to start", func() bool {
+		running, _ := backend.counts()
+		return 1 == running
+	})
+
+	// a call waiting for a slot gives up with its context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err := RemoteCallContext(ctx, "getblockhash", rawArguments(`2`))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error: %v  expected: %v", err, context.DeadlineExceeded)
+	}
+	if calls := backend.receivedFor("getblockhash"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
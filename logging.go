@@ -0,0 +1,151 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// destination for diagnostic messages
+type Logger interface {
+	Debugf(format string, arguments ...interface{})
+}
+
+// send diagnostic messages to logger
+func WithLogger(logger Logger) Option {
+	return func(conn *RemoteConnection) {
+		conn.logger = logger
+	}
+}
+
+// log every call at debug level, arguments and results are each
+// truncated to limit bytes and sensitive data is redacted
+func WithCallLogging(limit int) Option {
+	return func(conn *RemoteConnection) {
+		conn.logLimit = limit
+	}
+}
+
+// replacement for anything that must not be logged
+const redactedText = "[redacted]"
+
+// methods whose arguments and results are never logged
+var redactedMethods = map[string]bool{
+	"dumpprivkey":               true,
+	"dumpwallet":                true,
+	"encryptwallet":             true,
+	"importprivkey":             true,
+	"importwallet":              true,
+	"sethdseed":                 true,
+	"signrawtransactionwithkey": true,
+	"signmessagewithprivkey":    true,
+	"walletpassphrase":          true,
+	"walletpassphrasechange":    true,
+}
+
+// object fields whose values are never logged at any depth
+var redactedFields = map[string]bool{
+	"hdseed":     true,
+	"passphrase": true,
+	"privkey":    true,
+	"privkeys":   true,
+	"seed":       true,
+}
+
+// write a debug line for a completed call
+func (conn *RemoteConnection) logCall(call *Call, reply json.RawMessage, rpcErr json.RawMessage, err error) {
+	if nil == conn.logger || conn.logLimit <= 0 {
+		return
+	}
+
+	method := call.Method
+	arguments := call.Arguments
+
+	args := redactedText
+	result := redactedText
+	if !redactedMethods[method] {
+		args = conn.logText(argumentList(arguments))
+		result = conn.logText(reply)
+		if nil != rpcErr {
+			result = conn.logText(rpcErr)
+		}
+	}
+	metadata := CallMetadataFrom(call.Context)
+	if 0 == len(metadata) {
+		conn.logger.Debugf("call: %s args: %s result: %s error: %v", method, args, result, err)
+		return
+	}
+	conn.logger.Debugf("call: %s args: %s result: %s error: %v metadata: %s", method, args, result, err, metadata)
+}
+
+// the redacted and truncated form of a value
+func (conn *RemoteConnection) logText(value json.RawMessage) string {
+	return truncatedText(redactJSON(value), conn.logLimit)
+}
+
+// text cut to limit bytes with the full length noted
+func truncatedText(text []byte, limit int) string {
+	if len(text) <= limit {
+		return string(text)
+	}
+	return fmt.Sprintf("%s...(%d bytes)", text[:limit], len(text))
+}
+
+// the arguments of a call as one JSON array
+func argumentList(arguments []json.RawMessage) []byte {
+	var list bytes.Buffer
+	list.WriteByte('[')
+	for i, argument := range arguments {
+		if 0 != i {
+			list.WriteByte(',')
+		}
+		list.Write(argument)
+	}
+	list.WriteByte(']')
+	return list.Bytes()
+}
+
+// replace the values of sensitive fields
+func redactJSON(value json.RawMessage) []byte {
+
+	trimmed := bytes.TrimSpace(value)
+	if 0 == len(trimmed) || ('{' != trimmed[0] && '[' != trimmed[0]) {
+		return trimmed
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.UseNumber()
+	var decoded interface{}
+	if nil != decoder.Decode(&decoded) {
+		// unparsable, so cannot be checked
+		return []byte(redactedText)
+	}
+	redacted, err := json.Marshal(redactValue(decoded))
+	if nil != err {
+		return []byte(redactedText)
+	}
+	return redacted
+}
+
+// replace sensitive fields within a decoded value
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			if redactedFields[key] {
+				v[key] = redactedText
+			} else {
+				v[key] = redactValue(item)
+			}
+		}
+	}
+	return value
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// a logger keeping its lines
+type testLogger struct {
+	sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) Debugf(format string, arguments ...interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, arguments...))
+}
+
+// the lines logged for calls of a method
+func (l *testLogger) callLines(method string) []string {
+	l.Lock()
+	defer l.Unlock()
+	lines := []string{}
+	for _, line := range l.lines {
+		if strings.HasPrefix(line, "call: "+method+" ") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestCallLoggingRedactsMethod(t *testing.T) {
+	logger := &testLogger{}
+	backend := newFakeBitcoind(t, 200000, nil)
+	conn := backend.connect(t, WithLogger(logger), WithCallLogging(100))
+
+	var reply, rpcErr json.RawMessage
+	conn.runCall(&Call{Context: context.Background(), Method: "dumpprivkey", Arguments: rawArguments(`"bcrt1qsecretaddress"`)}, &reply, &rpcErr)
+
+	lines := logger.callLines("dumpprivkey")
+	if 1 != len(lines) {
+		t.Fatalf("lines: %v", logger.lines)
+	}
+	if strings.Contains(lines[0], "secretaddress") || !strings.Contains(lines[0], "args: "+redactedText) {
+		t.Errorf("line: %s", lines[0])
+	}
+}
+
+func TestCallLoggingRedactsFields(t *testing.T) {
+	logger := &testLogger{}
+	backend := newFakeBitcoind(t, 250000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "scanblocks" == method {
+			return map[string]interface{}{"relevant_blocks": []string{strings.Repeat("ab", 64)}}, nil
+		}
+		return nil, nil
+	})
+	conn := backend.connect(t, WithLogger(logger), WithCallLogging(60))
+
+	var reply, rpcErr json.RawMessage
+	arguments := rawArguments(`"start"`, `[{"desc":"addr(x)","privkey":"cVsecretkey"}]`)
+	err := conn.runCall(&Call{Context: context.Background(), Method: "scanblocks", Arguments: arguments}, &reply, &rpcErr)
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+
+	lines := logger.callLines("scanblocks")
+	if 1 != len(lines) {
+		t.Fatalf("lines: %v", logger.lines)
+	}
+	line := lines[0]
+	if strings.Contains(line, "cVsecretkey") || !strings.Contains(line, `"privkey":"[redacted]"`) || !strings.Contains(line, "addr(x)") {
+		t.Errorf("arguments not redacted: %s", line)
+	}
+	if !strings.Contains(line, fmt.Sprintf("...(%d bytes)", len(reply))) {
+		t.Errorf("result not truncated: %s", line)
+	}
+}
+
+func TestCallLoggingDisabled(t *testing.T) {
+	logger := &testLogger{}
+	backend := newFakeBitcoind(t, 200000, nil)
+	conn := backend.connect(t, WithLogger(logger))
+
+	var reply, rpcErr json.RawMessage
+	conn.runCall(&Call{Context: context.Background(), Method: "getpeerinfo"}, &reply, &rpcErr)
+	if lines := logger.callLines("getpeerinfo"); 0 != len(lines) {
+		t.Errorf("lines: %v", lines)
+	}
+}
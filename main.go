@@ -18,14 +18,18 @@ import (
 
 // global system configuration for the program
 type SystemConfiguration struct {
-	Listen        string                `libucl:"listen"`         // e.g. "127.0.0.1:1234"
-	ServerName    string                `libucl:"server_name"`    // e.g. "proxy.domain.tld"
-	ClientAuth    bool                  `libucl:"client_auth"`    // e.g. true (then clients must use certificate)
-	CACertificate string                `libucl:"ca_certificate"` // e.g. "ca.crt"
-	Certificate   string                `libucl:"certificate"`    // e.g. "server.crt"
-	PrivateKey    string                `libucl:"private_key"`    // e.g. "server.key"
-	RunAs         RunAsConfiguration    `libucl:"run_as"`         // currently only applies to FreeBSD
-	Chain         string                `libucl:"chain"`          // e.g. "testnet" or "livenet"
+	Listen        string                `libucl:"listen"`          // e.g. "127.0.0.1:1234"
+	ServerName    string                `libucl:"server_name"`     // e.g. "proxy.domain.tld"
+	ClientAuth    bool                  `libucl:"client_auth"`     // e.g. true (then clients must use certificate)
+	CACertificate string                `libucl:"ca_certificate"`  // e.g. "ca.crt"
+	Certificate   string                `libucl:"certificate"`     // e.g. "server.crt"
+	PrivateKey    string                `libucl:"private_key"`     // e.g. "server.key"
+	RunAs         RunAsConfiguration    `libucl:"run_as"`          // currently only applies to FreeBSD
+	Chain         string                `libucl:"chain"`           // e.g. "testnet" or "livenet"
+	LatencyHeader string                `libucl:"latency_header"`  // e.g. "X-Upstream-Duration-Ms" (empty to disable)
+	NoCacheHeader string                `libucl:"no_cache_header"` // e.g. "X-No-Cache" (empty to disable)
+	Verbatim      bool                  `libucl:"verbatim"`        // e.g. true (results are passed through byte for byte)
+	Sanitize      bool                  `libucl:"sanitize_errors"` // e.g. true (clients get generic error messages)
 	Remotes       []RemoteConfiguration `libucl:"remotes"`
 }
 
@@ -42,6 +46,8 @@ type RemoteConfiguration struct {
 	PrivateKey    string `libucl:"private_key"`    // e.g. "client.key"
 	URL           string `libucl:"url"`            // e.g. "http://127.0.0.1:17001" or https and use certificates/key
 	ServerName    string `libucl:"server_name"`    // e.g. "proxy.domain.tld"
+	Role          string `libucl:"role"`           // e.g. "read", "write" or "both" (default)
+	NodeControl   bool   `libucl:"node_control"`   // e.g. true (allow addnode and getaddednodeinfo)
 }
 
 // entry point
@@ -62,7 +68,7 @@ func main() {
 
 	server := &http.Server{
 		Addr:           system.Listen,
-		Handler:        pageHandler("dummy argument"),
+		Handler:        pageHandler("dummy argument", system.LatencyHeader, system.NoCacheHeader, system.Verbatim, system.Sanitize),
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: 1 << 20,
@@ -139,7 +145,17 @@ func main() {
 			log.Printf("remote[%d] invalid URL: %q\n", i, remote.URL)
 		}
 
-		rpcconn, err := NewRemoteConnection(remote.URL, remote.Username, remote.Password, system.Chain, tlsConfiguration)
+		role, err := ParseRole(remote.Role)
+		if nil != err {
+			log.Fatalf("remote[%d] role: %q error: %v\n", i, remote.Role, err)
+		}
+
+		options := []Option{WithRole(role)}
+		if remote.NodeControl {
+			options = append(options, WithNodeControl())
+		}
+
+		rpcconn, err := NewRemoteConnection(remote.URL, remote.Username, remote.Password, system.Chain, tlsConfiguration, options...)
 		if ErrAccessDenied == err {
 			log.Printf("remote[%d] %q error: %v\n", i, remote.URL, err)
 			continueRunning = false
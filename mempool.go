@@ -0,0 +1,258 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// how long a fetched verbose mempool is reused
+const mempoolCacheTime = 2 * time.Second
+
+// fee-rate bucket, MaxFeeRate is zero for the unbounded last bucket
+type FeeBucket struct {
+	MinFeeRate float64 `json:"min_fee_rate"` // sat/vB
+	MaxFeeRate float64 `json:"max_fee_rate"` // sat/vB
+	Count      int     `json:"count"`
+	VSize      uint64  `json:"vsize"`
+}
+
+// a verbose mempool entry, fees are in satoshis
+//
+// Depends and SpentBy are the txids of in-mempool parents and
+// children, BIP125Replaceable is nil if the daemon does not report it
+type MempoolEntry struct {
+	VSize             uint64
+	Weight            uint64
+	Fees              MempoolFees
+	AncestorCount     uint64
+	DescendantCount   uint64
+	Time              int64
+	Depends           []string
+	SpentBy           []string
+	BIP125Replaceable *bool
+}
+
+// fees of a mempool entry in satoshis
+type MempoolFees struct {
+	Base       int64
+	Modified   int64
+	Ancestor   int64
+	Descendant int64
+}
+
+// the verbose entry as sent by bitcoind
+//
+// older bitcoind have fee and modifiedfee in BTC with ancestorfees and
+// descendantfees in satoshis, newer have all of them in the fees object
+type mempoolEntryReply struct {
+	VSize           uint64      `json:"vsize"`
+	Size            uint64      `json:"size"`
+	Fee             json.Number `json:"fee"`
+	ModifiedFee     json.Number `json:"modifiedfee"`
+	AncestorFees    int64       `json:"ancestorfees"`
+	DescendantFees  int64       `json:"descendantfees"`
+	AncestorCount   uint64      `json:"ancestorcount"`
+	DescendantCount uint64      `json:"descendantcount"`
+	Time            int64       `json:"time"`
+	Weight          uint64      `json:"weight"`
+	Depends         []string    `json:"depends"`
+	SpentBy         []string    `json:"spentby"`
+	Replaceable     *bool       `json:"bip125-replaceable"`
+	Fees            *struct {
+		Base       json.Number `json:"base"`
+		Modified   json.Number `json:"modified"`
+		Ancestor   json.Number `json:"ancestor"`
+		Descendant json.Number `json:"descendant"`
+	} `json:"fees"`
+}
+
+// decode either form of a verbose mempool entry
+func (entry *MempoolEntry) UnmarshalJSON(data []byte) error {
+
+	var reply mempoolEntryReply
+	err := json.Unmarshal(data, &reply)
+	if nil != err {
+		return err
+	}
+
+	entry.VSize = reply.VSize
+	if 0 == entry.VSize {
+		entry.VSize = reply.Size // before vsize was added
+	}
+	entry.AncestorCount = reply.AncestorCount
+	entry.DescendantCount = reply.DescendantCount
+	entry.Time = reply.Time
+	entry.Weight = reply.Weight
+	entry.Depends = reply.Depends
+	entry.SpentBy = reply.SpentBy
+	entry.BIP125Replaceable = reply.Replaceable
+
+	if nil == reply.Fees {
+		entry.Fees.Ancestor = reply.AncestorFees
+		entry.Fees.Descendant = reply.DescendantFees
+		if err := setAmount(&entry.Fees.Base, reply.Fee); nil != err {
+			return err
+		}
+		return setAmount(&entry.Fees.Modified, reply.ModifiedFee)
+	}
+
+	if err := setAmount(&entry.Fees.Base, reply.Fees.Base); nil != err {
+		return err
+	}
+	if err := setAmount(&entry.Fees.Modified, reply.Fees.Modified); nil != err {
+		return err
+	}
+	if err := setAmount(&entry.Fees.Ancestor, reply.Fees.Ancestor); nil != err {
+		return err
+	}
+	return setAmount(&entry.Fees.Descendant, reply.Fees.Descendant)
+}
+
+// convert a BTC amount to satoshis, an absent amount is left as zero
+func setAmount(satoshis *int64, amount json.Number) error {
+	if "" == amount {
+		return nil
+	}
+	value, err := btcToSatoshis(amount)
+	if nil != err {
+		return err
+	}
+	*satoshis = value
+	return nil
+}
+
+// fetch the mempool entry of an unconfirmed transaction
+func GetMempoolEntry(txid string) (*MempoolEntry, error) {
+
+	hash, err := json.Marshal(txid)
+	if nil != err {
+		return nil, err
+	}
+
+	result, rpcErr, err := RemoteCall("getmempoolentry", []json.RawMessage{hash})
+	if nil != err {
+		return nil, err
+	}
+
+	var entry MempoolEntry
+	err = decodeResult(result, rpcErr, &entry)
+	if nil != err {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// the verbose mempool of this connection
+func (conn *RemoteConnection) MempoolEntries(ctx context.Context) (map[string]MempoolEntry, error) {
+	var entries map[string]MempoolEntry
+	err := conn.call(ctx, "getrawmempool", []interface{}{true}, &entries)
+	if nil != err {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// the lowest fee rate in sat/vB the node will accept into its mempool:
+// the larger of the static minrelaytxfee and the dynamic mempoolminfee
+// which rises while the mempool is full
+func (conn *RemoteConnection) MinRelayFee(ctx context.Context) (float64, error) {
+	var info struct {
+		MempoolMinFee json.Number `json:"mempoolminfee"` // BTC/kvB
+		MinRelayTxFee json.Number `json:"minrelaytxfee"` // BTC/kvB
+	}
+	err := conn.call(ctx, "getmempoolinfo", []interface{}{}, &info)
+	if nil != err {
+		return 0, err
+	}
+
+	minimum := 0.0
+	for _, rate := range []json.Number{info.MempoolMinFee, info.MinRelayTxFee} {
+		if "" == rate {
+			continue
+		}
+		satPerVByte, err := feeRateToSatPerVByte(rate)
+		if nil != err {
+			return 0, err
+		}
+		if satPerVByte > minimum {
+			minimum = satPerVByte
+		}
+	}
+	return minimum, nil
+}
+
+// mempool fee-rate histogram
+//
+// buckets are lower bounds in sat/vB, each bucket runs up to the next
+// bound and the last is unbounded, entries below the first are ignored
+func MempoolFeeHistogram(buckets []float64) ([]FeeBucket, error) {
+
+	entries, err := fetchMempoolFees()
+	if nil != err {
+		return nil, err
+	}
+
+	bounds := append([]float64{}, buckets...)
+	sort.Float64s(bounds)
+
+	histogram := make([]FeeBucket, len(bounds))
+	for i, bound := range bounds {
+		histogram[i].MinFeeRate = bound
+		if i+1 < len(bounds) {
+			histogram[i].MaxFeeRate = bounds[i+1]
+		}
+	}
+
+	for _, entry := range entries {
+		if 0 == entry.VSize {
+			continue
+		}
+		rate := float64(entry.Fees.Base) / float64(entry.VSize)
+
+		// first bound above the rate, bucket is the one before
+		i := sort.SearchFloat64s(bounds, rate)
+		if i < len(bounds) && bounds[i] == rate {
+			i += 1
+		}
+		if 0 == i {
+			continue
+		}
+		histogram[i-1].Count += 1
+		histogram[i-1].VSize += entry.VSize
+	}
+	return histogram, nil
+}
+
+// fetch the verbose mempool, reusing a recent fetch from the cache
+func fetchMempoolFees() (map[string]MempoolEntry, error) {
+
+	arguments := []json.RawMessage{json.RawMessage("true")}
+	key := callKey("getrawmempool", arguments)
+
+	result, ok := cacheLookup(key)
+	if !ok {
+		var rpcErr json.RawMessage
+		var err error
+		result, rpcErr, err = RemoteCall("getrawmempool", arguments)
+		if nil != err {
+			return nil, err
+		}
+		if !isNull(rpcErr) {
+			return nil, rpcErrorFrom(rpcErr)
+		}
+		cacheStore(key, result, mempoolCacheTime)
+	}
+
+	var entries map[string]MempoolEntry
+	err := json.Unmarshal(result, &entries)
+	if nil != err {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// a verbose mempool with fee rates of 0.5, 1, 5, 5 and 12 sat/vB
+const testMempool = `{
+  "a1": {"vsize": 200, "weight": 800, "time": 1, "ancestorcount": 1, "descendantcount": 1, "depends": [], "spentby": [],
+         "fees": {"base": 0.00000100, "modified": 0.00000100, "ancestor": 0.00000100, "descendant": 0.00000100}},
+  "a2": {"vsize": 100, "weight": 400, "time": 2, "ancestorcount": 1, "descendantcount": 1, "depends": [], "spentby": [],
+         "fees": {"base": 0.00000100, "modified": 0.00000100, "ancestor": 0.00000100, "descendant": 0.00000100}},
+  "a3": {"vsize": 100, "weight": 400, "time": 3, "ancestorcount": 1, "descendantcount": 1, "depends": [], "spentby": [],
+         "fees": {"base": 0.00000500, "modified": 0.00000500, "ancestor": 0.00000500, "descendant": 0.00000500}},
+  "a4": {"vsize": 300, "weight": 1200, "time": 4, "ancestorcount": 1, "descendantcount": 1, "depends": [], "spentby": [],
+         "fees": {"base": 0.00001500, "modified": 0.00001500, "ancestor": 0.00001500, "descendant": 0.00001500}},
+  "a5": {"vsize": 150, "weight": 600, "time": 5, "ancestorcount": 1, "descendantcount": 1, "depends": [], "spentby": [],
+         "fees": {"base": 0.00001800, "modified": 0.00001800, "ancestor": 0.00001800, "descendant": 0.00001800}}
+}`
+
+// a backend answering getrawmempool with a verbose mempool
+func mempoolBackend(t *testing.T, mempool string) *fakeBitcoind {
+	return newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getrawmempool" == method {
+			return json.RawMessage(mempool), nil
+		}
+		return nil, nil
+	})
+}
+
+func TestMempoolFeeHistogram(t *testing.T) {
+	emptyCache(t)
+	backend := mempoolBackend(t, testMempool)
+	backend.connect(t)
+
+	expected := []FeeBucket{
+		{MinFeeRate: 1, MaxFeeRate: 5, Count: 1, VSize: 100},
+		{MinFeeRate: 5, MaxFeeRate: 10, Count: 2, VSize: 400},
+		{MinFeeRate: 10, MaxFeeRate: 0, Count: 1, VSize: 150},
+	}
+	for i := 0; i < 2; i += 1 {
+		histogram, err := MempoolFeeHistogram([]float64{10, 1, 5})
+		if nil != err {
+			t.Fatalf("error: %v", err)
+		}
+		if len(expected) != len(histogram) {
+			t.Fatalf("histogram: %+v", histogram)
+		}
+		for j := range expected {
+			if expected[j] != histogram[j] {
+				t.Errorf("bucket %d: %+v  expected: %+v", j, histogram[j], expected[j])
+			}
+		}
+	}
+
+	// the second histogram reuses the fetched mempool
+	if calls := backend.receivedFor("getrawmempool"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestMempoolEntriesNewFees(t *testing.T) {
+	backend := mempoolBackend(t, testMempool)
+	conn := backend.connect(t)
+
+	entries, err := conn.MempoolEntries(context.Background())
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if 5 != len(entries) {
+		t.Fatalf("entries: %+v", entries)
+	}
+	entry := entries["a4"]
+	expected := MempoolFees{Base: 1500, Modified: 1500, Ancestor: 1500, Descendant: 1500}
+	if expected != entry.Fees {
+		t.Errorf("fees: %+v  expected: %+v", entry.Fees, expected)
+	}
+	if 300 != entry.VSize || 1200 != entry.Weight || 4 != entry.Time || 1 != entry.AncestorCount || 1 != entry.DescendantCount {
+		t.Errorf("entry: %+v", entry)
+	}
+	if calls := backend.receivedFor("getrawmempool"); 1 != len(calls) || "getrawmempool[true]" != calls[0] {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestMempoolEntriesOldFees(t *testing.T) {
+	backend := mempoolBackend(t, `{
+  "b1": {"size": 250, "fee": 0.00002500, "modifiedfee": 0.00003000, "time": 7, "height": 99,
+         "descendantcount": 2, "descendantsize": 400, "descendantfees": 4000,
+         "ancestorcount": 3, "ancestorsize": 600, "ancestorfees": 6000, "depends": ["b0"]}
+}`)
+	conn := backend.connect(t)
+
+	entries, err := conn.MempoolEntries(context.Background())
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	entry, ok := entries["b1"]
+	if !ok || 1 != len(entries) {
+		t.Fatalf("entries: %+v", entries)
+	}
+	expected := MempoolFees{Base: 2500, Modified: 3000, Ancestor: 6000, Descendant: 4000}
+	if expected != entry.Fees {
+		t.Errorf("fees: %+v  expected: %+v", entry.Fees, expected)
+	}
+	if 250 != entry.VSize || 7 != entry.Time || 3 != entry.AncestorCount || 2 != entry.DescendantCount {
+		t.Errorf("entry: %+v", entry)
+	}
+	if 1 != len(entry.Depends) || "b0" != entry.Depends[0] {
+		t.Errorf("depends: %v", entry.Depends)
+	}
+}
+
+// a backend answering getmempoolinfo with the given fee rates in BTC/kvB
+func relayFeeBackend(t *testing.T, mempoolMinFee string, minRelayTxFee string) *fakeBitcoind {
+	return newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getmempoolinfo" == method {
+			return json.RawMessage(`{"loaded": true, "size": 10, "mempoolminfee": ` + mempoolMinFee + `, "minrelaytxfee": ` + minRelayTxFee + `}`), nil
+		}
+		return nil, nil
+	})
+}
+
+func TestMinRelayFee(t *testing.T) {
+	for _, item := range []struct {
+		mempoolMinFee string
+		minRelayTxFee string
+		expected      float64
+	}{
+		{"0.00001000", "0.00001000", 1},
+		{"0.00002500", "0.00001000", 2.5},
+		{"0.00001000", "0.00000100", 1},
+		{"0.00000100", "0.00000100", 0.1},
+		{"0.00012345", "0.00001000", 12.345},
+	} {
+		t.Run(item.mempoolMinFee+"/"+item.minRelayTxFee, func(t *testing.T) {
+			backend := relayFeeBackend(t, item.mempoolMinFee, item.minRelayTxFee)
+			conn := backend.connect(t)
+
+			fee, err := conn.MinRelayFee(context.Background())
+			if nil != err {
+				t.Fatalf("error: %v", err)
+			}
+			if item.expected != fee {
+				t.Errorf("fee: %v  expected: %v", fee, item.expected)
+			}
+		})
+	}
+}
+
+func TestMinRelayFeeInvalid(t *testing.T) {
+	backend := relayFeeBackend(t, "-0.00001000", "0.00001000")
+	conn := backend.connect(t)
+
+	_, err := conn.MinRelayFee(context.Background())
+	if !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("error: %v  expected: %v", err, ErrInvalidAmount)
+	}
+}
+
+// getmempoolentry from a recent daemon, with the fees object
+const testMempoolEntry = `{
+  "vsize": 141, "weight": 561, "time": 1700000000, "height": 100,
+  "descendantcount": 2, "descendantsize": 282, "ancestorcount": 1, "ancestorsize": 141,
+  "wtxid": "` + testTxID + `",
+  "fees": {"base": 0.00000705, "modified": 0.00001705, "ancestor": 0.00000705, "descendant": 0.00001410},
+  "depends": [], "spentby": ["c0ffee00c0ffee00c0ffee00c0ffee00c0ffee00c0ffee00c0ffee00c0ffee00"],
+  "bip125-replaceable": true, "unbroadcast": false
+}`
+
+func TestGetMempoolEntry(t *testing.T) {
+	backend := newFakeBitcoind(t, 250000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getmempoolentry" == method {
+			return json.RawMessage(testMempoolEntry), nil
+		}
+		return nil, nil
+	})
+	backend.connect(t)
+
+	entry, err := GetMempoolEntry(testTxID)
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	expected := MempoolFees{Base: 705, Modified: 1705, Ancestor: 705, Descendant: 1410}
+	if expected != entry.Fees {
+		t.Errorf("fees: %+v  expected: %+v", entry.Fees, expected)
+	}
+	if 141 != entry.VSize || 561 != entry.Weight || 1 != entry.AncestorCount || 2 != entry.DescendantCount || 1700000000 != entry.Time {
+		t.Errorf("entry: %+v", entry)
+	}
+	if 0 != len(entry.Depends) || 1 != len(entry.SpentBy) || "c0ffee00" != entry.SpentBy[0][:8] {
+		t.Errorf("depends: %v  spent by: %v", entry.Depends, entry.SpentBy)
+	}
+	if nil == entry.BIP125Replaceable || !*entry.BIP125Replaceable {
+		t.Errorf("replaceable: %v", entry.BIP125Replaceable)
+	}
+	if calls := backend.receivedFor("getmempoolentry"); 1 != len(calls) || `getmempoolentry["`+testTxID+`"]` != calls[0] {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestGetMempoolEntryNotFound(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getmempoolentry" == method {
+			return nil, &RPCError{Code: -5, Message: "Transaction not in mempool"}
+		}
+		return nil, nil
+	})
+	backend.connect(t)
+
+	entry, err := GetMempoolEntry(testTxID)
+	var rpcErr *RPCError
+	if nil != entry || !errors.As(err, &rpcErr) || -5 != rpcErr.Code {
+		t.Errorf("entry: %+v  error: %v", entry, err)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// caller supplied values carried with a call to the logging and
+// metrics hooks for correlation, never sent to bitcoind
+type CallMetadata map[string]string
+
+// key for WithCorrelationID
+const CorrelationIDKey = "correlation_id"
+
+type metadataKey struct{}
+
+// attach metadata to calls made with the context, adding to or
+// replacing any already attached
+func WithCallMetadata(ctx context.Context, metadata CallMetadata) context.Context {
+	merged := CallMetadata{}
+	for key, value := range CallMetadataFrom(ctx) {
+		merged[key] = value
+	}
+	for key, value := range metadata {
+		merged[key] = value
+	}
+	return context.WithValue(ctx, metadataKey{}, merged)
+}
+
+// attach a correlation id to calls made with the context
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return WithCallMetadata(ctx, CallMetadata{CorrelationIDKey: id})
+}
+
+// the metadata attached to a context, nil if none
+func CallMetadataFrom(ctx context.Context) CallMetadata {
+	if nil == ctx {
+		return nil
+	}
+	metadata, _ := ctx.Value(metadataKey{}).(CallMetadata)
+	return metadata
+}
+
+// key=value pairs in key order
+func (metadata CallMetadata) String() string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + metadata[key]
+	}
+	return strings.Join(pairs, " ")
+}
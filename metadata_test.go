@@ -0,0 +1,59 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCorrelationIDLogged(t *testing.T) {
+	logger := &testLogger{}
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t, WithLogger(logger), WithCallLogging(100))
+
+	ctx := WithCorrelationID(context.Background(), "req-42")
+	_, _, err := RemoteCallContext(ctx, "getblockhash", rawArguments(`42`))
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	_, _, err = RemoteCall("getblockhash", rawArguments(`43`))
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+
+	lines := logger.callLines("getblockhash")
+	if 2 != len(lines) {
+		t.Fatalf("lines: %v", lines)
+	}
+	for _, line := range lines {
+		tagged := strings.HasSuffix(line, "metadata: correlation_id=req-42")
+		if strings.Contains(line, "[42]") != tagged || strings.Contains(line, "metadata:") != tagged {
+			t.Errorf("line: %s", line)
+		}
+	}
+
+	// only the arguments are sent
+	for _, call := range backend.received() {
+		if strings.Contains(call, "req-42") {
+			t.Errorf("metadata forwarded: %s", call)
+		}
+	}
+}
+
+func TestCallMetadataMerged(t *testing.T) {
+	ctx := WithCallMetadata(context.Background(), CallMetadata{"user": "alice", "route": "/block"})
+	ctx = WithCorrelationID(ctx, "req-7")
+	ctx = WithCallMetadata(ctx, CallMetadata{"route": "/tx"})
+
+	metadata := CallMetadataFrom(ctx)
+	if "correlation_id=req-7 route=/tx user=alice" != metadata.String() {
+		t.Errorf("metadata: %s", metadata)
+	}
+	if nil != CallMetadataFrom(context.Background()) {
+		t.Errorf("metadata without any attached: %v", CallMetadataFrom(context.Background()))
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// the first bitcoind version providing each method that is newer
+// than bitcoinMinimumVersion
+var methodVersions = struct {
+	sync.RWMutex
+	minimum map[string]uint64
+}{
+	minimum: map[string]uint64{
+		"getblockfilter": 190000,
+		"getindexinfo":   210000,
+		"scanblocks":     250000,
+		"submitpackage":  280000,
+	},
+}
+
+// set the version a method needs, zero to remove the requirement
+func SetMethodMinimumVersion(method string, version uint64) {
+	methodVersions.Lock()
+	defer methodVersions.Unlock()
+
+	if 0 == version {
+		delete(methodVersions.minimum, method)
+		return
+	}
+	methodVersions.minimum[method] = version
+}
+
+// a call was rejected locally because the daemon is too old for it
+type VersionError struct {
+	Method   string
+	Required uint64
+	Version  uint64
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("method %q needs bitcoind version %d, connected to %d", e.Method, e.Required, e.Version)
+}
+
+func (e *VersionError) Unwrap() error {
+	return ErrMethodNotSupportedByVersion
+}
+
+// check the daemon version from bootstrap is new enough for a method
+func (conn *RemoteConnection) requireVersion(method string) error {
+	methodVersions.RLock()
+	required := methodVersions.minimum[method]
+	methodVersions.RUnlock()
+
+	if conn.version >= required {
+		return nil
+	}
+	return &VersionError{
+		Method:   method,
+		Required: required,
+		Version:  conn.version,
+	}
+}
+
+// the version found by the bootstrap, zero before it
+func (conn *RemoteConnection) daemonVersion() uint64 {
+	conn.RLock()
+	defer conn.RUnlock()
+	return conn.version
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMethodTooNewForNode(t *testing.T) {
+	backend := newFakeBitcoind(t, 180000, nil)
+	backend.connect(t)
+
+	_, _, err := RemoteCall("getblockfilter", rawArguments(`"`+genesisHash+`"`))
+	if !errors.Is(err, ErrMethodNotSupportedByVersion) {
+		t.Fatalf("error: %v  expected: %v", err, ErrMethodNotSupportedByVersion)
+	}
+	var versionErr *VersionError
+	if !errors.As(err, &versionErr) || "getblockfilter" != versionErr.Method || 190000 != versionErr.Required || 180000 != versionErr.Version {
+		t.Errorf("error: %+v", versionErr)
+	}
+	if calls := backend.receivedFor("getblockfilter"); 0 != len(calls) {
+		t.Errorf("rejected call forwarded: %v", calls)
+	}
+
+	// older methods are unaffected
+	_, _, err = RemoteCall("getpeerinfo", nil)
+	if nil != err {
+		t.Errorf("getpeerinfo error: %v", err)
+	}
+}
+
+func TestMethodNewEnoughForNode(t *testing.T) {
+	backend := newFakeBitcoind(t, 190000, nil)
+	backend.connect(t)
+
+	_, _, err := RemoteCall("getblockfilter", rawArguments(`"`+genesisHash+`"`))
+	if nil != err {
+		t.Errorf("error: %v", err)
+	}
+	if calls := backend.receivedFor("getblockfilter"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestSetMethodMinimumVersion(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t)
+	SetMethodMinimumVersion("getpeerinfo", 210000)
+	t.Cleanup(func() {
+		SetMethodMinimumVersion("getpeerinfo", 0)
+	})
+
+	_, _, err := RemoteCall("getpeerinfo", nil)
+	var versionErr *VersionError
+	if !errors.As(err, &versionErr) || 210000 != versionErr.Required {
+		t.Errorf("error: %v", err)
+	}
+
+	SetMethodMinimumVersion("getpeerinfo", 0)
+	_, _, err = RemoteCall("getpeerinfo", nil)
+	if nil != err {
+		t.Errorf("error after removing the requirement: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// hooks for exporting metrics, any may be nil
+//
+// QueueWait and Backend split the latency of a call into the time it
+// waited to be taken from a queue and the time of the request to
+// bitcoind, for observing as separate histograms
+type Metrics struct {
+	InFlight  func(count int)                             // gauge of RemoteCalls in flight
+	Shed      func(method string, metadata CallMetadata)  // a call failed by latency shedding
+	QueueWait func(method string, duration time.Duration) // enqueue to dequeue of each attempt
+	Backend   func(method string, duration time.Duration) // each request to bitcoind
+}
+
+// the installed metrics hooks
+var metrics = struct {
+	sync.RWMutex
+	hooks Metrics
+}{}
+
+// install metrics hooks for all connections
+func SetMetrics(hooks Metrics) {
+	metrics.Lock()
+	metrics.hooks = hooks
+	metrics.Unlock()
+}
+
+// fetch the current hooks
+func currentMetrics() Metrics {
+	metrics.RLock()
+	defer metrics.RUnlock()
+	return metrics.hooks
+}
+
+func reportInFlight(count int64) {
+	if hook := currentMetrics().InFlight; nil != hook {
+		hook(int(count))
+	}
+}
+
+func reportShed(method string, metadata CallMetadata) {
+	if hook := currentMetrics().Shed; nil != hook {
+		hook(method, metadata)
+	}
+}
+
+func reportQueueWait(method string, duration time.Duration) {
+	if hook := currentMetrics().QueueWait; nil != hook {
+		hook(method, duration)
+	}
+}
+
+func reportBackend(method string, duration time.Duration) {
+	if hook := currentMetrics().Backend; nil != hook {
+		hook(method, duration)
+	}
+}
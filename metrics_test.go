@@ -0,0 +1,94 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// durations observed by a metrics hook
+type histogram struct {
+	sync.Mutex
+	observed []time.Duration
+}
+
+func (h *histogram) observe(method string, duration time.Duration) {
+	if "getblockhash" != method {
+		return
+	}
+	h.Lock()
+	h.observed = append(h.observed, duration)
+	h.Unlock()
+}
+
+// the number of observations and the largest
+func (h *histogram) summary() (int, time.Duration) {
+	h.Lock()
+	defer h.Unlock()
+	largest := time.Duration(0)
+	for _, duration := range h.observed {
+		if duration > largest {
+			largest = duration
+		}
+	}
+	return len(h.observed), largest
+}
+
+func TestQueueWaitAndBackendMetrics(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockhash" == method {
+			time.Sleep(delay)
+			return genesisHash, nil
+		}
+		return nil, nil
+	})
+	conn := backend.connect(t)
+
+	queueWait := &histogram{}
+	backendTime := &histogram{}
+	SetMetrics(Metrics{
+		QueueWait: queueWait.observe,
+		Backend:   backendTime.observe,
+	})
+	t.Cleanup(func() {
+		SetMetrics(Metrics{})
+	})
+
+	// the single connection serves one at a time so the calls queue
+	const calls = 5
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i += 1 {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			RemoteCall("getblockhash", rawArguments(fmt.Sprint(i)))
+		}(i)
+	}
+	wg.Wait()
+
+	count, largest := backendTime.summary()
+	if calls != count || largest < delay {
+		t.Errorf("backend: %d observations  largest: %v", count, largest)
+	}
+	count, largest = queueWait.summary()
+	if calls != count || largest < 2*delay {
+		t.Errorf("queue wait: %d observations  largest: %v", count, largest)
+	}
+
+	// a direct call is not queued
+	conn.Do(context.Background(), "getblockhash", rawArguments("9"))
+	if count, _ := queueWait.summary(); calls != count {
+		t.Errorf("queue wait: %d observations", count)
+	}
+	if count, _ := backendTime.summary(); calls+1 != count {
+		t.Errorf("backend: %d observations", count)
+	}
+}
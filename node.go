@@ -0,0 +1,179 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// errors
+var (
+	ErrNodeControlDisabled = errors.New("node control is not enabled")
+	ErrInvalidNodeAddress  = errors.New("invalid node address")
+	ErrInvalidNodeCommand  = errors.New("invalid node command: add, remove or onetry expected")
+)
+
+// getaddednodeinfo takes a leading dns bool before this version
+const bitcoinAddedNodeDNSVersion = 140000
+
+// longest node address accepted, a hostname with a port
+const maxNodeAddressLength = 255 + len(":65535")
+
+// methods that only a connection with node control serves
+var nodeMethods = map[string]bool{
+	"addnode":          true,
+	"getaddednodeinfo": true,
+}
+
+// connections with node control, in the order they were made
+var nodeControllers = struct {
+	sync.RWMutex
+	connections []*RemoteConnection
+}{}
+
+// allow the peer management methods addnode and getaddednodeinfo,
+// refused by default as they change or reveal which peers the daemon
+// connects to
+//
+// RemoteCall sends them to the connection they are routed to with
+// SetMethodRoute, otherwise to the first connection made with node
+// control, never RoleRead for addnode
+func WithNodeControl() Option {
+	return func(conn *RemoteConnection) {
+		conn.nodeControl = true
+	}
+}
+
+// start serving node methods
+func (conn *RemoteConnection) joinNodeControl() {
+	if !conn.nodeControl {
+		return
+	}
+	nodeControllers.Lock()
+	nodeControllers.connections = append(nodeControllers.connections, conn)
+	nodeControllers.Unlock()
+}
+
+// stop serving node methods
+func (conn *RemoteConnection) leaveNodeControl() {
+	if !conn.nodeControl {
+		return
+	}
+	nodeControllers.Lock()
+	defer nodeControllers.Unlock()
+	for i, c := range nodeControllers.connections {
+		if c == conn {
+			nodeControllers.connections = append(nodeControllers.connections[:i], nodeControllers.connections[i+1:]...)
+			return
+		}
+	}
+}
+
+// the connection to send a node method to
+func nodeConnection(method string) (*RemoteConnection, bool) {
+	if conn, ok := routedConnection(method); ok {
+		return conn, true
+	}
+	nodeControllers.RLock()
+	defer nodeControllers.RUnlock()
+	for _, conn := range nodeControllers.connections {
+		if writeMethods[method] && RoleRead == conn.role {
+			continue
+		}
+		return conn, true
+	}
+	return nil, false
+}
+
+// fail unless node control is enabled
+func (conn *RemoteConnection) requireNodeControl() error {
+	if !conn.nodeControl {
+		return ErrNodeControlDisabled
+	}
+	return nil
+}
+
+// the arguments for getaddednodeinfo, with the dns flag older daemons need
+func (conn *RemoteConnection) addedNodeArguments(node string) []interface{} {
+	params := []interface{}{}
+	if conn.version < bitcoinAddedNodeDNSVersion {
+		params = append(params, true)
+	}
+	if "" != node {
+		params = append(params, node)
+	}
+	return params
+}
+
+// get a node address given as host or host:port
+func getNodeAddress(argument json.RawMessage) (string, error) {
+	var node string
+	err := json.Unmarshal(argument, &node)
+	if nil != err {
+		return "", ErrInvalidArgumentType
+	}
+	if !validNodeAddress(node) {
+		return "", ErrInvalidNodeAddress
+	}
+	return node, nil
+}
+
+// get an addnode command
+func getNodeCommand(argument json.RawMessage) (string, error) {
+	var command string
+	err := json.Unmarshal(argument, &command)
+	if nil != err {
+		return "", ErrInvalidArgumentType
+	}
+	switch command {
+	case "add", "remove", "onetry":
+		return command, nil
+	default:
+		return "", ErrInvalidNodeCommand
+	}
+}
+
+// check for an IP address or hostname, including onion and I2P
+// names, with an optional port; IPv6 with a port must be bracketed
+func validNodeAddress(node string) bool {
+
+	if 0 == len(node) || len(node) > maxNodeAddressLength {
+		return false
+	}
+
+	host := node
+	if h, port, err := net.SplitHostPort(node); nil == err {
+		n, err := strconv.ParseUint(port, 10, 16)
+		if nil != err || 0 == n {
+			return false
+		}
+		host = h
+	} else if strings.HasPrefix(node, "[") && strings.HasSuffix(node, "]") {
+		host = node[1 : len(node)-1]
+	}
+
+	if nil != net.ParseIP(host) {
+		return true
+	}
+	if 0 == len(host) || len(host) > 255 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if 0 == len(label) || len(label) > 63 {
+			return false
+		}
+		for _, c := range label {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || '-' == c) {
+				return false
+			}
+		}
+	}
+	return true
+}
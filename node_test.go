@@ -0,0 +1,122 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestNodeControlDisabled(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	conn := backend.connect(t)
+
+	_, _, err := RemoteCall("addnode", rawArguments(`"192.0.2.1:8333"`, `"add"`))
+	if !errors.Is(err, ErrNodeControlDisabled) {
+		t.Errorf("addnode error: %v", err)
+	}
+	var reply, rpcErr json.RawMessage
+	err = conn.processCall(context.Background(), "getaddednodeinfo", nil, &reply, &rpcErr)
+	if !errors.Is(err, ErrNodeControlDisabled) {
+		t.Errorf("getaddednodeinfo error: %v", err)
+	}
+	if calls := backend.received(); 0 != len(calls) {
+		t.Errorf("forwarded: %v", calls)
+	}
+}
+
+func TestNodeControlUsesOwningConnection(t *testing.T) {
+	controlled := newFakeBitcoind(t, 200000, nil)
+	other := newFakeBitcoind(t, 200000, nil)
+	other.connect(t)
+	controlled.connect(t, WithNodeControl())
+	other.connect(t)
+
+	for i := 0; i < 10; i += 1 {
+		_, _, err := RemoteCall("addnode", rawArguments(`"192.0.2.1:8333"`, `"onetry"`))
+		if nil != err {
+			t.Fatalf("addnode error: %v", err)
+		}
+		_, _, err = RemoteCall("getaddednodeinfo", rawArguments(`"192.0.2.1:8333"`))
+		if nil != err {
+			t.Fatalf("getaddednodeinfo error: %v", err)
+		}
+	}
+	if calls := controlled.receivedFor("addnode"); 10 != len(calls) || `addnode["192.0.2.1:8333","onetry"]` != calls[0] {
+		t.Errorf("controlled addnode calls: %v", calls)
+	}
+	if calls := controlled.receivedFor("getaddednodeinfo"); 10 != len(calls) || `getaddednodeinfo["192.0.2.1:8333"]` != calls[0] {
+		t.Errorf("controlled getaddednodeinfo calls: %v", calls)
+	}
+	if calls := other.received(); 0 != len(calls) {
+		t.Errorf("other connection calls: %v", calls)
+	}
+}
+
+func TestNodeControlNotOnReadReplica(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t, WithNodeControl(), WithRole(RoleRead))
+
+	_, _, err := RemoteCall("addnode", rawArguments(`"192.0.2.1:8333"`, `"add"`))
+	if !errors.Is(err, ErrNodeControlDisabled) {
+		t.Errorf("addnode error: %v", err)
+	}
+	_, _, err = RemoteCall("getaddednodeinfo", nil)
+	if nil != err {
+		t.Errorf("getaddednodeinfo error: %v", err)
+	}
+	if calls := backend.receivedFor("addnode"); 0 != len(calls) {
+		t.Errorf("addnode on a read replica: %v", calls)
+	}
+}
+
+func TestNodeControlArguments(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	conn := backend.connect(t, WithNodeControl())
+	ctx := context.Background()
+
+	valid := []string{`"192.0.2.1"`, `"192.0.2.1:8333"`, `"[2001:db8::1]:8333"`, `"2001:db8::1"`, `"[2001:db8::1]"`, `"node.example.com"`, `"abcdefghijklmnop.onion:8333"`}
+	for _, node := range valid {
+		var reply, rpcErr json.RawMessage
+		err := conn.processCall(ctx, "addnode", rawArguments(node, `"remove"`), &reply, &rpcErr)
+		if nil != err {
+			t.Errorf("node: %s  error: %v", node, err)
+		}
+	}
+
+	invalid := []string{`""`, `"a b"`, `"host:0"`, `"host:65536"`, `"a..b"`, `"host;x"`, `5`}
+	for _, node := range invalid {
+		var reply, rpcErr json.RawMessage
+		err := conn.processCall(ctx, "addnode", rawArguments(node, `"add"`), &reply, &rpcErr)
+		if nil == err {
+			t.Errorf("node: %s accepted", node)
+		}
+	}
+
+	var reply, rpcErr json.RawMessage
+	err := conn.processCall(ctx, "addnode", rawArguments(`"192.0.2.1"`, `"connect"`), &reply, &rpcErr)
+	if !errors.Is(err, ErrInvalidNodeCommand) {
+		t.Errorf("command error: %v", err)
+	}
+	if calls := backend.receivedFor("addnode"); len(valid) != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestAddedNodeInfoDNSFlag(t *testing.T) {
+	backend := newFakeBitcoind(t, 130000, nil)
+	conn := backend.connect(t, WithNodeControl())
+
+	var reply, rpcErr json.RawMessage
+	err := conn.processCall(context.Background(), "getaddednodeinfo", rawArguments(`"192.0.2.1"`), &reply, &rpcErr)
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if calls := backend.received(); 1 != len(calls) || `getaddednodeinfo[true,"192.0.2.1"]` != calls[0] {
+		t.Errorf("calls: %v", calls)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// send methods as notifications: the call returns as soon as the
+// HTTP status is known with a null result and the body is not decoded
+//
+// the request still carries an id and bitcoind still sends a reply,
+// which is discarded, so any RPC error is only seen as an HTTP error
+// status
+func WithNotificationMethods(methods ...string) Option {
+	return func(conn *RemoteConnection) {
+		if nil == conn.notifications {
+			conn.notifications = make(map[string]bool)
+		}
+		for _, method := range methods {
+			conn.notifications[method] = true
+		}
+	}
+}
+
+// send a request and check only the status of the response
+func (conn *RemoteConnection) notifyRPC(ctx context.Context, arguments *bitcoinArguments) error {
+
+	response, err := conn.post(ctx, arguments)
+	if nil != err {
+		return err
+	}
+
+	// drain in the background so the connection can be reused
+	go func() {
+		io.Copy(io.Discard, response.Body)
+		response.Body.Close()
+	}()
+
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusUnauthorized:
+		return ErrAccessDenied
+	default:
+		return &HTTPError{
+			StatusCode: response.StatusCode,
+			Status:     response.Status,
+		}
+	}
+}
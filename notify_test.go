@@ -0,0 +1,101 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a backend sending the status of sendrawtransaction at once but
+// holding back the body until release is closed
+type heldBodyBackend struct {
+	*fakeBitcoind
+	status  int
+	release chan struct{}
+}
+
+func newHeldBodyBackend(t *testing.T, status int) *heldBodyBackend {
+	h := &heldBodyBackend{
+		fakeBitcoind: newFakeBitcoind(t, 200000, nil),
+		status:       status,
+		release:      make(chan struct{}),
+	}
+	// sendrawtransaction is answered here, other calls by the fake
+	h.Server = httptest.NewServer(http.HandlerFunc(h.serve))
+	t.Cleanup(h.Server.Close)
+	t.Cleanup(func() {
+		close(h.release)
+	})
+	return h
+}
+
+func (h *heldBodyBackend) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	if !bytes.Contains(body, []byte(`"sendrawtransaction"`)) {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		h.fakeBitcoind.serve(w, r)
+		return
+	}
+
+	var request fakeRequest
+	json.Unmarshal(body, &request)
+	reply, _ := h.reply(request)
+	w.WriteHeader(h.status)
+	w.(http.Flusher).Flush()
+	<-h.release
+	w.Write(reply)
+}
+
+func TestNotificationReturnsOnStatus(t *testing.T) {
+	backend := newHeldBodyBackend(t, http.StatusOK)
+	backend.connect(t, WithNotificationMethods("sendrawtransaction"))
+
+	start := time.Now()
+	result, rpcErr, err := RemoteCall("sendrawtransaction", rawArguments(`"`+testTransaction+`"`))
+	if nil != err || "null" != string(result) || "null" != string(rpcErr) {
+		t.Errorf("result: %s  rpc error: %s  error: %v", result, rpcErr, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waited %v for the body", elapsed)
+	}
+	if calls := backend.receivedFor("sendrawtransaction"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestNotificationErrorStatus(t *testing.T) {
+	backend := newHeldBodyBackend(t, http.StatusInternalServerError)
+	backend.connect(t, WithNotificationMethods("sendrawtransaction"))
+	setTestTries(t, 1)
+
+	_, _, err := RemoteCall("sendrawtransaction", rawArguments(`"`+testTransaction+`"`))
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || http.StatusInternalServerError != httpErr.StatusCode {
+		t.Errorf("error: %v", err)
+	}
+}
+
+func TestNotificationOnlySelected(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "sendrawtransaction" == method {
+			return testTxID, nil
+		}
+		return nil, nil
+	})
+	backend.connect(t, WithNotificationMethods("getpeerinfo"))
+
+	result, _, err := RemoteCall("sendrawtransaction", rawArguments(`"`+testTransaction+`"`))
+	if nil != err || !strings.Contains(string(result), testTxID) {
+		t.Errorf("result: %s  error: %v", result, err)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// errors
+var (
+	ErrInvalidRequest = errors.New("invalid JSON-RPC request")
+)
+
+// parameter names of the supported methods, in position order,
+// for requests that give their parameters as an object
+var parameterNames = map[string][]string{
+	"getinfo":              {},
+	"getblockchaininfo":    {},
+	"getblockcount":        {},
+	"getbestblockhash":     {},
+	"getpeerinfo":          {},
+	"getnettotals":         {},
+	"getaddednodeinfo":     {"node"},
+	"addnode":              {"node", "command"},
+	"getblockhash":         {"height"},
+	"getblock":             {"blockhash", "verbosity"},
+	"getblockheader":       {"blockhash", "verbose"},
+	"getrawtransaction":    {"txid", "verbose", "blockhash"},
+	"gettxout":             {"txid", "n", "include_mempool"},
+	"getrawmempool":        {"verbose"},
+	"getmempoolentry":      {"txid"},
+	"decoderawtransaction": {"hexstring"},
+	"sendrawtransaction":   {"hexstring"},
+	"getblockfilter":       {"blockhash", "filtertype"},
+	"scanblocks":           {"action", "scanobjects", "start_height", "stop_height", "filtertype", "options"},
+}
+
+// split a JSON-RPC request body into its method, positional arguments
+// and id, ready for RemoteCall
+//
+// params may be an array or, for the supported methods, an object of
+// named parameters; parameters left out before a given one become
+// null, which is treated as omitted; the id is null if absent
+func ParseCall(body []byte) (string, []json.RawMessage, json.RawMessage, error) {
+
+	var request struct {
+		ID     json.RawMessage `json:"id"`
+		Method *string         `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	err := json.Unmarshal(body, &request)
+	if nil != err {
+		return "", nil, nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	if nil == request.Method || "" == *request.Method {
+		return "", nil, nil, fmt.Errorf("%w: missing method", ErrInvalidRequest)
+	}
+	method := *request.Method
+
+	id := request.ID
+	if 0 == len(id) {
+		id = jsonNull
+	}
+
+	params := bytes.TrimSpace(request.Params)
+	if 0 == len(params) || isNull(params) {
+		return method, []json.RawMessage{}, id, nil
+	}
+
+	switch params[0] {
+	case '[':
+		var arguments []json.RawMessage
+		err = json.Unmarshal(params, &arguments)
+		if nil != err {
+			return "", nil, nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+		}
+		return method, arguments, id, nil
+
+	case '{':
+		arguments, err := namedArguments(method, params)
+		if nil != err {
+			return "", nil, nil, err
+		}
+		return method, arguments, id, nil
+
+	default:
+		return "", nil, nil, fmt.Errorf("%w: params must be an array or an object", ErrInvalidRequest)
+	}
+}
+
+// convert named parameters to positional arguments
+func namedArguments(method string, params json.RawMessage) ([]json.RawMessage, error) {
+
+	names, ok := parameterNames[method]
+	if !ok {
+		return nil, fmt.Errorf("%w: named parameters for %q", ErrInvalidMethod, method)
+	}
+
+	var named map[string]json.RawMessage
+	err := json.Unmarshal(params, &named)
+	if nil != err {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	positions := make(map[string]int, len(names))
+	for i, name := range names {
+		positions[name] = i
+	}
+	count := 0
+	for name := range named {
+		i, ok := positions[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown parameter %q for %s", ErrInvalidRequest, name, method)
+		}
+		if i+1 > count {
+			count = i + 1
+		}
+	}
+
+	arguments := make([]json.RawMessage, count)
+	for i := range arguments {
+		argument, ok := named[names[i]]
+		if !ok {
+			argument = jsonNull
+		}
+		arguments[i] = argument
+	}
+	return arguments, nil
+}
+
+// a JSON-RPC 2.0 reply, exactly one of Result and Error is set
+type replyEnvelope struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   json.RawMessage `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// wrap the result and RPC error of a RemoteCall into a reply for the
+// request with the id, e.g. from ParseCall
+//
+// the error is used if it is not null and must then be an object with
+// a code and a message, otherwise the result is used, null if empty
+func EncodeReply(id json.RawMessage, result json.RawMessage, rpcErr json.RawMessage) ([]byte, error) {
+
+	if 0 == len(id) {
+		id = jsonNull
+	}
+	if !json.Valid(id) {
+		return nil, fmt.Errorf("%w: id is not valid JSON", ErrInvalidRequest)
+	}
+
+	reply := replyEnvelope{
+		JSONRPC: "2.0",
+		ID:      id,
+	}
+	if 0 != len(rpcErr) && !isNull(rpcErr) {
+		var e RPCError
+		var fields map[string]json.RawMessage
+		if nil != json.Unmarshal(rpcErr, &e) || nil != json.Unmarshal(rpcErr, &fields) || nil == fields["code"] || nil == fields["message"] {
+			return nil, fmt.Errorf("%w: error is not a JSON-RPC error object", ErrIncomprehesibleResponse)
+		}
+		reply.Error = rpcErr
+	} else if 0 == len(result) {
+		reply.Result = jsonNull
+	} else {
+		reply.Result = result
+	}
+	return json.Marshal(reply)
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// arguments as a single string
+func joinArguments(arguments []json.RawMessage) string {
+	buffer, _ := json.Marshal(arguments)
+	return string(buffer)
+}
+
+func TestParseCall(t *testing.T) {
+	for _, item := range []struct {
+		body      string
+		method    string
+		arguments string
+		id        string
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"getblockhash","params":[7]}`, "getblockhash", `[7]`, `1`},
+		{`{"id":"a-1","method":"getblockcount"}`, "getblockcount", `[]`, `"a-1"`},
+		{`{"method":"getblockcount","params":null}`, "getblockcount", `[]`, `null`},
+		{`{"id":null,"method":"getbestblockhash","params":[]}`, "getbestblockhash", `[]`, `null`},
+		{`{"id":2,"method":"getblock","params":{"blockhash":"00aa","verbosity":0}}`, "getblock", `["00aa",0]`, `2`},
+		{`{"id":3,"method":"getrawtransaction","params":{"txid":"00bb","blockhash":"00cc"}}`, "getrawtransaction", `["00bb",null,"00cc"]`, `3`},
+		{`{"id":4,"method":"getpeerinfo","params":{}}`, "getpeerinfo", `[]`, `4`},
+		{`{"id":5,"method":"getwalletinfo","params":[]}`, "getwalletinfo", `[]`, `5`}, // left to RemoteCall to reject
+		{` {"id":6, "method":"getblockhash", "params": [ 8 ] } `, "getblockhash", `[8]`, `6`},
+	} {
+		method, arguments, id, err := ParseCall([]byte(item.body))
+		if nil != err {
+			t.Errorf("%s error: %v", item.body, err)
+			continue
+		}
+		if item.method != method || item.arguments != joinArguments(arguments) || item.id != string(id) {
+			t.Errorf("%s: method: %s  arguments: %s  id: %s", item.body, method, joinArguments(arguments), id)
+		}
+	}
+}
+
+func TestParseCallMalformed(t *testing.T) {
+	for _, item := range []struct {
+		body     string
+		expected error
+	}{
+		{``, ErrInvalidRequest},
+		{`not json`, ErrInvalidRequest},
+		{`[{"method":"getblockcount"}]`, ErrInvalidRequest}, // batches are not calls
+		{`{"id":1}`, ErrInvalidRequest},
+		{`{"id":1,"method":""}`, ErrInvalidRequest},
+		{`{"id":1,"method":7}`, ErrInvalidRequest},
+		{`{"id":1,"method":"getblockhash","params":"7"}`, ErrInvalidRequest},
+		{`{"id":1,"method":"getblockhash","params":[7,}`, ErrInvalidRequest},
+		{`{"id":1,"method":"getblockhash","params":{"depth":7}}`, ErrInvalidRequest},
+		{`{"id":1,"method":"getwalletinfo","params":{"verbose":true}}`, ErrInvalidMethod},
+	} {
+		_, _, _, err := ParseCall([]byte(item.body))
+		if !errors.Is(err, item.expected) {
+			t.Errorf("%q error: %v  expected: %v", item.body, err, item.expected)
+		}
+	}
+}
+
+func TestEncodeReply(t *testing.T) {
+	for _, item := range []struct {
+		id       string
+		result   string
+		rpcErr   string
+		expected string
+	}{
+		{`1`, `"00aa"`, `null`, `{"jsonrpc":"2.0","result":"00aa","id":1}`},
+		{`"a-1"`, `{"blocks": 7}`, ``, `{"jsonrpc":"2.0","result":{"blocks":7},"id":"a-1"}`},
+		{`2`, `null`, `{"code":-8,"message":"Block height out of range"}`, `{"jsonrpc":"2.0","error":{"code":-8,"message":"Block height out of range"},"id":2}`},
+		{`3`, `"ignored"`, `{"code":-5,"message":"not found"}`, `{"jsonrpc":"2.0","error":{"code":-5,"message":"not found"},"id":3}`},
+		{`null`, `null`, `null`, `{"jsonrpc":"2.0","result":null,"id":null}`},
+		{``, ``, ``, `{"jsonrpc":"2.0","result":null,"id":null}`},
+	} {
+		reply, err := EncodeReply(json.RawMessage(item.id), json.RawMessage(item.result), json.RawMessage(item.rpcErr))
+		if nil != err || item.expected != string(reply) {
+			t.Errorf("reply: %s  error: %v\nexpected: %s", reply, err, item.expected)
+		}
+	}
+}
+
+func TestEncodeReplyInvalid(t *testing.T) {
+	if _, err := EncodeReply(json.RawMessage(`{`), nil, nil); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("id error: %v  expected: %v", err, ErrInvalidRequest)
+	}
+	for _, rpcErr := range []string{`"failed"`, `{"code":-1}`, `{"message":"failed"}`, `{"code":"x","message":"failed"}`} {
+		if _, err := EncodeReply(json.RawMessage(`1`), nil, json.RawMessage(rpcErr)); !errors.Is(err, ErrIncomprehesibleResponse) {
+			t.Errorf("%s error: %v  expected: %v", rpcErr, err, ErrIncomprehesibleResponse)
+		}
+	}
+}
+
+func TestParseEncodeRoundTrip(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t)
+
+	method, arguments, id, err := ParseCall([]byte(`{"jsonrpc":"2.0","id":"r1","method":"getblockhash","params":{"height":9}}`))
+	if nil != err {
+		t.Fatalf("parse error: %v", err)
+	}
+	result, rpcErr, err := RemoteCall(method, arguments)
+	if nil != err {
+		t.Fatalf("call error: %v", err)
+	}
+	reply, err := EncodeReply(id, result, rpcErr)
+	if nil != err || `{"jsonrpc":"2.0","result":null,"id":"r1"}` != string(reply) {
+		t.Errorf("reply: %s  error: %v", reply, err)
+	}
+	if calls := backend.receivedFor("getblockhash"); 1 != len(calls) || "getblockhash[9]" != calls[0] {
+		t.Errorf("calls: %v", calls)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+)
+
+// a single entry from getpeerinfo
+//
+// pointer fields are not present in all bitcoind versions
+// (or not until the first ping completes) and are nil if absent
+type Peer struct {
+	ID             uint64   `json:"id"`
+	Addr           string   `json:"addr"`
+	Services       string   `json:"services"`
+	RelayTxes      *bool    `json:"relaytxes"`
+	LastSend       int64    `json:"lastsend"`
+	LastRecv       int64    `json:"lastrecv"`
+	BytesSent      uint64   `json:"bytessent"`
+	BytesRecv      uint64   `json:"bytesrecv"`
+	ConnTime       int64    `json:"conntime"`
+	PingTime       *float64 `json:"pingtime"`
+	Version        int64    `json:"version"`
+	SubVer         string   `json:"subver"`
+	Inbound        bool     `json:"inbound"`
+	StartingHeight *int64   `json:"startingheight"`
+	SyncedHeaders  *int64   `json:"synced_headers"`
+	SyncedBlocks   *int64   `json:"synced_blocks"`
+}
+
+// fetch the peer list from one of the remote servers
+func GetPeerInfo() ([]Peer, error) {
+
+	result, rpcErr, err := RemoteCall("getpeerinfo", []json.RawMessage{})
+	if nil != err {
+		return nil, err
+	}
+
+	var peers []Peer
+	err = decodeResult(result, rpcErr, &peers)
+	if nil != err {
+		return nil, err
+	}
+	return peers, nil
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const testPeers = `[
+  {"id": 3, "addr": "203.0.113.7:8333", "services": "0000000000000409", "relaytxes": true,
+   "lastsend": 1600000010, "lastrecv": 1600000011, "bytessent": 1234, "bytesrecv": 5678,
+   "conntime": 1600000000, "pingtime": 0.05, "version": 70015, "subver": "/Satoshi:0.20.0/",
+   "inbound": false, "startingheight": 650000, "synced_headers": 650010, "synced_blocks": 650009},
+  {"id": 4, "addr": "198.51.100.2:50000", "services": "0000000000000000",
+   "lastsend": 1600000020, "lastrecv": 1600000021, "bytessent": 10, "bytesrecv": 20,
+   "conntime": 1600000001, "version": 70001, "subver": "/old:0.1/", "inbound": true}
+]`
+
+func TestGetPeerInfo(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getpeerinfo" == method {
+			return json.RawMessage(testPeers), nil
+		}
+		return nil, nil
+	})
+	backend.connect(t)
+
+	peers, err := GetPeerInfo()
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if 2 != len(peers) {
+		t.Fatalf("%d peers", len(peers))
+	}
+
+	p := peers[0]
+	if 3 != p.ID || "203.0.113.7:8333" != p.Addr || "0000000000000409" != p.Services ||
+		1600000010 != p.LastSend || 1600000011 != p.LastRecv || 1234 != p.BytesSent || 5678 != p.BytesRecv ||
+		1600000000 != p.ConnTime || 70015 != p.Version || "/Satoshi:0.20.0/" != p.SubVer || p.Inbound {
+		t.Errorf("peer: %+v", p)
+	}
+	if nil == p.RelayTxes || !*p.RelayTxes || nil == p.PingTime || 0.05 != *p.PingTime {
+		t.Errorf("relaytxes: %v  pingtime: %v", p.RelayTxes, p.PingTime)
+	}
+	if nil == p.StartingHeight || 650000 != *p.StartingHeight ||
+		nil == p.SyncedHeaders || 650010 != *p.SyncedHeaders ||
+		nil == p.SyncedBlocks || 650009 != *p.SyncedBlocks {
+		t.Errorf("heights: %v %v %v", p.StartingHeight, p.SyncedHeaders, p.SyncedBlocks)
+	}
+
+	// fields an older daemon leaves out stay nil
+	p = peers[1]
+	if !p.Inbound || nil != p.RelayTxes || nil != p.PingTime || nil != p.StartingHeight || nil != p.SyncedHeaders || nil != p.SyncedBlocks {
+		t.Errorf("peer: %+v", p)
+	}
+}
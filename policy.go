@@ -0,0 +1,72 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+)
+
+// errors
+var (
+	ErrMethodDenied = errors.New("method denied by policy")
+)
+
+// one entry of a method policy
+//
+// Pattern is a glob matched against the whole method name,
+// e.g. "get*" or "getblock?"
+type Rule struct {
+	Allow   bool
+	Pattern string
+}
+
+// allow a rule's methods
+func AllowMethods(pattern string) Rule {
+	return Rule{
+		Allow:   true,
+		Pattern: pattern,
+	}
+}
+
+// deny a rule's methods
+func DenyMethods(pattern string) Rule {
+	return Rule{
+		Allow:   false,
+		Pattern: pattern,
+	}
+}
+
+// restrict the methods this connection will forward
+//
+// the first matching rule decides and a method no rule matches is
+// denied; an allowed method must still be one of the supported methods
+// and pass their argument checks
+func WithMethodPolicy(rules []Rule) Option {
+	return func(conn *RemoteConnection) {
+		conn.methodPolicy = append([]Rule{}, rules...)
+	}
+}
+
+// check a method against the policy, if one was given
+func (conn *RemoteConnection) permitMethod(method string) error {
+	if nil == conn.methodPolicy {
+		return nil
+	}
+	for _, rule := range conn.methodPolicy {
+		matched, err := path.Match(rule.Pattern, method)
+		if nil != err {
+			return fmt.Errorf("%w: invalid pattern %q: %v", ErrMethodDenied, rule.Pattern, err)
+		}
+		if matched {
+			if rule.Allow {
+				return nil
+			}
+			break
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrMethodDenied, method)
+}
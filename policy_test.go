@@ -0,0 +1,66 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMethodPolicy(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	conn := backend.connect(t, WithMethodPolicy([]Rule{
+		DenyMethods("sendrawtransaction"),
+		AllowMethods("get*"),
+		DenyMethods("getpeerinfo"), // too late, get* matched first
+		AllowMethods("decoderawtransaction"),
+	}))
+
+	for _, item := range []struct {
+		method    string
+		arguments []string
+		expected  error
+	}{
+		{"getblockcount", nil, nil}, // wildcard allow
+		{"getpeerinfo", nil, nil},   // first match wins
+		{"decoderawtransaction", []string{`"` + testTransaction + `"`}, nil}, // explicit allow
+		{"sendrawtransaction", []string{`"` + testTransaction + `"`}, ErrMethodDenied},
+		{"addnode", []string{`"10.0.0.1"`, `"add"`}, ErrMethodDenied}, // default deny
+		{"getwalletinfo", nil, ErrInvalidMethod},                      // allowed but not supported
+		{"getblockhash", []string{`"x"`}, ErrInvalidArgumentType},     // allowed but still validated
+	} {
+		_, _, err := conn.Do(context.Background(), item.method, rawArguments(item.arguments...))
+		if !errors.Is(err, item.expected) {
+			t.Errorf("%s error: %v  expected: %v", item.method, err, item.expected)
+		}
+	}
+
+	// denied methods never reach the backend
+	for _, method := range []string{"sendrawtransaction", "addnode"} {
+		if calls := backend.receivedFor(method); 0 != len(calls) {
+			t.Errorf("%s forwarded: %v", method, calls)
+		}
+	}
+}
+
+func TestMethodPolicyInvalidPattern(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	conn := backend.connect(t, WithMethodPolicy([]Rule{AllowMethods("get[")}))
+
+	if _, _, err := conn.Do(context.Background(), "getblockcount", nil); !errors.Is(err, ErrMethodDenied) {
+		t.Errorf("error: %v  expected: %v", err, ErrMethodDenied)
+	}
+}
+
+func TestMethodPolicyEmpty(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	conn := backend.connect(t, WithMethodPolicy([]Rule{}))
+
+	// a policy without rules denies everything
+	if _, _, err := conn.Do(context.Background(), "getblockcount", nil); !errors.Is(err, ErrMethodDenied) {
+		t.Errorf("error: %v  expected: %v", err, ErrMethodDenied)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// scheduling priority of a call in the shared queues
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0 // default
+	PriorityHigh   Priority = 1
+)
+
+// each priority step is worth this much waiting time, so a waiting
+// low priority call eventually goes ahead of newer high priority ones
+const priorityAging = 2 * time.Second
+
+type priorityKey struct{}
+
+// attach a priority to calls made with the context
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// the priority attached to a context, normal if none
+func priorityFrom(ctx context.Context) Priority {
+	if priority, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return priority
+	}
+	return PriorityNormal
+}
+
+// calls from in are handed to workers on out in order of
+// enqueue time offset by priority
+type priorityQueue struct {
+	in  chan Call
+	out chan Call
+
+	sync.Mutex
+	workers int
+	idle    bool
+	stopped chan bool // closed when the last worker leaves
+}
+
+// the queue starts idle, as if its last worker had left
+func newPriorityQueue() *priorityQueue {
+	q := &priorityQueue{
+		in:      make(chan Call),
+		out:     make(chan Call),
+		idle:    true,
+		stopped: make(chan bool),
+	}
+	close(q.stopped)
+	go q.run()
+	return q
+}
+
+// a worker starts serving the queue
+func (q *priorityQueue) join() {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.idle {
+		q.stopped = make(chan bool)
+		q.idle = false
+	}
+	q.workers += 1
+}
+
+// a worker stops serving the queue
+func (q *priorityQueue) leave() {
+	q.Lock()
+	defer q.Unlock()
+
+	q.workers -= 1
+	if 0 == q.workers {
+		close(q.stopped)
+		q.idle = true
+	}
+}
+
+// a worker stops serving the queue unless it is the only one left,
+// false if it has to keep serving so that waiting calls are answered
+func (q *priorityQueue) leaveUnlessLast() bool {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.workers <= 1 {
+		return false
+	}
+	q.workers -= 1
+	return true
+}
+
+// check if any worker serves the queue
+func (q *priorityQueue) serving() bool {
+	q.Lock()
+	defer q.Unlock()
+	return q.workers > 0
+}
+
+// closed once no worker is left to serve the queue
+func (q *priorityQueue) done() <-chan bool {
+	q.Lock()
+	defer q.Unlock()
+	return q.stopped
+}
+
+// dispatch loop
+func (q *priorityQueue) run() {
+
+	pending := &callHeap{}
+	for {
+		var out chan Call
+		var next Call
+		if pending.Len() > 0 {
+			next = (*pending)[0]
+			out = q.out
+		}
+
+		select {
+		case call := <-q.in:
+			heap.Push(pending, call)
+		case out <- next:
+			heap.Pop(pending)
+		}
+
+		// drop calls whose callers have given up
+		for pending.Len() > 0 && abandonedCall((*pending)[0]) {
+			heap.Pop(pending)
+		}
+	}
+}
+
+// check if the caller of a pending call has gone
+func abandonedCall(call Call) bool {
+	return nil != call.Context && nil != call.Context.Err()
+}
+
+// the effective time of a call for ordering
+func dueTime(call Call) time.Time {
+	return call.Enqueued.Add(-time.Duration(call.Priority) * priorityAging)
+}
+
+// min-heap of calls by due time
+type callHeap []Call
+
+func (h callHeap) Len() int           { return len(h) }
+func (h callHeap) Less(i, j int) bool { return dueTime(h[i]).Before(dueTime(h[j])) }
+func (h callHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *callHeap) Push(x interface{}) {
+	*h = append(*h, x.(Call))
+}
+
+func (h *callHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	call := old[n-1]
+	*h = old[:n-1]
+	return call
+}
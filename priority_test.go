@@ -0,0 +1,55 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHighPriorityServedFirst(t *testing.T) {
+	q := newPriorityQueue()
+
+	now := time.Now()
+	for i := 0; i < 10; i += 1 {
+		q.in <- Call{Method: "getblock", Priority: PriorityLow, Enqueued: now}
+	}
+	q.in <- Call{Method: "sendrawtransaction", Priority: PriorityHigh, Enqueued: now.Add(time.Millisecond)}
+
+	if call := <-q.out; "sendrawtransaction" != call.Method {
+		t.Fatalf("first call: %s", call.Method)
+	}
+	for i := 0; i < 10; i += 1 {
+		if call := <-q.out; "getblock" != call.Method {
+			t.Errorf("call %d: %s", i, call.Method)
+		}
+	}
+}
+
+func TestLowPriorityAges(t *testing.T) {
+	q := newPriorityQueue()
+
+	now := time.Now()
+	q.in <- Call{Method: "getblock", Priority: PriorityLow, Enqueued: now.Add(-3 * priorityAging)}
+	q.in <- Call{Method: "sendrawtransaction", Priority: PriorityHigh, Enqueued: now}
+
+	if call := <-q.out; "getblock" != call.Method {
+		t.Errorf("long waiting call not first: %s", call.Method)
+	}
+	if call := <-q.out; "sendrawtransaction" != call.Method {
+		t.Errorf("second call: %s", call.Method)
+	}
+}
+
+func TestPriorityFromContext(t *testing.T) {
+	if PriorityNormal != priorityFrom(context.Background()) {
+		t.Errorf("default priority: %d", priorityFrom(context.Background()))
+	}
+	ctx := WithPriority(context.Background(), PriorityHigh)
+	if PriorityHigh != priorityFrom(ctx) {
+		t.Errorf("priority: %d", priorityFrom(ctx))
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// the raw transactions a backend was sent
+func broadcasts(backend *fakeBitcoind) map[string]bool {
+	sent := make(map[string]bool)
+	for _, call := range backend.receivedFor("sendrawtransaction") {
+		var params []string
+		json.Unmarshal([]byte(call[len("sendrawtransaction"):]), &params)
+		sent[params[0]] = true
+	}
+	return sent
+}
+
+func TestShutdownCallNotRunLater(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	first, err := NewRemoteConnection(backend.URL, "user", "password", "regtest", nil)
+	if nil != err {
+		t.Fatalf("connect error: %v", err)
+	}
+	first.DestroyWithTimeout(time.Second)
+
+	// a failed call may or may not have reached the queue
+	for i := 0; i < 20; i += 1 {
+		_, _, err = RemoteCall("sendrawtransaction", rawArguments(fmt.Sprintf(`"%02x"`, i)))
+		if !errors.Is(err, ErrShuttingDown) {
+			t.Fatalf("error: %v  expected: %v", err, ErrShuttingDown)
+		}
+	}
+
+	// a connection joining later must not broadcast the failed calls
+	backend.connect(t)
+	time.Sleep(100 * time.Millisecond)
+	if sent := broadcasts(backend); 0 != len(sent) {
+		t.Errorf("calls broadcast after their callers were told they failed: %v", sent)
+	}
+}
+
+func TestQueueStress(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+
+	const (
+		callers = 20
+		calls   = 25
+	)
+
+	var mu sync.Mutex
+	failed := make(map[string]error)
+	succeeded := make(map[string]bool)
+
+	// connections come and go while the calls are made
+	stop := make(chan struct{})
+	churned := make(chan struct{})
+	go func() {
+		defer close(churned)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			conn, err := NewRemoteConnection(backend.URL, "user", "password", "regtest", nil)
+			if nil != err {
+				t.Errorf("connect error: %v", err)
+				return
+			}
+			time.Sleep(2 * time.Millisecond)
+			conn.DestroyWithTimeout(time.Second)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i += 1 {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < calls; j += 1 {
+				raw := fmt.Sprintf("%02x%02x", i, j)
+				_, _, err := RemoteCall("sendrawtransaction", rawArguments(`"`+raw+`"`))
+				mu.Lock()
+				if nil == err {
+					succeeded[raw] = true
+				} else {
+					failed[raw] = err
+				}
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	<-churned
+
+	// give any call wrongly left queued the chance to run
+	backend.connect(t)
+	time.Sleep(100 * time.Millisecond)
+
+	sent := broadcasts(backend)
+	for raw := range succeeded {
+		if !sent[raw] {
+			t.Errorf("%s succeeded but was not sent", raw)
+		}
+	}
+	for raw, err := range failed {
+		if !errors.Is(err, ErrShuttingDown) {
+			t.Errorf("%s error: %v", raw, err)
+		}
+		if sent[raw] {
+			t.Errorf("%s failed with %v but was sent", raw, err)
+		}
+	}
+	if callers*calls != len(succeeded)+len(failed) {
+		t.Errorf("%d results for %d calls", len(succeeded)+len(failed), callers*calls)
+	}
+}
+
+func TestDestroyWithTimeout(t *testing.T) {
+	backend := newHeldBackend(t, "getpeerinfo")
+	conn, err := NewRemoteConnection(backend.URL, "user", "password", "regtest", nil)
+	if nil != err {
+		t.Fatalf("connect error: %v", err)
+	}
+
+	failed := make(chan error, 1)
+	go func() {
+		_, _, err := RemoteCall("getpeerinfo", nil)
+		failed <- err
+	}()
+	eventually(t, "the slow call to start", func() bool {
+		running, _ := backend.counts()
+		return 1 == running
+	})
+
+	start := time.Now()
+	err = conn.DestroyWithTimeout(100 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("destroy took %v", elapsed)
+	}
+	var abandoned *AbandonedError
+	if !errors.As(err, &abandoned) || 1 != len(abandoned.Calls) || "getpeerinfo" != abandoned.Calls[0] {
+		t.Errorf("error: %v", err)
+	}
+
+	select {
+	case err := <-failed:
+		if nil == err {
+			t.Error("abandoned call succeeded")
+		}
+	case <-time.After(time.Second):
+		t.Error("abandoned call did not return")
+	}
+}
+
+func TestDestroyContextExpires(t *testing.T) {
+	backend := newHeldBackend(t, "getpeerinfo")
+	conn, err := NewRemoteConnection(backend.URL, "user", "password", "regtest", nil)
+	if nil != err {
+		t.Fatalf("connect error: %v", err)
+	}
+
+	const calls = 4
+	failed := make(chan error, calls)
+	for i := 0; i < calls; i += 1 {
+		go func() {
+			_, _, err := RemoteCall("getpeerinfo", nil)
+			failed <- err
+		}()
+	}
+	eventually(t, "the slow call to start", func() bool {
+		running, _ := backend.counts()
+		return 1 == running
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = conn.DestroyContext(ctx)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("destroy took %v", elapsed)
+	}
+	var abandoned *AbandonedError
+	if !errors.As(err, &abandoned) || 0 == len(abandoned.Calls) {
+		t.Fatalf("error: %v", err)
+	}
+	for _, method := range abandoned.Calls {
+		if "getpeerinfo" != method {
+			t.Errorf("abandoned: %v", abandoned.Calls)
+		}
+	}
+
+	// the calls still running or queued are all failed
+	for i := 0; i < calls; i += 1 {
+		select {
+		case err := <-failed:
+			if !errors.Is(err, ErrShuttingDown) {
+				t.Errorf("error: %v  expected: %v", err, ErrShuttingDown)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("call did not return")
+		}
+	}
+}
+
+func TestDestroyContextDrains(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	conn, err := NewRemoteConnection(backend.URL, "user", "password", "regtest", nil)
+	if nil != err {
+		t.Fatalf("connect error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := conn.DestroyContext(ctx); nil != err {
+		t.Errorf("error: %v", err)
+	}
+	if _, _, err := RemoteCall("getpeerinfo", nil); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("error: %v  expected: %v", err, ErrShuttingDown)
+	}
+}
+
+func TestDestroyContextLeavesSharedQueues(t *testing.T) {
+	healthy := newHeldBackend(t, "getpeerinfo")
+	healthy.connect(t)
+	first := make(chan error, 1)
+	go func() {
+		_, _, err := RemoteCall("getpeerinfo", nil)
+		first <- err
+	}()
+	eventually(t, "the healthy connection to be busy", func() bool {
+		running, _ := healthy.counts()
+		return 1 == running
+	})
+
+	// a call this connection took while draining would be held and
+	// then aborted
+	draining := newHeldBackend(t, "getpeerinfo")
+	conn, err := NewRemoteConnection(draining.URL, "user", "password", "regtest", nil)
+	if nil != err {
+		t.Fatalf("connect error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	destroyed := make(chan error, 1)
+	go func() {
+		destroyed <- conn.DestroyContext(ctx)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	second := make(chan error, 1)
+	go func() {
+		_, _, err := RemoteCall("getpeerinfo", nil)
+		second <- err
+	}()
+	if err := <-destroyed; nil != err {
+		t.Errorf("destroy error: %v", err)
+	}
+	if calls := draining.receivedFor("getpeerinfo"); 0 != len(calls) {
+		t.Errorf("draining connection took: %v", calls)
+	}
+
+	// the queued call waits for the other connection
+	healthy.done()
+	for _, result := range []chan error{first, second} {
+		if err := <-result; nil != err {
+			t.Errorf("error: %v", err)
+		}
+	}
+}
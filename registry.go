@@ -0,0 +1,146 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// errors
+var (
+	ErrUnknownConnection = errors.New("unknown connection")
+	ErrNoConnection      = errors.New("no connection")
+	ErrAmbiguousChain    = errors.New("connections to several chains, call a named connection")
+)
+
+// queues shared by the connections to one chain
+type chainQueues struct {
+	reads  *priorityQueue
+	writes *priorityQueue // calls that change state
+}
+
+// connections by name
+var registry = struct {
+	sync.RWMutex
+	connections map[string]*RemoteConnection
+	routes      map[string]string       // method to connection name
+	queues      map[string]*chainQueues // by chain
+}{
+	connections: make(map[string]*RemoteConnection),
+	routes:      make(map[string]string),
+	queues:      make(map[string]*chainQueues),
+}
+
+// the shared queues of a chain, created for its first connection
+func queuesFor(chain string) *chainQueues {
+	registry.Lock()
+	defer registry.Unlock()
+
+	queues, ok := registry.queues[chain]
+	if !ok {
+		queues = &chainQueues{
+			reads:  newPriorityQueue(),
+			writes: newPriorityQueue(),
+		}
+		registry.queues[chain] = queues
+	}
+	return queues
+}
+
+// the shared queues used by RemoteCall, those of the one chain with
+// connections serving it
+//
+// once every connection has gone the queues left fail calls with
+// ErrShuttingDown, calls for one of several chains need RemoteCallNamed
+func defaultQueues() (*chainQueues, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	var found, idle *chainQueues
+	for _, queues := range registry.queues {
+		if !queues.reads.serving() && !queues.writes.serving() {
+			idle = queues
+			continue
+		}
+		if nil != found {
+			return nil, ErrAmbiguousChain
+		}
+		found = queues
+	}
+	switch {
+	case nil != found:
+		return found, nil
+	case nil != idle:
+		return nil, ErrShuttingDown
+	default:
+		return nil, ErrNoConnection
+	}
+}
+
+// make a connection available by name, replacing any previous one
+func Register(name string, conn *RemoteConnection) {
+	registry.Lock()
+	registry.connections[name] = conn
+	registry.Unlock()
+}
+
+// remove a named connection
+func Unregister(name string) {
+	registry.Lock()
+	delete(registry.connections, name)
+	registry.Unlock()
+}
+
+// find a named connection
+func Lookup(name string) (*RemoteConnection, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	conn, ok := registry.connections[name]
+	return conn, ok
+}
+
+// RPC call to a single named connection instead of the shared queues,
+// e.g. for applications connected to several chains
+func RemoteCallNamed(name string, method string, arguments []json.RawMessage) (json.RawMessage, json.RawMessage, error) {
+	conn, ok := Lookup(name)
+	if !ok {
+		return jsonNull, jsonNull, ErrUnknownConnection
+	}
+	return queueCall(context.Background(), conn.queue, conn.shutdown, method, arguments)
+}
+
+// send RemoteCalls of method to the named connection, e.g. an
+// archival replica for heavy queries, instead of the shared queue;
+// an empty name removes the route
+//
+// tip and write methods always use the shared queues, except the
+// node control methods, and while no connection is registered under
+// the name the shared queue is used
+func SetMethodRoute(method string, name string) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if "" == name {
+		delete(registry.routes, method)
+		return
+	}
+	registry.routes[method] = name
+}
+
+// the connection a method is routed to, if any
+func routedConnection(method string) (*RemoteConnection, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	name, ok := registry.routes[method]
+	if !ok {
+		return nil, false
+	}
+	conn, ok := registry.connections[name]
+	return conn, ok
+}
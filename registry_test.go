@@ -0,0 +1,128 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// register a connection for the rest of the test
+func register(t *testing.T, name string, conn *RemoteConnection) {
+	Register(name, conn)
+	t.Cleanup(func() {
+		Unregister(name)
+	})
+}
+
+func TestRegistryNamedConnections(t *testing.T) {
+	local := newFakeBitcoind(t, 200000, nil)
+	signet := newFakeBitcoind(t, 200000, nil)
+	signet.chain = "signet"
+
+	register(t, "local", local.connect(t))
+	signetConnection, err := NewRemoteConnection(signet.URL, "user", "password", "signet", nil)
+	if nil != err {
+		t.Fatalf("connect error: %v", err)
+	}
+	register(t, "signet", signetConnection)
+
+	if conn, ok := Lookup("signet"); !ok || signetConnection != conn {
+		t.Errorf("lookup found: %v", conn)
+	}
+
+	_, _, err = RemoteCallNamed("local", "getblockhash", rawArguments(`1`))
+	if nil != err {
+		t.Fatalf("local error: %v", err)
+	}
+	_, _, err = RemoteCallNamed("signet", "getblockhash", rawArguments(`2`))
+	if nil != err {
+		t.Fatalf("signet error: %v", err)
+	}
+	if calls := local.received(); 1 != len(calls) || "getblockhash[1]" != calls[0] {
+		t.Errorf("local calls: %v", calls)
+	}
+	if calls := signet.received(); 1 != len(calls) || "getblockhash[2]" != calls[0] {
+		t.Errorf("signet calls: %v", calls)
+	}
+
+	_, _, err = RemoteCallNamed("mainnet", "getblockhash", rawArguments(`3`))
+	if !errors.Is(err, ErrUnknownConnection) {
+		t.Errorf("error: %v  expected: %v", err, ErrUnknownConnection)
+	}
+
+	// the chains do not share queues, so an unnamed call cannot choose
+	_, _, err = RemoteCall("getblockhash", rawArguments(`4`))
+	if !errors.Is(err, ErrAmbiguousChain) {
+		t.Errorf("error: %v  expected: %v", err, ErrAmbiguousChain)
+	}
+
+	signetConnection.DestroyWithTimeout(time.Second)
+	_, _, err = RemoteCall("getblockhash", rawArguments(`5`))
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if calls := local.receivedFor("getblockhash"); 2 != len(calls) || "getblockhash[5]" != calls[1] {
+		t.Errorf("local calls: %v", calls)
+	}
+	if calls := signet.received(); 1 != len(calls) {
+		t.Errorf("signet calls: %v", calls)
+	}
+}
+
+func TestMethodRoutes(t *testing.T) {
+	primary := genesisBackend(t)
+	replica := genesisBackend(t)
+	register(t, "primary", primary.connect(t))
+	register(t, "replica", replica.connect(t))
+	SetMethodRoute("getblock", "replica")
+	SetMethodRoute("getblockhash", "primary")
+	t.Cleanup(func() {
+		SetMethodRoute("getblock", "")
+		SetMethodRoute("getblockhash", "")
+	})
+
+	for i := 0; i < 10; i += 1 {
+		_, _, err := RemoteCall("getblock", rawArguments(`"`+genesisHash+`"`))
+		if nil != err {
+			t.Fatalf("getblock error: %v", err)
+		}
+		_, _, err = RemoteCall("getblockhash", rawArguments(strconv.Itoa(i)))
+		if nil != err {
+			t.Fatalf("getblockhash error: %v", err)
+		}
+	}
+	if calls := replica.receivedFor("getblock"); 10 != len(calls) {
+		t.Errorf("replica getblock calls: %d", len(calls))
+	}
+	if calls := primary.receivedFor("getblock"); 0 != len(calls) {
+		t.Errorf("primary getblock calls: %d", len(calls))
+	}
+	if calls := primary.receivedFor("getblockhash"); 10 != len(calls) {
+		t.Errorf("primary getblockhash calls: %d", len(calls))
+	}
+	if calls := replica.receivedFor("getblockhash"); 0 != len(calls) {
+		t.Errorf("replica getblockhash calls: %d", len(calls))
+	}
+}
+
+func TestMethodRouteUnregistered(t *testing.T) {
+	backend := genesisBackend(t)
+	backend.connect(t)
+	SetMethodRoute("getblock", "replica")
+	t.Cleanup(func() {
+		SetMethodRoute("getblock", "")
+	})
+
+	_, _, err := RemoteCall("getblock", rawArguments(`"`+genesisHash+`"`))
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if calls := backend.receivedFor("getblock"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// number of tips remembered by the poller for finding fork heights
+const tipHistorySize = 100
+
+// tips seen by the poller and the last reorg they showed
+type tipHistory struct {
+	sync.Mutex
+	hashes     map[uint64]string
+	lastHeight uint64
+	lastHash   string
+
+	reorged    bool
+	forkHeight uint64
+	reorgTime  time.Time
+}
+
+// the most recent reorg seen by the tip poller (see WithTipPoller)
+//
+// blocks from forkHeight up need scanning again; it is just above the
+// highest tip the poller saw that is still in the chain, so it may be
+// lower than the first replaced block but never higher unless the
+// reorg is deeper than all the remembered tips
+func (conn *RemoteConnection) LastReorg() (bool, uint64, time.Time) {
+	conn.tips.Lock()
+	defer conn.tips.Unlock()
+	return conn.tips.reorged, conn.tips.forkHeight, conn.tips.reorgTime
+}
+
+// record a polled tip, checking the previous one is still in the chain
+func (conn *RemoteConnection) trackTip(ctx context.Context, info *ChainInfo) {
+
+	height, err := strconv.ParseUint(info.Blocks.String(), 10, 64)
+	if nil != err || "" == info.BestBlockHash {
+		return
+	}
+	hash := info.BestBlockHash
+
+	conn.tips.Lock()
+	if nil == conn.tips.hashes {
+		conn.tips.hashes = make(map[uint64]string)
+	}
+	previousHeight := conn.tips.lastHeight
+	previousHash := conn.tips.lastHash
+	seen := make(map[uint64]string, len(conn.tips.hashes))
+	for h, recorded := range conn.tips.hashes {
+		seen[h] = recorded
+	}
+	conn.tips.Unlock()
+
+	if hash == previousHash {
+		return
+	}
+
+	// the chain has changed at a height already seen, find how far down
+	if "" != previousHash && !conn.inChain(ctx, previousHeight, previousHash, height, hash) {
+		if nil != ctx.Err() {
+			return
+		}
+		heights := make([]uint64, 0, len(seen))
+		for h := range seen {
+			heights = append(heights, h)
+		}
+		sort.Slice(heights, func(i, j int) bool { return heights[i] > heights[j] })
+
+		// just above the highest tip still in the chain
+		fork := previousHeight
+		for _, h := range heights {
+			fork = h
+			if conn.inChain(ctx, h, seen[h], height, hash) {
+				fork = h + 1
+				break
+			}
+		}
+		if nil != ctx.Err() {
+			return
+		}
+
+		conn.tips.Lock()
+		conn.tips.reorged = true
+		conn.tips.forkHeight = fork
+		conn.tips.reorgTime = time.Now()
+		for h := range conn.tips.hashes {
+			if h >= fork {
+				delete(conn.tips.hashes, h)
+			}
+		}
+		conn.tips.Unlock()
+	}
+
+	conn.tips.Lock()
+	conn.tips.hashes[height] = hash
+	conn.tips.lastHeight = height
+	conn.tips.lastHash = hash
+	for h := range conn.tips.hashes {
+		if h+tipHistorySize <= height || h > height {
+			delete(conn.tips.hashes, h)
+		}
+	}
+	conn.tips.Unlock()
+}
+
+// check if a block seen earlier is still in the chain with the given tip
+//
+// getblockhash is called directly as the hash cache could be stale
+func (conn *RemoteConnection) inChain(ctx context.Context, height uint64, hash string, tipHeight uint64, tipHash string) bool {
+	if height > tipHeight {
+		return false
+	}
+	if height == tipHeight {
+		return hash == tipHash
+	}
+	var current string
+	err := conn.call(ctx, "getblockhash", []interface{}{height}, &current)
+	if nil != err {
+		return true // unknown, do not report a reorg
+	}
+	return hash == current
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a chain whose blocks can be replaced while it is being polled
+type reorgChain struct {
+	sync.Mutex
+	hashes []string
+}
+
+// extend the chain to tip, replacing the blocks from fork up with
+// ones from branch
+func (c *reorgChain) build(fork uint64, tip uint64, branch int) {
+	c.Lock()
+	defer c.Unlock()
+	c.hashes = c.hashes[:fork]
+	for height := fork; height <= tip; height += 1 {
+		c.hashes = append(c.hashes, fmt.Sprintf("%02x%062x", branch, height))
+	}
+}
+
+func (c *reorgChain) tip() (uint64, string) {
+	c.Lock()
+	defer c.Unlock()
+	return uint64(len(c.hashes) - 1), c.hashes[len(c.hashes)-1]
+}
+
+func (c *reorgChain) backend(t *testing.T) *fakeBitcoind {
+	return newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		height, hash := c.tip()
+		switch method {
+		case "getblockchaininfo":
+			return map[string]interface{}{"chain": "regtest", "blocks": height, "bestblockhash": hash}, nil
+		case "getblockcount":
+			return height, nil
+		case "getblockhash":
+			h, _ := strconv.ParseUint(string(params[0]), 10, 64)
+			if h > height {
+				return nil, &RPCError{Code: rpcInvalidParameter, Message: "Block height out of range"}
+			}
+			c.Lock()
+			defer c.Unlock()
+			return c.hashes[h], nil
+		}
+		return nil, nil
+	})
+}
+
+// wait for the poller to record the current tip of the chain
+func waitForTip(t *testing.T, conn *RemoteConnection, chain *reorgChain) {
+	_, hash := chain.tip()
+	eventually(t, "the poller to see "+hash, func() bool {
+		conn.tips.Lock()
+		defer conn.tips.Unlock()
+		return hash == conn.tips.lastHash
+	})
+}
+
+func TestLastReorg(t *testing.T) {
+	chain := &reorgChain{}
+	chain.build(0, 100, 0)
+	conn := chain.backend(t).connect(t, WithTipPoller(5*time.Millisecond))
+
+	// the chain growing is not a reorg
+	waitForTip(t, conn, chain)
+	for tip := uint64(101); tip <= 102; tip += 1 {
+		chain.build(tip, tip, 0)
+		waitForTip(t, conn, chain)
+	}
+	if reorged, _, _ := conn.LastReorg(); reorged {
+		t.Fatal("reorg reported for a growing chain")
+	}
+
+	// block 102 replaced and two more mined on the new branch
+	start := time.Now()
+	chain.build(102, 104, 1)
+	waitForTip(t, conn, chain)
+
+	reorged, fork, at := conn.LastReorg()
+	if !reorged || 102 != fork || at.Before(start) {
+		t.Errorf("reorged: %v  fork: %d  at: %v", reorged, fork, at)
+	}
+
+	// a deeper one later replaces the report
+	chain.build(101, 105, 2)
+	waitForTip(t, conn, chain)
+	if reorged, fork, _ := conn.LastReorg(); !reorged || 101 != fork {
+		t.Errorf("reorged: %v  fork: %d", reorged, fork)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// a call under construction on a connection, the first invalid
+// argument is reported by Do and nothing is sent
+type CallBuilder struct {
+	conn      *RemoteConnection
+	ctx       context.Context
+	method    string
+	arguments []json.RawMessage
+	err       error
+}
+
+// start building a call to method
+func (conn *RemoteConnection) Request(method string) *CallBuilder {
+	return &CallBuilder{
+		conn:   conn,
+		ctx:    context.Background(),
+		method: method,
+	}
+}
+
+// append a 32 byte hash given as hex
+func (r *CallBuilder) HashArg(hash string) *CallBuilder {
+	b, err := hex.DecodeString(hash)
+	if nil != err {
+		return r.fail(err)
+	}
+	if 32 != len(b) {
+		return r.fail(ErrHexLengthIncorrect)
+	}
+	return r.arg(hash)
+}
+
+// append a number
+func (r *CallBuilder) NumberArg(number uint64) *CallBuilder {
+	return r.arg(number)
+}
+
+// append a bool
+func (r *CallBuilder) BoolArg(flag bool) *CallBuilder {
+	return r.arg(flag)
+}
+
+// append a string
+func (r *CallBuilder) StringArg(s string) *CallBuilder {
+	return r.arg(s)
+}
+
+// make the call with ctx instead of the background context
+func (r *CallBuilder) Context(ctx context.Context) *CallBuilder {
+	r.ctx = ctx
+	return r
+}
+
+// send the call through the connection queue
+func (r *CallBuilder) Do() (json.RawMessage, json.RawMessage, error) {
+	if nil != r.err {
+		return jsonNull, jsonNull, r.err
+	}
+	return queueCall(r.ctx, r.conn.queue, r.conn.shutdown, r.method, r.arguments)
+}
+
+// encode and append an argument
+func (r *CallBuilder) arg(value interface{}) *CallBuilder {
+	if nil != r.err {
+		return r
+	}
+	argument, err := json.Marshal(value)
+	if nil != err {
+		return r.fail(err)
+	}
+	r.arguments = append(r.arguments, argument)
+	return r
+}
+
+// keep the first error
+func (r *CallBuilder) fail(err error) *CallBuilder {
+	if nil == r.err {
+		r.err = err
+	}
+	return r
+}
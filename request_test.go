@@ -0,0 +1,99 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCallBuilder(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		switch method {
+		case "getblock":
+			return json.RawMessage(genesisHeader), nil
+		case "getblockhash":
+			return genesisHash, nil
+		}
+		return nil, nil
+	})
+	conn := backend.connect(t)
+
+	result, _, err := conn.Request("getblock").HashArg(genesisHash).NumberArg(2).Context(context.Background()).Do()
+	if nil != err || !strings.Contains(string(result), genesisHash) {
+		t.Errorf("getblock result: %s  error: %v", result, err)
+	}
+	result, _, err = conn.Request("getblockhash").NumberArg(0).Do()
+	if nil != err || `"`+genesisHash+`"` != string(result) {
+		t.Errorf("getblockhash result: %s  error: %v", result, err)
+	}
+	_, _, err = conn.Request("getrawmempool").BoolArg(false).Do()
+	if nil != err {
+		t.Errorf("getrawmempool error: %v", err)
+	}
+	_, _, err = conn.Request("decoderawtransaction").StringArg(testTransaction).Do()
+	if nil != err {
+		t.Errorf("decoderawtransaction error: %v", err)
+	}
+
+	expected := []string{
+		`getblock["` + genesisHash + `",2]`,
+		`getblockhash[0]`,
+		`getrawmempool[false]`,
+		`decoderawtransaction["` + testTransaction + `"]`,
+	}
+	calls := backend.received()
+	if len(expected) != len(calls) {
+		t.Fatalf("calls: %v", calls)
+	}
+	for i := range expected {
+		if expected[i] != calls[i] {
+			t.Errorf("call %d: %s  expected: %s", i, calls[i], expected[i])
+		}
+	}
+}
+
+func TestCallBuilderInvalidHash(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	conn := backend.connect(t)
+
+	_, _, err := conn.Request("getblock").HashArg("00ff").NumberArg(1).Do()
+	if !errors.Is(err, ErrHexLengthIncorrect) {
+		t.Errorf("short hash error: %v  expected: %v", err, ErrHexLengthIncorrect)
+	}
+
+	// the first failure is kept
+	_, _, err = conn.Request("getblock").HashArg(strings.Repeat("zz", 32)).HashArg("00ff").Do()
+	var hexErr hex.InvalidByteError
+	if !errors.As(err, &hexErr) {
+		t.Errorf("non hex error: %v", err)
+	}
+
+	// built calls are still checked against the method
+	_, _, err = conn.Request("getblockhash").NumberArg(1).NumberArg(2).Do()
+	if !errors.Is(err, ErrTooManyArguments) {
+		t.Errorf("extra argument error: %v  expected: %v", err, ErrTooManyArguments)
+	}
+
+	if calls := backend.received(); 0 != len(calls) {
+		t.Errorf("invalid calls sent: %v", calls)
+	}
+}
+
+func TestCallBuilderContext(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	conn := backend.connect(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := conn.Request("getblockhash").NumberArg(1).Context(ctx).Do()
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error: %v  expected: %v", err, context.Canceled)
+	}
+}
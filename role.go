@@ -0,0 +1,51 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+)
+
+// errors
+var (
+	ErrInvalidRole = errors.New("invalid role: read, write or both expected")
+)
+
+// which calls a connection serves from the shared queues
+type Role int
+
+const (
+	RoleBoth Role = iota // default
+	RoleRead
+	RoleWrite
+)
+
+// methods that change state and are only sent to write-capable connections
+var writeMethods = map[string]bool{
+	"sendrawtransaction": true,
+	"addnode":            true,
+}
+
+// set the role of a connection, e.g. a trusted node for broadcasting
+// with RoleWrite and read replicas with RoleRead
+func WithRole(role Role) Option {
+	return func(conn *RemoteConnection) {
+		conn.role = role
+	}
+}
+
+// convert a configuration value to a role, empty is both
+func ParseRole(s string) (Role, error) {
+	switch s {
+	case "", "both":
+		return RoleBoth, nil
+	case "read":
+		return RoleRead, nil
+	case "write":
+		return RoleWrite, nil
+	default:
+		return RoleBoth, ErrInvalidRole
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRoleRouting(t *testing.T) {
+	writer := newFakeBitcoind(t, 200000, nil)
+	writer.connect(t, WithRole(RoleWrite))
+	reader := newFakeBitcoind(t, 200000, nil)
+	reader.connect(t, WithRole(RoleRead))
+	replica := newFakeBitcoind(t, 200000, nil)
+	replica.connect(t, WithRole(RoleRead))
+
+	for i := 0; i < 10; i += 1 {
+		_, _, err := RemoteCall("sendrawtransaction", rawArguments(fmt.Sprintf(`"%02x"`, i)))
+		if nil != err {
+			t.Fatalf("error: %v", err)
+		}
+		_, _, err = RemoteCall("getblockhash", rawArguments(fmt.Sprintf(`%d`, i)))
+		if nil != err {
+			t.Fatalf("error: %v", err)
+		}
+	}
+
+	if sent := broadcasts(writer); 10 != len(sent) {
+		t.Errorf("writer broadcast %d transactions", len(sent))
+	}
+	if calls := writer.receivedFor("getblockhash"); 0 != len(calls) {
+		t.Errorf("reads sent to the writer: %v", calls)
+	}
+	for _, backend := range []*fakeBitcoind{reader, replica} {
+		if calls := backend.receivedFor("sendrawtransaction"); 0 != len(calls) {
+			t.Errorf("writes sent to a reader: %v", calls)
+		}
+	}
+	if reads := len(reader.receivedFor("getblockhash")) + len(replica.receivedFor("getblockhash")); 10 != reads {
+		t.Errorf("readers served %d reads", reads)
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	for s, expected := range map[string]Role{"": RoleBoth, "both": RoleBoth, "read": RoleRead, "write": RoleWrite} {
+		role, err := ParseRole(s)
+		if nil != err || expected != role {
+			t.Errorf("%q parsed as: %v  error: %v", s, role, err)
+		}
+	}
+	_, err := ParseRole("primary")
+	if !errors.Is(err, ErrInvalidRole) {
+		t.Errorf("error: %v  expected: %v", err, ErrInvalidRole)
+	}
+}
+
+func TestReadersOnly(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t, WithRole(RoleRead), WithNodeControl())
+	backend.connect(t, WithRole(RoleRead))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	_, _, err := RemoteCallContext(ctx, "sendrawtransaction", rawArguments(`"00"`))
+	if !errors.Is(err, ErrNoConnection) {
+		t.Errorf("sendrawtransaction error: %v", err)
+	}
+	_, _, err = RemoteCallContext(ctx, "addnode", rawArguments(`"127.0.0.1:8333"`, `"add"`))
+	if !errors.Is(err, ErrNodeControlDisabled) {
+		t.Errorf("addnode error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("writes waited %v", elapsed)
+	}
+	if calls := backend.received(); 0 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+
+	_, _, err = RemoteCallContext(ctx, "getblockhash", rawArguments(`1`))
+	if nil != err {
+		t.Errorf("getblockhash error: %v", err)
+	}
+}
+
+func TestWritersOnly(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t, WithRole(RoleWrite))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, _, err := RemoteCallContext(ctx, "getblockhash", rawArguments(`1`))
+	if !errors.Is(err, ErrNoConnection) {
+		t.Errorf("getblockhash error: %v", err)
+	}
+	_, _, err = RemoteCallContext(ctx, "sendrawtransaction", rawArguments(`"00"`))
+	if nil != err {
+		t.Errorf("sendrawtransaction error: %v", err)
+	}
+}
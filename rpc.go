@@ -6,44 +6,63 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // global constants
 const (
 	bitcoinMinimumVersion = 90200 // do not start if bitcoind older than this
 	totalTries            = 5     // retry failed connections
+
+	bitcoinBoolVerboseVersion = 140000 // getrawtransaction accepts a bool verbose flag
+
+	drainIdleTime = 50 * time.Millisecond // DestroyContext stops once no call arrives in this time
 )
 
 // errors
 var (
 	//ErrNotInitialised          = errors.New("not initialised")
-	ErrInvalidBitcoinVersion   = errors.New("invalid bitcoin version")
-	ErrInvalidBitcoinChain     = errors.New("invalid bitcoin chain")
-	ErrInvalidMethod           = errors.New("invalid method")
-	ErrTooFewArguments         = errors.New("too few arguments")
-	ErrTooManyArguments        = errors.New("too many arguments")
-	ErrInvalidArgumentType     = errors.New("invalid argument type")
-	ErrRpcError                = errors.New("RPC error")
-	ErrIncomprehesibleResponse = errors.New("incomprehesible response")
-	ErrHexLengthIncorrect      = errors.New("hex length incorrect")
-	ErrInvalidBool             = errors.New("invalid bool: 0/1 expected")
-	ErrAccessDenied            = errors.New("Access denied")
+	ErrInvalidBitcoinVersion       = errors.New("invalid bitcoin version")
+	ErrInvalidBitcoinChain         = errors.New("invalid bitcoin chain")
+	ErrInvalidMethod               = errors.New("invalid method")
+	ErrTooFewArguments             = errors.New("too few arguments")
+	ErrTooManyArguments            = errors.New("too many arguments")
+	ErrInvalidArgumentType         = errors.New("invalid argument type")
+	ErrRpcError                    = errors.New("RPC error")
+	ErrIncomprehesibleResponse     = errors.New("incomprehesible response")
+	ErrHexLengthIncorrect          = errors.New("hex length incorrect")
+	ErrInvalidBool                 = errors.New("invalid bool: 0/1 expected")
+	ErrAccessDenied                = errors.New("Access denied")
+	ErrMismatchedID                = errors.New("response id does not match request")
+	ErrInvalidURL                  = errors.New("invalid URL: expected http or https scheme and a host")
+	ErrMethodNotSupportedByVersion = errors.New("method not supported by bitcoind version")
+	ErrShuttingDown                = errors.New("shutting down")
+	ErrRequestTooLarge             = errors.New("request too large")
+	ErrResponseTooLarge            = errors.New("response too large")
 )
 
 // RPC request
 type Call struct {
+	Context   context.Context
 	Method    string
 	Arguments []json.RawMessage
 	Response  chan interface{}
 	Tries     int
+	Priority  Priority
+	Enqueued  time.Time
 }
 
 // globals for background proccess
@@ -53,62 +72,334 @@ type RemoteConnection struct {
 	// connection to bitcoin daemon
 	client *http.Client
 	url    string
+	wallet string
+
+	// negotiated TLS of the last successful request
+	tlsState *tls.ConnectionState
 
 	// authentication
 	username string
 	password string
 
-	// identifier for the RPC
-	id uint64
+	// identifier for the RPC, advanced by idStep for each call
+	id     uint64
+	idStep uint64
+
+	// which shared queues to serve
+	role Role
+
+	// do not drop trailing null arguments
+	strictArguments bool
+
+	// per-method checks on results
+	validators map[string]ResponseValidator
+
+	// gzip large request bodies
+	compressRequests bool
+
+	// largest encoded request body sent, zero for no limit
+	maxRequestSize int
+
+	// largest reply body read, zero for no limit
+	maxResponseSize int64
+
+	// keep-alive connections opened in advance
+	warmup int
+
+	// allow addnode and getaddednodeinfo
+	nodeControl bool
+
+	// limits applied to all RemoteCalls while the connection exists
+	maxInFlight  limit
+	methodLimits map[string]limit
+
+	// methods whose reply bodies are not awaited
+	notifications map[string]bool
+
+	// canonical to upstream method names
+	upstreamNames map[string]string
+
+	// check verbose results are for the requested hash
+	verifyHashes bool
+
+	// answer as of a fixed height
+	frozen       bool
+	frozenHeight uint64
 
-	// current height
+	// limit on each backend attempt, zero for none
+	requestTimeout time.Duration
+
+	// recent latency for shedding low priority calls
+	latency       latencyTracker
+	shedThreshold time.Duration
+	shedBelow     Priority
+
+	// debug logging, calls are logged if the limit is positive
+	logger   Logger
+	logLimit int
+
+	// height to hash for blocks unlikely to be reorganised
+	blockHashes blockHashCache
+
+	// blocks fetched ahead by IterateBlocks
+	blockPrefetch int
+
+	// highest getblock verbosity clients may ask for, if limited
+	maxBlockVerbosity   uint64
+	limitBlockVerbosity bool
+
+	// calls per batch for helpers that split requests
+	maxBatchSize int
+
+	// bootstrap checks, possibly deferred to the first call
+	chain         string
+	lazyBootstrap bool
+	bootstrapLock sync.Mutex
+	bootstrapped  bool
+	bootstrapErr  error
+
+	// daemon version from bootstrap
+	version            uint64
+	expectedSubversion string
+
+	// forwarded method patterns, nil for no restriction
+	methodPolicy []Rule
+
+	// already known transactions count as sent
+	idempotentSubmit bool
+
+	// build getinfo from its replacements when the daemon lacks it
+	getInfoShim       bool
+	synthesizeGetInfo bool // set by bootstrap
+
+	// retry transaction lookups with a block hash when there is no index
+	blockHashRetry  bool
+	blockHashLookup func(ctx context.Context, txid string) (string, bool)
+
+	// enabled indexes from bootstrap when gating
+	indexGating bool
+	indexes     map[string]bool
+
+	// current height and when it was seen
 	latestBlockNumber uint64
+	latestBlockTime   time.Time
+	pollInterval      time.Duration
+
+	// tips seen by the poller, for reorg detection
+	tips tipHistory
+
+	// blockchain status from bootstrap, refreshed by the tip poller
+	chainInfo     *ChainInfo
+	chainInfoTime time.Time
+
+	// recent call outcomes
+	health health
+
+	// background health probe
+	probeMethod      string
+	probeInterval    time.Duration
+	onReconnect      func(attempt int, err error, recovered bool)
+	reconnectAttempt int
+	rotationCheck    bool // any failed probe withdraws the connection
+	outOfRotation    bool
 
 	// for the background
-	shutdown chan bool
-	finished chan bool
+	ctx       context.Context // cancelled to abort in-flight calls
+	cancel    context.CancelFunc
+	inFlight  *Call
+	abandoned []string
+	queue     chan Call       // calls for only this connection
+	drain     context.Context // set by DestroyContext to serve waiting calls
+	shutdown  chan bool
+	finished  chan bool
 }
 
-// shared queue
-var sharedQueue = make(chan Call)
+// attempts made by RemoteCall
+var callTries int32 = totalTries
+
+// set the number of attempts made by RemoteCall for a failed call,
+// 1 disables retries so the first error is returned immediately
+func SetTries(tries int) {
+	if tries < 1 {
+		tries = 1
+	}
+	atomic.StoreInt32(&callTries, int32(tries))
+}
 
 // external API
 // ------------
 
+// optional settings for NewRemoteConnection
+type Option func(*RemoteConnection)
+
+// count trailing null arguments instead of treating them as omitted,
+// so ["hash", null] is two arguments and fails validation
+func WithStrictArguments() Option {
+	return func(conn *RemoteConnection) {
+		conn.strictArguments = true
+	}
+}
+
+// send methods upstream under different names, e.g. for an RPC
+// implementation that renamed them; validation, caching and hooks
+// still use the canonical names
+func WithUpstreamMethodNames(names map[string]string) Option {
+	return func(conn *RemoteConnection) {
+		if nil == conn.upstreamNames {
+			conn.upstreamNames = make(map[string]string)
+		}
+		for method, upstream := range names {
+			conn.upstreamNames[method] = upstream
+		}
+	}
+}
+
+// the name a method is sent upstream as
+func (conn *RemoteConnection) upstreamMethod(method string) string {
+	if upstream, ok := conn.upstreamNames[method]; ok {
+		return upstream
+	}
+	return method
+}
+
+// fail calls and batches whose encoded body is over size bytes with
+// ErrRequestTooLarge instead of sending them, the limit applies
+// before any compression
+func WithMaxRequestSize(size int) Option {
+	return func(conn *RemoteConnection) {
+		conn.maxRequestSize = size
+	}
+}
+
+// fail calls and batches whose reply body is over size bytes with
+// ErrResponseTooLarge; the bytes are counted as they are read so the
+// limit holds for chunked replies without a Content-Length, which are
+// abandoned as soon as they pass it
+func WithMaxResponseSize(size int64) Option {
+	return func(conn *RemoteConnection) {
+		conn.maxResponseSize = size
+	}
+}
+
+// start the RPC id counter at base instead of zero, so that with
+// WithIDStep proxies sharing a backend can use separate id spaces,
+// e.g. base 0 and 1 with step 2 give even and odd ids
+func WithIDBase(base uint64) Option {
+	return func(conn *RemoteConnection) {
+		conn.id = base
+	}
+}
+
+// advance the RPC id counter by step for each call instead of one,
+// the ids of a batch are then step apart
+func WithIDStep(step uint64) Option {
+	return func(conn *RemoteConnection) {
+		conn.idStep = step
+	}
+}
+
+// the id counter increment
+func (conn *RemoteConnection) idIncrement() uint64 {
+	if 0 == conn.idStep {
+		return 1
+	}
+	return conn.idStep
+}
+
+// allocate the ids of n calls and return the first
+func (conn *RemoteConnection) nextIDs(n uint64) uint64 {
+	step := conn.idIncrement()
+	return atomic.AddUint64(&conn.id, n*step) - (n-1)*step
+}
+
 // connet to a either bitcoind or a miniature-spoon proxy
-func NewRemoteConnection(url string, username string, password string, chain string, tls *tls.Config) (*RemoteConnection, error) {
+func NewRemoteConnection(url string, username string, password string, chain string, tls *tls.Config, options ...Option) (*RemoteConnection, error) {
+	return NewRemoteConnectionContext(context.Background(), url, username, password, chain, tls, options...)
+}
+
+// connect with the bootstrap checks abandoned if the context is done,
+// the context does not apply to calls made on the connection later
+func NewRemoteConnectionContext(ctx context.Context, url string, username string, password string, chain string, tls *tls.Config, options ...Option) (*RemoteConnection, error) {
+
+	// the URL is the POST target as given, including any sub-path
+	// but must be absolute, e.g. not a bare host:port
+	u, err := parseEndpoint(url)
+	if nil != err {
+		return nil, err
+	}
+
+	// credentials in the URL are used only if none were given
+	// and are never kept in the stored URL
+	username, password = takeCredentials(u, username, password)
 
 	conn := RemoteConnection{
 		id:       0,
 		username: username,
 		password: password,
-		url:      url,
+		url:      u.String(),
 
 		client: &http.Client{},
 
+		queue:    make(chan Call),
 		shutdown: make(chan bool),
 		finished: make(chan bool),
 	}
 
+	conn.ctx, conn.cancel = context.WithCancel(context.Background())
+
+	for _, option := range options {
+		option(&conn)
+	}
+
+	if "" != conn.wallet {
+		conn.url = walletEndpoint(u, conn.wallet).String()
+	}
+
 	if nil != tls {
 		conn.client.Transport = &http.Transport{
 			TLSClientConfig: tls,
 		}
 	}
+	conn.keepWarmConnections()
 
-	// query bitcoind for blockchain status
-	// only need to have necessary fields as JSON unmarshaller will ignore excess
-	var blockchainReply struct {
-		Chain string `json:"chain"`
-		//Blocks uint64 `json:"blocks"`
+	conn.chain = chain
+
+	// check the daemon now unless deferred to the first call
+	if !conn.lazyBootstrap {
+		err = conn.bootstrap(ctx)
+		if nil != err {
+			conn.cancel()
+			return nil, err
+		}
+		conn.bootstrapped = true
+		conn.warmUp(ctx)
+	}
+
+	// start background processes, joining the shared queues first
+	// so that calls made as soon as this returns are not refused
+	reads, writes := conn.joinQueues()
+	conn.joinNodeControl()
+	conn.joinLimits()
+	go conn.background(reads, writes)
+	if conn.pollInterval > 0 {
+		go conn.poller()
 	}
+
+	return &conn, nil
+}
+
+// check the chain and version of the daemon and record its state
+func (conn *RemoteConnection) bootstrap(ctx context.Context) error {
+
+	// query bitcoind for blockchain status
+	var blockchainReply ChainInfo
 	var rpcErr interface{}
-	err := conn.remoteCall("getblockchaininfo", []interface{}{}, &blockchainReply, &rpcErr)
+	err := conn.remoteCall(ctx, "getblockchaininfo", []interface{}{}, &blockchainReply, &rpcErr)
 	if nil != err {
-		return nil, err
+		return err
 	}
-	if chain != blockchainReply.Chain {
-		return nil, ErrInvalidBitcoinChain
+	if conn.chain != blockchainReply.Chain {
+		return ErrInvalidBitcoinChain
 	}
 
 	// query bitcoind for general status
@@ -117,23 +408,75 @@ func NewRemoteConnection(url string, username string, password string, chain str
 		Version uint64 `json:"version"`
 		Blocks  uint64 `json:"blocks"`
 	}
-	err = conn.remoteCall("getinfo", []interface{}{}, &infoReply, &rpcErr)
+	var infoErr *RPCError
+	err = conn.remoteCall(ctx, "getinfo", []interface{}{}, &infoReply, &infoErr)
+	if conn.getInfoShim && getInfoRemoved(err, infoErr) {
+		conn.synthesizeGetInfo = true
+		var synthesized json.RawMessage
+		synthesized, err = conn.synthesizeInfo(ctx)
+		if nil == err {
+			err = json.Unmarshal(synthesized, &infoReply)
+		}
+	}
 	if nil != err {
-		return nil, err
+		return err
 	}
 
 	// check version is sufficient
 	if infoReply.Version < bitcoinMinimumVersion {
-		return nil, ErrInvalidBitcoinVersion
+		return ErrInvalidBitcoinVersion
+	}
+
+	// check which implementation this is
+	err = conn.checkSubversion(ctx)
+	if nil != err {
+		return err
+	}
+
+	// find which indexes are available
+	if conn.indexGating {
+		err = conn.loadIndexes(ctx, infoReply.Version)
+		if nil != err {
+			return err
+		}
 	}
 
-	// set up current block number
-	conn.latestBlockNumber = infoReply.Blocks
+	// set up version and current block number
+	conn.Lock()
+	conn.version = infoReply.Version
+	conn.Unlock()
+	conn.setLatestBlockNumber(infoReply.Blocks)
+	conn.setChainInfo(&blockchainReply)
+	return nil
+}
+
+// defer the bootstrap checks from NewRemoteConnection to the first call
+func WithLazyBootstrap() Option {
+	return func(conn *RemoteConnection) {
+		conn.lazyBootstrap = true
+	}
+}
 
-	// start background processes
-	go conn.background(sharedQueue)
+// run a deferred bootstrap once
+//
+// a wrong chain, version or subversion fails every call, other errors (e.g. the
+// daemon not yet running) fail only this call and the next retries
+func (conn *RemoteConnection) ensureBootstrap(ctx context.Context) error {
+	conn.bootstrapLock.Lock()
+	defer conn.bootstrapLock.Unlock()
 
-	return &conn, nil
+	if conn.bootstrapped {
+		return conn.bootstrapErr
+	}
+	err := conn.bootstrap(ctx)
+	if nil == err {
+		conn.warmUp(ctx)
+	}
+	if nil == err || errors.Is(err, ErrInvalidBitcoinChain) || errors.Is(err, ErrInvalidBitcoinVersion) || errors.Is(err, ErrUnexpectedSubversion) {
+		conn.bootstrapped = true
+		conn.bootstrapErr = err
+	}
+	return err
 }
 
 // finialise - stop all background tasks
@@ -144,6 +487,110 @@ func (conn *RemoteConnection) Destroy() {
 
 	// wait for stop
 	<-conn.finished
+	conn.cancel()
+}
+
+// finalise - cancel any in-flight call and stop all background tasks
+// waiting at most d for the background to finish
+//
+// returns an *AbandonedError listing calls that were cancelled
+// or were still running when the timeout expired
+func (conn *RemoteConnection) DestroyWithTimeout(d time.Duration) error {
+
+	// stop background and abort the current call
+	close(conn.shutdown)
+	conn.cancel()
+
+	// wait for stop
+	finished := true
+	select {
+	case <-conn.finished:
+	case <-time.After(d):
+		finished = false
+	}
+
+	conn.RLock()
+	abandoned := append([]string{}, conn.abandoned...)
+	if !finished && nil != conn.inFlight {
+		abandoned = append(abandoned, conn.inFlight.Method)
+	}
+	conn.RUnlock()
+
+	if 0 != len(abandoned) {
+		return &AbandonedError{
+			Calls: abandoned,
+		}
+	}
+	return nil
+}
+
+// finalise - keep serving calls already queued until the queues are
+// empty or the context is done, then cancel any in-flight call and
+// stop; the shared queues are only drained if no other connection
+// serves them, calls left in them are served by the remaining
+// connections or, if there are none, fail with ErrShuttingDown
+//
+// returns an *AbandonedError listing calls that were cancelled
+func (conn *RemoteConnection) DestroyContext(ctx context.Context) error {
+
+	// stop background after draining
+	conn.drain = ctx
+	close(conn.shutdown)
+
+	// wait for the drain, aborting it when the context is done
+	select {
+	case <-conn.finished:
+	case <-ctx.Done():
+		conn.cancel()
+		<-conn.finished
+	}
+	conn.cancel()
+
+	conn.RLock()
+	abandoned := append([]string{}, conn.abandoned...)
+	conn.RUnlock()
+
+	if 0 != len(abandoned) {
+		return &AbandonedError{
+			Calls: abandoned,
+		}
+	}
+	return nil
+}
+
+// calls abandoned by DestroyWithTimeout or DestroyContext
+type AbandonedError struct {
+	Calls []string // method names
+}
+
+func (e *AbandonedError) Error() string {
+	return fmt.Sprintf("shutdown abandoned calls: %s", strings.Join(e.Calls, ", "))
+}
+
+// a single connection used directly, without the queues, so that
+// embedders can do their own scheduling; calls are still validated
+type Backend interface {
+	Do(ctx context.Context, method string, arguments []json.RawMessage) (json.RawMessage, json.RawMessage, error)
+}
+
+var _ Backend = (*RemoteConnection)(nil)
+
+// process one call on this connection immediately, without retries
+func (conn *RemoteConnection) Do(ctx context.Context, method string, arguments []json.RawMessage) (json.RawMessage, json.RawMessage, error) {
+
+	var reply json.RawMessage
+	var rpcErr json.RawMessage
+	err := conn.processCall(ctx, method, arguments, &reply, &rpcErr)
+	if nil != err {
+		return jsonNull, jsonNull, err
+	}
+	if !isNull(rpcErr) {
+		return jsonNull, rpcErr, nil
+	}
+	if isNull(reply) {
+		return jsonNull, jsonNull, nil
+	}
+	return reply, jsonNull, nil
 }
 
 // some types for RPC results
@@ -155,27 +602,173 @@ var jsonNull = json.RawMessage("null")
 
 // the main RPC calling routine
 func RemoteCall(method string, arguments []json.RawMessage) (json.RawMessage, json.RawMessage, error) {
-	r := make(chan interface{})
+	return RemoteCallContext(context.Background(), method, arguments)
+}
+
+// RPC call that gives up waiting when the context is done
+func RemoteCallContext(ctx context.Context, method string, arguments []json.RawMessage) (json.RawMessage, json.RawMessage, error) {
+
+	ttl := methodTTL(method)
+	if notModified(ctx, ttl, arguments) {
+		return jsonNull, jsonNull, ErrNotModified
+	}
+
+	key := ""
+	if 0 != ttl {
+		key = callKey(method, arguments)
+	}
+	if 0 != ttl && !cacheBypassed(ctx) {
+		if result, ok := cacheLookup(key); ok {
+			recordCached(ctx)
+			return result, jsonNull, nil
+		}
+	}
+
+	result, rpcErr, err := dispatchCall(ctx, method, arguments)
+
+	// only successful results are cached
+	if 0 != ttl && nil == err && isNull(rpcErr) {
+		cacheStore(key, result, ttl)
+	}
+	if nil == err && !isNull(rpcErr) && sanitizing(ctx) {
+		rpcErr = sanitizeError(rpcErr)
+	}
+	return result, rpcErr, err
+}
+
+// RPC call with a single error path: an RPC error from bitcoind is
+// returned as an *RPCError instead of in a separate raw result
+func RemoteCallResult(ctx context.Context, method string, arguments []json.RawMessage) (json.RawMessage, error) {
+	result, rpcErr, err := RemoteCallContext(ctx, method, arguments)
+	if nil != err {
+		return nil, err
+	}
+	if !isNull(rpcErr) {
+		return nil, rpcErrorFrom(rpcErr)
+	}
+	return result, nil
+}
+
+// route a call to the appropriate queue
+func dispatchCall(ctx context.Context, method string, arguments []json.RawMessage) (json.RawMessage, json.RawMessage, error) {
+	if nodeMethods[method] {
+		conn, ok := nodeConnection(method)
+		if !ok {
+			return jsonNull, jsonNull, ErrNodeControlDisabled
+		}
+		return queueCall(ctx, conn.queue, conn.shutdown, method, arguments)
+	}
+	coalesced := tipMethods[method] && 0 == len(arguments)
+	if !coalesced && !writeMethods[method] {
+		if conn, ok := routedConnection(method); ok {
+			return queueCall(ctx, conn.queue, conn.shutdown, method, arguments)
+		}
+	}
+
+	queues, err := defaultQueues()
+	if nil != err {
+		return jsonNull, jsonNull, err
+	}
+
+	// a call would wait forever in a queue no worker has joined,
+	// e.g. a write when every connection has RoleRead
+	queue := queues.reads
+	if writeMethods[method] {
+		queue = queues.writes
+	}
+	if !queue.serving() {
+		return jsonNull, jsonNull, ErrNoConnection
+	}
+
+	if coalesced {
+		return coalescedCall(ctx, queues, method)
+	}
+	if "sendrawtransaction" == method {
+		if s, ok := joinSubmission(ctx, queues, arguments); ok {
+			return s.wait(ctx)
+		}
+	}
+	return queueCall(ctx, queue.in, queue.done(), method, arguments)
+}
+
+// send a call through a queue, retrying on failure
+//
+// once stopped is closed nothing will serve the queue, so unless the
+// call has already been answered it fails with ErrShuttingDown
+func queueCall(ctx context.Context, queue chan<- Call, stopped <-chan bool, method string, arguments []json.RawMessage) (json.RawMessage, json.RawMessage, error) {
+
+	// cancelled on return so that a call left queued, e.g. after
+	// ErrShuttingDown, is dropped instead of run by a later worker
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// buffered so the background never blocks on an abandoned call
+	r := make(chan interface{}, 1)
 	c := Call{
+		Context:   ctx,
 		Method:    method,
 		Arguments: arguments,
 		Response:  r,
+		Priority:  priorityFrom(ctx),
+	}
+
+	releaseInFlight, err := acquireInFlight(ctx)
+	if nil != err {
+		return jsonNull, jsonNull, err
+	}
+	defer releaseInFlight()
+
+	release, err := acquireMethod(ctx, method)
+	if nil != err {
+		return jsonNull, jsonNull, err
 	}
+	defer release()
 
-	tries := totalTries
+	tries := int(atomic.LoadInt32(&callTries))
 	for {
-		tries -= 1
+		// a doomed attempt would only hold a worker
+		if nil != ctx.Err() {
+			return jsonNull, jsonNull, ctx.Err()
+		}
+		if !enoughTime(ctx, method) {
+			return jsonNull, jsonNull, ErrDeadlineExceeded
+		}
+
+		c.Tries += 1
+		c.Enqueued = time.Now()
 
 		// send request
-		sharedQueue <- c
+		select {
+		case queue <- c:
+		case <-stopped:
+			return jsonNull, jsonNull, ErrShuttingDown
+		case <-ctx.Done():
+			return jsonNull, jsonNull, ctx.Err()
+		}
 
 		// receive response
-		result := <-r
+		var result interface{}
+		select {
+		case result = <-r:
+		case <-stopped:
+			// a worker may have answered just before leaving
+			select {
+			case result = <-r:
+			default:
+				return jsonNull, jsonNull, ErrShuttingDown
+			}
+		case <-ctx.Done():
+			return jsonNull, jsonNull, ctx.Err()
+		}
 
 		//decode the result
 		switch result.(type) {
 		case error:
-			if tries <= 1 {
+			if !retryable(result.(error)) {
+				return jsonNull, jsonNull, result.(error)
+			}
+			if c.Tries >= tries {
+				reportDeadLetter(&c, result.(error))
 				return jsonNull, jsonNull, result.(error)
 			}
 		case RawResult:
@@ -188,151 +781,650 @@ func RemoteCall(method string, arguments []json.RawMessage) (json.RawMessage, js
 	}
 }
 
+// decode the result of a RemoteCall into a typed reply
+// a non-null RPC error is returned as an *RPCError
+func decodeResult(result json.RawMessage, rpcErr json.RawMessage, reply interface{}) error {
+	if !isNull(rpcErr) {
+		return rpcErrorFrom(rpcErr)
+	}
+	return json.Unmarshal(result, reply)
+}
+
+// check for a missing or null JSON value
+func isNull(raw json.RawMessage) bool {
+	return 0 == len(raw) || bytes.Equal(raw, jsonNull)
+}
+
+// the arguments without any trailing nulls
+func trimNulls(arguments []json.RawMessage) []json.RawMessage {
+	for 0 != len(arguments) && isNull(bytes.TrimSpace(arguments[len(arguments)-1])) {
+		arguments = arguments[:len(arguments)-1]
+	}
+	return arguments
+}
+
 // background process
-func (conn *RemoteConnection) background(queue <-chan Call) {
+//
+// the queues have already been joined, a nil queue is not served
+func (conn *RemoteConnection) background(readQueue *priorityQueue, writeQueue *priorityQueue) {
+
+	var reads, writes <-chan Call
+	if nil != readQueue {
+		reads = readQueue.out
+	}
+	if nil != writeQueue {
+		writes = writeQueue.out
+	}
+
+	var probe <-chan time.Time
+	if conn.probeInterval > 0 {
+		ticker := time.NewTicker(conn.probeInterval)
+		defer ticker.Stop()
+		probe = ticker.C
+	}
 
 loop:
 	for {
+		// stop taking calls while probing shows the backend is down
+		// so that other connections serve the shared queues
+		r, w := reads, writes
+		inRotation := conn.InRotation()
+		if !inRotation {
+			r, w = nil, nil
+		}
+
 		select {
 		case <-conn.shutdown:
+			if nil != conn.drain {
+				// calls in the shared queues are left to the other
+				// connections, only the last one serving drains them
+				if nil != reads && readQueue.leaveUnlessLast() {
+					reads = nil
+				}
+				if nil != writes && writeQueue.leaveUnlessLast() {
+					writes = nil
+				}
+				conn.drainQueues(reads, writes)
+			}
 			break loop
-		case call := <-queue:
-
-			var reply json.RawMessage
-			var rpcerr json.RawMessage
-
-			//log.Printf("dequeued call: %v\n", call)
-			err := conn.processCall(call.Method, call.Arguments, &reply, &rpcerr)
-
-			//log.Printf("pc: reply: %v\n", reply)
-			//log.Printf("pc: reply: %s\n", reply)
-			//log.Printf("pc: rpcerr: %v\n", rpcerr)
-			//log.Printf("pc: rpcerr: %s\n", rpcerr)
-
-			if nil != rpcerr {
-				call.Response <- RawError(rpcerr)
-			} else if nil != err {
-				call.Response <- err
+		case <-probe:
+			if !inRotation {
+				conn.reconnect()
 			} else {
-				call.Response <- RawResult(reply)
+				conn.probe()
 			}
-
+		case call := <-r:
+			conn.serve(call)
+		case call := <-w:
+			conn.serve(call)
+		case call := <-conn.queue:
+			conn.serve(call)
 		}
 	}
-	close(conn.finished)
-}
-
-// check if a parameter element is a valid hash string, if so extract it
-func getHex(argument json.RawMessage, size int) (string, error) {
 
-	var hexData string
-	err := json.Unmarshal(argument, &hexData)
-	if nil != err {
-		return "", ErrInvalidArgumentType
+	if nil != reads {
+		readQueue.leave()
 	}
-	bytes, err := hex.DecodeString(hexData)
-	if nil != err {
-		return "", err
+	if nil != writes {
+		writeQueue.leave()
 	}
-	if size > 0 && len(bytes) != size {
-		return "", ErrHexLengthIncorrect
+	conn.leaveNodeControl()
+	conn.leaveLimits()
+	close(conn.finished)
+}
+
+// serve calls that are waiting until none arrive for drainIdleTime
+// or the drain context is done
+func (conn *RemoteConnection) drainQueues(reads <-chan Call, writes <-chan Call) {
+	for {
+		select {
+		case <-conn.drain.Done():
+			return
+		case call := <-reads:
+			conn.serve(call)
+		case call := <-writes:
+			conn.serve(call)
+		case call := <-conn.queue:
+			conn.serve(call)
+		case <-time.After(drainIdleTime):
+			return
+		}
 	}
-	return hexData, nil
 }
 
-// check if a parameter is a number, if so extract it
-func getNumber(argument json.RawMessage) (uint64, error) {
-	var number uint64
-	err := json.Unmarshal(argument, &number)
-	if nil != err {
-		return 0, ErrInvalidArgumentType
+// join the shared queues of the chain matching the role, nil for
+// those not served
+func (conn *RemoteConnection) joinQueues() (*priorityQueue, *priorityQueue) {
+	queues := queuesFor(conn.chain)
+	var reads, writes *priorityQueue
+	if RoleWrite != conn.role {
+		reads = queues.reads
+		reads.join()
 	}
-	return number, nil
+	if RoleRead != conn.role {
+		writes = queues.writes
+		writes.join()
+	}
+	return reads, writes
+}
+
+// process a call and send back its response
+func (conn *RemoteConnection) serve(call Call) {
+
+	var reply json.RawMessage
+	var rpcerr json.RawMessage
+
+	//log.Printf("dequeued call: %v\n", call)
+	err := conn.runCall(&call, &reply, &rpcerr)
+
+	//log.Printf("pc: reply: %v\n", reply)
+	//log.Printf("pc: reply: %s\n", reply)
+	//log.Printf("pc: rpcerr: %v\n", rpcerr)
+	//log.Printf("pc: rpcerr: %s\n", rpcerr)
+
+	// an error such as a deprecated method takes precedence over
+	// the raw RPC error it was made from
+	if nil != err {
+		call.Response <- err
+	} else if nil != rpcerr {
+		call.Response <- RawError(rpcerr)
+	} else if isNull(reply) {
+		call.Response <- RawResult(jsonNull) // e.g. a notification
+	} else {
+		call.Response <- RawResult(reply)
+	}
+}
+
+// process a dequeued call, cancelled by either the caller or Destroy
+func (conn *RemoteConnection) runCall(call *Call, reply *json.RawMessage, rpcErr *json.RawMessage) error {
+
+	if !call.Enqueued.IsZero() {
+		reportQueueWait(call.Method, time.Since(call.Enqueued))
+	}
+
+	// the caller gave up while the call was queued
+	if abandonedCall(*call) {
+		return call.Context.Err()
+	}
+
+	if conn.shed(call) {
+		reportShed(call.Method, CallMetadataFrom(call.Context))
+		return ErrCallShed
+	}
+
+	ctx := call.Context
+	if nil == ctx {
+		ctx = context.Background()
+	}
+	var cancel context.CancelFunc
+	if timeout := conn.callTimeout(ctx); timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+	stop := context.AfterFunc(conn.ctx, cancel)
+	defer stop()
+
+	conn.Lock()
+	conn.inFlight = call
+	conn.Unlock()
+
+	start := time.Now()
+	err := conn.processCall(ctx, call.Method, call.Arguments, reply, rpcErr)
+	conn.recordLatency(time.Since(start))
+	conn.logCall(call, *reply, *rpcErr, err)
+
+	conn.Lock()
+	conn.inFlight = nil
+	if nil != conn.ctx.Err() {
+		conn.abandoned = append(conn.abandoned, call.Method)
+	}
+	conn.Unlock()
+
+	return err
+}
+
+// a call had too few or too many arguments for its method,
+// matches ErrTooFewArguments or ErrTooManyArguments
+type ArgumentCountError struct {
+	Method string
+	Got    int
+	Min    int
+	Max    int
+}
+
+func (e *ArgumentCountError) Error() string {
+	expected := strconv.Itoa(e.Min)
+	if e.Min != e.Max {
+		expected = fmt.Sprintf("%d to %d", e.Min, e.Max)
+	}
+	return fmt.Sprintf("%s: method %q takes %s arguments, got %d", e.Unwrap(), e.Method, expected, e.Got)
+}
+
+func (e *ArgumentCountError) Unwrap() error {
+	if e.Got < e.Min {
+		return ErrTooFewArguments
+	}
+	return ErrTooManyArguments
+}
+
+// check the number of arguments is within the range for a method
+func checkArgumentCount(method string, count int, minimum int, maximum int) error {
+	if count < minimum || count > maximum {
+		return &ArgumentCountError{
+			Method: method,
+			Got:    count,
+			Min:    minimum,
+			Max:    maximum,
+		}
+	}
+	return nil
+}
+
+// check if a parameter element is a valid hash string, if so extract it
+func getHex(argument json.RawMessage, size int) (string, error) {
+
+	var hexData string
+	err := json.Unmarshal(argument, &hexData)
+	if nil != err {
+		return "", ErrInvalidArgumentType
+	}
+	bytes, err := hex.DecodeString(hexData)
+	if nil != err {
+		return "", err
+	}
+	if size > 0 && len(bytes) != size {
+		return "", ErrHexLengthIncorrect
+	}
+	return hexData, nil
+}
+
+// check if a parameter is a string, if so extract it
+func getString(argument json.RawMessage) (string, error) {
+	var s string
+	err := json.Unmarshal(argument, &s)
+	if nil != err {
+		return "", ErrInvalidArgumentType
+	}
+	return s, nil
+}
+
+// check if a parameter is a number, if so extract it
+func getNumber(argument json.RawMessage) (uint64, error) {
+	var number uint64
+	err := json.Unmarshal(argument, &number)
+	if nil != err {
+		return 0, ErrInvalidArgumentType
+	}
+	return number, nil
+}
+
+// check if a parameter is a flag, either a bool or a 0/1 number, if so extract it
+func getFlag(argument json.RawMessage) (bool, error) {
+	var flag bool
+	if nil == json.Unmarshal(argument, &flag) {
+		return flag, nil
+	}
+	number, err := getNumber(argument)
+	if nil != err {
+		return false, err
+	}
+	if number > 1 {
+		return false, ErrInvalidBool
+	}
+	return 1 == number, nil
+}
+
+// check if a parameter is a getblock verbosity, either a number or
+// a bool for the older form, if so extract it as a number
+func getVerbosity(argument json.RawMessage) (uint64, error) {
+	var flag bool
+	if nil == json.Unmarshal(argument, &flag) {
+		if flag {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return getNumber(argument)
+}
+
+// the wire form of the getrawtransaction verbose flag:
+// older bitcoind only accept a number, newer accept a bool
+func (conn *RemoteConnection) verboseFlag(verbose bool) interface{} {
+	if conn.version >= bitcoinBoolVerboseVersion {
+		return verbose
+	}
+	if verbose {
+		return 1
+	}
+	return 0
 }
 
 // process only allowable RPCs
-func (conn *RemoteConnection) processCall(method string, arguments []json.RawMessage, reply *json.RawMessage, rpcErr *json.RawMessage) error {
+func (conn *RemoteConnection) processCall(ctx context.Context, method string, arguments []json.RawMessage, reply *json.RawMessage, rpcErr *json.RawMessage) error {
+
+	// arguments from RemoteCall callers need not have come from a decoder
+	for i, argument := range arguments {
+		if !json.Valid(argument) {
+			return fmt.Errorf("argument %d is not valid JSON: %w", i, ErrInvalidArgumentType)
+		}
+	}
+
+	// trailing nulls stand for omitted optional arguments
+	if !conn.strictArguments {
+		arguments = trimNulls(arguments)
+	}
+
+	err := conn.permitMethod(method)
+	if nil != err {
+		return err
+	}
+
+	err = conn.ensureBootstrap(ctx)
+	if nil != err {
+		return err
+	}
+
+	// newer methods are rejected before reaching an older daemon
+	err = conn.requireVersion(method)
+	if nil != err {
+		return err
+	}
 
 	count := len(arguments)
 
 	switch method {
 
 	case "getinfo":
-		if 0 != count {
-			return ErrTooManyArguments
+		err = checkArgumentCount(method, count, 0, 0)
+		if nil != err {
+			return err
+		}
+		if conn.synthesizeGetInfo {
+			*reply, err = conn.synthesizeInfo(ctx)
+			return err
 		}
-		return conn.remoteCall("getinfo", []interface{}{}, reply, rpcErr)
+		return conn.remoteCall(ctx, "getinfo", []interface{}{}, reply, rpcErr)
 
 	case "getblockchaininfo":
-		if 0 != count {
-			return ErrTooManyArguments
+		err = checkArgumentCount(method, count, 0, 0)
+		if nil != err {
+			return err
 		}
-		return conn.remoteCall("getblockchaininfo", []interface{}{}, reply, rpcErr)
+		return conn.remoteCall(ctx, "getblockchaininfo", []interface{}{}, reply, rpcErr)
 
 	case "getblockcount":
-		if 0 != count {
-			return ErrTooManyArguments
+		err = checkArgumentCount(method, count, 0, 0)
+		if nil != err {
+			return err
+		}
+		err = conn.remoteCall(ctx, "getblockcount", []interface{}{}, reply, rpcErr)
+		if nil != err {
+			return err
+		}
+		return conn.clampBlockCount(reply)
+
+	case "getbestblockhash":
+		err = checkArgumentCount(method, count, 0, 0)
+		if nil != err {
+			return err
+		}
+		return conn.bestBlockHash(ctx, reply, rpcErr)
+
+	case "getpeerinfo":
+		err = checkArgumentCount(method, count, 0, 0)
+		if nil != err {
+			return err
+		}
+		return conn.remoteCall(ctx, "getpeerinfo", []interface{}{}, reply, rpcErr)
+
+	case "getnettotals":
+		err = checkArgumentCount(method, count, 0, 0)
+		if nil != err {
+			return err
+		}
+		return conn.remoteCall(ctx, "getnettotals", []interface{}{}, reply, rpcErr)
+
+	case "getaddednodeinfo":
+		err = conn.requireNodeControl()
+		if nil != err {
+			return err
+		}
+		err = checkArgumentCount(method, count, 0, 1)
+		if nil != err {
+			return err
+		}
+
+		node := "" // optional
+		if count >= 1 {
+			node, err = getNodeAddress(arguments[0])
+			if nil != err {
+				return err
+			}
+		}
+
+		return conn.remoteCall(ctx, "getaddednodeinfo", conn.addedNodeArguments(node), reply, rpcErr)
+
+	case "addnode":
+		err = conn.requireNodeControl()
+		if nil != err {
+			return err
+		}
+		err = checkArgumentCount(method, count, 2, 2)
+		if nil != err {
+			return err
+		}
+
+		node, err := getNodeAddress(arguments[0])
+		if nil != err {
+			return err
+		}
+		command, err := getNodeCommand(arguments[1])
+		if nil != err {
+			return err
 		}
-		return conn.remoteCall("getblockcount", []interface{}{}, reply, rpcErr)
+
+		return conn.remoteCall(ctx, "addnode", []interface{}{node, command}, reply, rpcErr)
 
 	case "getblockhash":
-		if count < 1 {
-			return ErrTooFewArguments
-		} else if count > 1 {
-			return ErrTooManyArguments
+		err = checkArgumentCount(method, count, 1, 1)
+		if nil != err {
+			return err
 		}
 
 		number, err := getNumber(arguments[0])
 		if nil != err {
 			return err
 		}
+		err = conn.checkFrozenHeight(number)
+		if nil != err {
+			return err
+		}
 
-		return conn.remoteCall("getblockhash", []interface{}{number}, reply, rpcErr)
+		return conn.remoteCall(ctx, "getblockhash", []interface{}{number}, reply, rpcErr)
 
 	case "getblock":
-		if count < 1 {
-			return ErrTooFewArguments
-		} else if count > 1 {
-			return ErrTooManyArguments
+		err = checkArgumentCount(method, count, 1, 2)
+		if nil != err {
+			return err
+		}
+
+		hash, err := getHex(arguments[0], 32)
+		if nil != err {
+			return err
+		}
+		verbosity := uint64(1) // optional
+		if count >= 2 {
+			verbosity, err = getVerbosity(arguments[1])
+			if nil != err {
+				return err
+			}
+		}
+		err = conn.checkBlockVerbosity(verbosity)
+		if nil != err {
+			return err
+		}
+		err = conn.checkFrozenBlock(ctx, hash)
+		if nil != err {
+			return err
+		}
+
+		params := []interface{}{hash}
+		if count >= 2 {
+			params = append(params, conn.verbosityFlag(verbosity))
+		}
+		err = conn.remoteCall(ctx, "getblock", params, reply, rpcErr)
+		if nil != err || 0 == verbosity {
+			return err
+		}
+		return conn.verifyHash(*reply, "hash", hash)
+
+	case "getblockheader":
+		err = checkArgumentCount(method, count, 1, 2)
+		if nil != err {
+			return err
 		}
 
 		hash, err := getHex(arguments[0], 32)
 		if nil != err {
 			return err
 		}
+		verbose := true // optional
+		if count >= 2 {
+			verbose, err = getFlag(arguments[1])
+			if nil != err {
+				return err
+			}
+		}
+		err = conn.checkFrozenBlock(ctx, hash)
+		if nil != err {
+			return err
+		}
 
-		return conn.remoteCall("getblock", []interface{}{hash}, reply, rpcErr)
+		err = conn.remoteCall(ctx, "getblockheader", []interface{}{hash, verbose}, reply, rpcErr)
+		if nil != err || !verbose {
+			return err
+		}
+		return conn.verifyHash(*reply, "hash", hash)
 
 	case "getrawtransaction":
 
-		if count < 1 {
-			return ErrTooFewArguments
-		} else if count > 2 {
-			return ErrTooManyArguments
+		err = checkArgumentCount(method, count, 1, 3)
+		if nil != err {
+			return err
 		}
 
 		hash, err := getHex(arguments[0], 32)
 		if nil != err {
 			return err
 		}
-		number := uint64(0) // optional
+		verbose := false // optional
 		if count >= 2 {
-			number, err = getNumber(arguments[1])
+			verbose, err = getFlag(arguments[1])
+			if nil != err {
+				return err
+			}
+		}
+		blockHash := "" // optional
+		if count >= 3 {
+			blockHash, err = getHex(arguments[2], 32)
+			if nil != err {
+				return err
+			}
+		}
+
+		// without a block hash the lookup needs the transaction index,
+		// unless it can be retried with one for a transaction not in the mempool
+		if "" == blockHash && !conn.blockHashRetry {
+			err = conn.requireIndex(method, txIndex)
+			if nil != err {
+				return err
+			}
+		}
+		err = conn.checkFrozenTransaction(ctx, hash, blockHash)
+		var frozenErr *RPCError
+		if errors.As(err, &frozenErr) {
+			*rpcErr, err = json.Marshal(frozenErr)
+			return err
+		} else if nil != err {
+			return err
+		}
+
+		params := []interface{}{hash, conn.verboseFlag(verbose)}
+		if "" != blockHash {
+			params = append(params, blockHash)
+		}
+		err = conn.remoteCall(ctx, "getrawtransaction", params, reply, rpcErr)
+		if nil == err && "" == blockHash && conn.blockHashRetry && needsTxIndex(*rpcErr) {
+			if blockHash, ok := conn.blockHashFor(ctx, hash); ok {
+				*reply, *rpcErr = nil, nil // a null error would not overwrite them
+				params = append(params, blockHash)
+				err = conn.remoteCall(ctx, "getrawtransaction", params, reply, rpcErr)
+			}
+		}
+		if nil != err || !verbose {
+			return err
+		}
+		return conn.verifyHash(*reply, "txid", hash)
+
+	case "gettxout":
+		err = checkArgumentCount(method, count, 2, 3)
+		if nil != err {
+			return err
+		}
+
+		hash, err := getHex(arguments[0], 32)
+		if nil != err {
+			return err
+		}
+		n, err := getNumber(arguments[1])
+		if nil != err {
+			return err
+		}
+		includeMempool := true // optional
+		if count >= 3 {
+			includeMempool, err = getFlag(arguments[2])
 			if nil != err {
 				return err
 			}
-			if number < 0 || number > 1 {
-				return ErrInvalidBool
+		}
+
+		return conn.remoteCall(ctx, "gettxout", []interface{}{hash, n, includeMempool}, reply, rpcErr)
+
+	case "getrawmempool":
+		err = checkArgumentCount(method, count, 0, 1)
+		if nil != err {
+			return err
+		}
+
+		verbose := false // optional
+		if count >= 1 {
+			verbose, err = getFlag(arguments[0])
+			if nil != err {
+				return err
 			}
 		}
 
-		return conn.remoteCall("getrawtransaction", []interface{}{hash, number}, reply, rpcErr)
+		return conn.remoteCall(ctx, "getrawmempool", []interface{}{verbose}, reply, rpcErr)
+
+	case "getmempoolentry":
+		err = checkArgumentCount(method, count, 1, 1)
+		if nil != err {
+			return err
+		}
+
+		hash, err := getHex(arguments[0], 32)
+		if nil != err {
+			return err
+		}
+
+		return conn.remoteCall(ctx, "getmempoolentry", []interface{}{hash}, reply, rpcErr)
 
 	case "decoderawtransaction":
-		if count < 1 {
-			return ErrTooFewArguments
-		} else if count > 1 {
-			return ErrTooManyArguments
+		err = checkArgumentCount(method, count, 1, 1)
+		if nil != err {
+			return err
 		}
 
 		hexData, err := getHex(arguments[0], 0)
@@ -340,13 +1432,12 @@ func (conn *RemoteConnection) processCall(method string, arguments []json.RawMes
 			return err
 		}
 
-		return conn.remoteCall("decoderawtransaction", []interface{}{hexData}, reply, rpcErr)
+		return conn.remoteCall(ctx, "decoderawtransaction", []interface{}{hexData}, reply, rpcErr)
 
 	case "sendrawtransaction":
-		if count < 1 {
-			return ErrTooFewArguments
-		} else if count > 1 {
-			return ErrTooManyArguments
+		err = checkArgumentCount(method, count, 1, 1)
+		if nil != err {
+			return err
 		}
 
 		hexData, err := getHex(arguments[0], 0)
@@ -354,7 +1445,43 @@ func (conn *RemoteConnection) processCall(method string, arguments []json.RawMes
 			return err
 		}
 
-		return conn.remoteCall("sendrawtransaction", []interface{}{hexData}, reply, rpcErr)
+		return conn.remoteCall(ctx, "sendrawtransaction", []interface{}{hexData}, reply, rpcErr)
+
+	case "getblockfilter":
+		err = checkArgumentCount(method, count, 1, 2)
+		if nil != err {
+			return err
+		}
+		err = conn.requireIndex(method, blockFilterIndex)
+		if nil != err {
+			return err
+		}
+
+		hash, err := getHex(arguments[0], 32)
+		if nil != err {
+			return err
+		}
+
+		filterType := "basic"
+		if count >= 2 {
+			filterType, err = getString(arguments[1])
+			if nil != err {
+				return err
+			}
+			if "basic" != filterType {
+				return ErrInvalidArgumentType
+			}
+		}
+
+		return conn.remoteCall(ctx, "getblockfilter", []interface{}{hash, filterType}, reply, rpcErr)
+
+	case "scanblocks":
+		params, err := scanBlocksParams(arguments)
+		if nil != err {
+			return err
+		}
+
+		return conn.remoteCall(ctx, "scanblocks", params, reply, rpcErr)
 
 	default:
 		return ErrInvalidMethod
@@ -364,15 +1491,17 @@ func (conn *RemoteConnection) processCall(method string, arguments []json.RawMes
 // low level RPC
 // -------------
 
-// high level call - only use while global data locked
-// because the HTTP RPC cannot interleave calls and responses
-func (conn *RemoteConnection) remoteCall(method string, params []interface{}, reply interface{}, rpcerr interface{}) error {
+// high level call - safe to use concurrently as each HTTP request
+// carries its own id and the client does not interleave responses
+func (conn *RemoteConnection) remoteCall(ctx context.Context, method string, params []interface{}, reply interface{}, rpcerr interface{}) error {
 
-	conn.id += 1
+	id := conn.nextIDs(1)
+	upstream := conn.upstreamMethod(method)
+	recordForwarded(ctx, upstream, params)
 
 	arguments := bitcoinArguments{
-		ID:         conn.id,
-		Method:     method,
+		ID:         id,
+		Method:     upstream,
 		Parameters: params,
 	}
 	response := bitcoinReply{
@@ -380,15 +1509,121 @@ func (conn *RemoteConnection) remoteCall(method string, params []interface{}, re
 		Error:  rpcerr,
 	}
 	//log.Printf("arguments: %v\n", arguments)
-	err := conn.bitcoinRPC(&arguments, &response)
+	var err error
+	if conn.notifications[method] {
+		err = conn.notifyRPC(ctx, &arguments)
+	} else {
+		start := time.Now()
+		err = conn.bitcoinRPC(ctx, &arguments, &response)
+		duration := time.Since(start)
+		reportBackend(method, duration)
+		recordUpstream(ctx, duration)
+	}
 	//log.Printf("response: %v\n", response)
 	//log.Printf("reply: %v\n", reply)
+	// a cancelled call says nothing about the backend, neither does
+	// a method it no longer supports or a request that was not sent
+	var deprecated *MethodDeprecatedError
+	if nil == ctx.Err() && !errors.As(err, &deprecated) && !errors.Is(err, ErrRequestTooLarge) && !errors.Is(err, ErrResponseTooLarge) {
+		conn.recordOutcome(err)
+	}
+	if nil != err {
+		return err
+	}
+
+	// only successful results are validated
+	if !rpcErrorSet(rpcerr) {
+		return conn.validate(method, reply)
+	}
+	return nil
+}
+
+// check the RPC error decoded from a reply, a missing or null error
+// leaves the caller's value unset
+func rpcErrorSet(rpcerr interface{}) bool {
+	switch e := rpcerr.(type) {
+	case *json.RawMessage:
+		return !isNull(*e)
+	case **RPCError:
+		return nil != *e
+	case *interface{}:
+		return nil != *e
+	default:
+		return false
+	}
+}
+
+// direct call on this connection bypassing the queues, after any
+// deferred bootstrap checks
+// a non-null RPC error is returned as an *RPCError
+func (conn *RemoteConnection) call(ctx context.Context, method string, params []interface{}, reply interface{}) error {
+	err := conn.ensureBootstrap(ctx)
 	if nil != err {
 		return err
 	}
+	return conn.directCall(ctx, method, params, reply)
+}
+
+// call without the bootstrap checks, for the bootstrap itself
+func (conn *RemoteConnection) directCall(ctx context.Context, method string, params []interface{}, reply interface{}) error {
+	var rpcErr *RPCError
+	err := conn.remoteCall(ctx, method, params, reply, &rpcErr)
+	if nil != err {
+		return err
+	}
+	if nil != rpcErr {
+		return rpcErr
+	}
 	return nil
 }
 
+// encode a request body and POST it to the backend
+func (conn *RemoteConnection) post(ctx context.Context, payload interface{}) (*http.Response, error) {
+
+	buffer := getBuffer()
+	err := json.NewEncoder(buffer).Encode(payload)
+	if nil != err {
+		putBuffer(buffer)
+		return nil, err
+	}
+
+	// refuse rather than have the daemon drop the connection part way
+	if conn.maxRequestSize > 0 && buffer.Len() > conn.maxRequestSize {
+		size := buffer.Len()
+		putBuffer(buffer)
+		return nil, fmt.Errorf("%w: %d bytes exceeds the limit of %d", ErrRequestTooLarge, size, conn.maxRequestSize)
+	}
+
+	compress := conn.compressRequests && buffer.Len() >= compressMinimumSize
+	if compress {
+		compressed := getBuffer()
+		err = gzipData(compressed, buffer.Bytes())
+		putBuffer(buffer)
+		buffer = compressed
+		if nil != err {
+			putBuffer(buffer)
+			return nil, err
+		}
+	}
+
+	// the buffer goes back to the pool when the transport closes the body
+	postData := newPooledBody(buffer)
+
+	url, username, password := conn.upstream()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, postData)
+	if nil != err {
+		postData.Close()
+		return nil, err
+	}
+	request.ContentLength = int64(buffer.Len())
+	request.SetBasicAuth(username, password)
+	if compress {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+
+	return conn.doRecordingTLS(request)
+}
+
 // for encoding the RPC arguments
 type bitcoinArguments struct {
 	ID         uint64        `json:"id"`
@@ -398,48 +1633,166 @@ type bitcoinArguments struct {
 
 // for decoding the RPC reply
 type bitcoinReply struct {
-	Id     int64       `json:"id"`
-	Method string      `json:"method"`
-	Result interface{} `json:"result"`
-	Error  interface{} `json:"error"`
+	Id     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Result interface{}     `json:"result"`
+	Error  interface{}     `json:"error"`
 }
 
-// basic RPC - only use while global data locked
-func (conn *RemoteConnection) bitcoinRPC(arguments *bitcoinArguments, reply *bitcoinReply) error {
+// check the reply id against the request id
+// accepts either a number or a string containing the number
+// a null or missing id is accepted as bitcoind uses it for parse errors
+func (reply *bitcoinReply) matchesID(id uint64) bool {
+	if isNull(reply.Id) {
+		return true
+	}
+	replyID, ok := parseID(reply.Id)
+	return ok && replyID == id
+}
 
-	s, err := json.Marshal(arguments)
-	if nil != err {
-		return err
+// extract a numeric id sent either as a number or as a string
+func parseID(raw json.RawMessage) (uint64, bool) {
+	var s string
+	if nil != json.Unmarshal(raw, &s) {
+		var n json.Number
+		if nil != json.Unmarshal(raw, &n) {
+			return 0, false
+		}
+		s = n.String()
 	}
+	id, err := strconv.ParseUint(s, 10, 64)
+	return id, nil == err
+}
 
-	postData := bytes.NewBuffer(s)
+// the body of a reply, failing with ErrResponseTooLarge as soon as
+// more than the size limit has been read
+func (conn *RemoteConnection) limitBody(response *http.Response) io.Reader {
+	if conn.maxResponseSize <= 0 {
+		return response.Body
+	}
+	return &limitedBody{
+		reader: io.LimitReader(response.Body, conn.maxResponseSize+1),
+		limit:  conn.maxResponseSize,
+		size:   response.ContentLength, // -1 when chunked
+	}
+}
 
-	request, err := http.NewRequest(http.MethodPost, conn.url, postData)
-	if nil != err {
-		return err
+// a reply body reader that counts the bytes read
+type limitedBody struct {
+	reader io.Reader
+	limit  int64
+	size   int64
+	read   int64
+}
+
+func (body *limitedBody) Read(p []byte) (int, error) {
+	if body.size > body.limit || body.read > body.limit {
+		return 0, fmt.Errorf("%w: over the limit of %d bytes", ErrResponseTooLarge, body.limit)
+	}
+	n, err := body.reader.Read(p)
+	body.read += int64(n)
+	if body.read > body.limit {
+		return 0, fmt.Errorf("%w: over the limit of %d bytes", ErrResponseTooLarge, body.limit)
 	}
-	request.SetBasicAuth(conn.username, conn.password)
+	return n, err
+}
+
+// basic RPC
+func (conn *RemoteConnection) bitcoinRPC(ctx context.Context, arguments *bitcoinArguments, reply *bitcoinReply) error {
 
-	response, err := conn.client.Do(request)
+	response, err := conn.post(ctx, arguments)
 	if nil != err {
 		return err
 	}
 	defer response.Body.Close()
 
-	body, err := ioutil.ReadAll(response.Body)
+	body, err := ioutil.ReadAll(conn.limitBody(response))
 	if nil != err {
 		return err
 	}
 
-	if http.StatusOK == response.StatusCode {
+	// bitcoind sends RPC errors with a 500 or 404 status
+	rpcError := parseRPCError(body)
+	if http.StatusOK == response.StatusCode || nil != rpcError {
 		err = json.Unmarshal(body, &reply)
 		if nil != err {
 			return err
 		}
+		if !reply.matchesID(arguments.ID) {
+			return ErrMismatchedID
+		}
+		if nil != rpcError && rpcError.deprecated() {
+			return &MethodDeprecatedError{
+				Method:  arguments.Method,
+				Message: rpcError.Message,
+			}
+		}
 		return nil
 	}
 	if http.StatusUnauthorized == response.StatusCode {
 		return ErrAccessDenied
 	}
-	return fmt.Errorf("http failed: %q", response.Status)
+	return &HTTPError{
+		StatusCode: response.StatusCode,
+		Status:     response.Status,
+	}
+}
+
+// an unexpected HTTP status from the backend
+type HTTPError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("http failed: %q", e.Status)
+}
+
+// status class: 4 for client errors, 5 for server errors
+func (e *HTTPError) Class() int {
+	return e.StatusCode / 100
+}
+
+// only gateway failures are worth retrying, typically a reverse
+// proxy while bitcoind restarts, other statuses will not change
+func (e *HTTPError) Retryable() bool {
+	switch e.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// errors from checking the arguments of a call before it is sent,
+// the call would fail the same way on any connection
+//
+// not the checks of a connection's own settings, version or indexes
+// that another connection serving the queue may pass
+var validationErrors = []error{
+	ErrInvalidMethod,
+	ErrTooFewArguments,
+	ErrTooManyArguments,
+	ErrInvalidArgumentType,
+	ErrHexLengthIncorrect,
+	ErrInvalidBool,
+	ErrInvalidNodeAddress,
+	ErrInvalidNodeCommand,
+}
+
+// check if a failed call should be tried again
+func retryable(err error) bool {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Retryable()
+	}
+	if errors.Is(err, ErrMethodDeprecated) || errors.Is(err, ErrCallShed) || errors.Is(err, ErrRequestTooLarge) || errors.Is(err, ErrResponseTooLarge) || errors.Is(err, ErrMethodDenied) || errors.Is(err, ErrNodeControlDisabled) {
+		return false
+	}
+	for _, validation := range validationErrors {
+		if errors.Is(err, validation) {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,431 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReplyMatchesID(t *testing.T) {
+	for _, item := range []struct {
+		id      string
+		matches bool
+	}{
+		{`17`, true},
+		{`"17"`, true},
+		{`null`, true},
+		{``, true},
+		{`18`, false},
+		{`"18"`, false},
+		{`"abc"`, false},
+		{`17.5`, false},
+		{`{}`, false},
+	} {
+		reply := bitcoinReply{Id: json.RawMessage(item.id)}
+		if item.matches != reply.matchesID(17) {
+			t.Errorf("id %s matches: %v", item.id, !item.matches)
+		}
+	}
+}
+
+func TestStringReplyID(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.replyID = func(method string, id json.RawMessage) json.RawMessage {
+		return json.RawMessage(strconv.Quote(string(id)))
+	}
+	backend.connect(t)
+
+	_, _, err := RemoteCall("getblockhash", rawArguments(`8`))
+	if nil != err {
+		t.Errorf("error: %v", err)
+	}
+}
+
+func TestMismatchedReplyID(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.replyID = func(method string, id json.RawMessage) json.RawMessage {
+		if "getblockhash" == method {
+			return json.RawMessage(`"other"`)
+		}
+		return id
+	}
+	conn := backend.connect(t)
+
+	var hash string
+	err := conn.call(context.Background(), "getblockhash", []interface{}{8}, &hash)
+	if !errors.Is(err, ErrMismatchedID) {
+		t.Errorf("error: %v  expected: %v", err, ErrMismatchedID)
+	}
+}
+
+func TestGetNetTotals(t *testing.T) {
+	totals := `{"totalbytesrecv": 7, "totalbytessent": 9, "timemillis": 1600000000000}`
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getnettotals" == method {
+			return json.RawMessage(totals), nil
+		}
+		return nil, nil
+	})
+	backend.connect(t)
+
+	result, _, err := RemoteCall("getnettotals", nil)
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if `{"totalbytesrecv":7,"totalbytessent":9,"timemillis":1600000000000}` != string(result) {
+		t.Errorf("result: %s", result)
+	}
+
+	_, _, err = RemoteCall("getnettotals", rawArguments(`1`))
+	if !errors.Is(err, ErrTooManyArguments) {
+		t.Errorf("error: %v  expected: %v", err, ErrTooManyArguments)
+	}
+	if calls := backend.receivedFor("getnettotals"); 1 != len(calls) || "getnettotals[]" != calls[0] {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestFlagForms(t *testing.T) {
+	for _, item := range []struct {
+		version uint64
+		flag    string
+		wire    string // getrawtransaction verbose as sent
+		bool    string // getrawmempool and gettxout flags as sent
+	}{
+		{200000, `true`, `true`, `true`},
+		{200000, `1`, `true`, `true`},
+		{200000, `false`, `false`, `false`},
+		{200000, `0`, `false`, `false`},
+		{130000, `true`, `1`, `true`},
+		{130000, `1`, `1`, `true`},
+		{130000, `false`, `0`, `false`},
+		{130000, `0`, `0`, `false`},
+	} {
+		t.Run(fmt.Sprintf("%d/%s", item.version, item.flag), func(t *testing.T) {
+			backend := newFakeBitcoind(t, item.version, nil)
+			backend.connect(t)
+
+			RemoteCall("getrawtransaction", rawArguments(`"`+testTxID+`"`, item.flag))
+			RemoteCall("getrawmempool", rawArguments(item.flag))
+			RemoteCall("gettxout", rawArguments(`"`+testTxID+`"`, `0`, item.flag))
+
+			expected := []string{
+				`getrawtransaction["` + testTxID + `",` + item.wire + `]`,
+				`getrawmempool[` + item.bool + `]`,
+				`gettxout["` + testTxID + `",0,` + item.bool + `]`,
+			}
+			calls := backend.received()
+			if len(expected) != len(calls) {
+				t.Fatalf("calls: %v", calls)
+			}
+			for i := range expected {
+				if expected[i] != calls[i] {
+					t.Errorf("sent: %s  expected: %s", calls[i], expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFlagInvalid(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t)
+
+	for _, flag := range []string{`2`, `"true"`, `-1`} {
+		_, _, err := RemoteCall("getrawmempool", rawArguments(flag))
+		if nil == err {
+			t.Errorf("flag %s accepted", flag)
+		}
+	}
+	_, _, err := RemoteCall("getrawmempool", rawArguments(`2`))
+	if !errors.Is(err, ErrInvalidBool) {
+		t.Errorf("error: %v  expected: %v", err, ErrInvalidBool)
+	}
+	if calls := backend.received(); 0 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestMalformedArgument(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t)
+
+	_, _, err := RemoteCall("getrawtransaction", []json.RawMessage{json.RawMessage(`"` + testTxID + `"`), json.RawMessage(`{tru`)})
+	if !errors.Is(err, ErrInvalidArgumentType) {
+		t.Fatalf("error: %v  expected: %v", err, ErrInvalidArgumentType)
+	}
+	if !strings.Contains(err.Error(), "argument 1") {
+		t.Errorf("error does not name the argument: %v", err)
+	}
+	if calls := backend.received(); 0 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestRetryGatewayStatus(t *testing.T) {
+	failures := int64(2)
+	backend := failingBackend(t, http.StatusBadGateway, &failures)
+	backend.connect(t)
+	setTestTries(t, 3)
+
+	_, _, err := RemoteCall("getblockhash", rawArguments(`8`))
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if calls := backend.receivedFor("getblockhash"); 3 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestNoRetryClientStatus(t *testing.T) {
+	failures := int64(100)
+	backend := failingBackend(t, http.StatusBadRequest, &failures)
+	backend.connect(t)
+	setTestTries(t, 3)
+
+	_, _, err := RemoteCall("getblockhash", rawArguments(`8`))
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("error: %v", err)
+	}
+	if 4 != httpErr.Class() || http.StatusBadRequest != httpErr.StatusCode || httpErr.Retryable() {
+		t.Errorf("error: %+v  class: %d", httpErr, httpErr.Class())
+	}
+	if calls := backend.receivedFor("getblockhash"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestRetriesDisabled(t *testing.T) {
+	failures := int64(100)
+	backend := failingBackend(t, http.StatusServiceUnavailable, &failures)
+	backend.connect(t)
+
+	for _, tries := range []int{1, 0} {
+		setTestTries(t, tries)
+		_, _, err := RemoteCall("getblockhash", rawArguments(`8`))
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) || http.StatusServiceUnavailable != httpErr.StatusCode {
+			t.Errorf("tries %d error: %v", tries, err)
+		}
+	}
+	if calls := backend.receivedFor("getblockhash"); 2 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestBackendDo(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		switch method {
+		case "getblockhash":
+			return "00ff", nil
+		case "getblock":
+			return nil, &RPCError{Code: -5, Message: "Block not found"}
+		}
+		return nil, nil
+	})
+	var b Backend = backend.connect(t)
+
+	reply, rpcErr, err := b.Do(context.Background(), "getblockhash", rawArguments(`8`))
+	if nil != err || `"00ff"` != string(reply) || "null" != string(rpcErr) {
+		t.Errorf("reply: %s  rpc error: %s  error: %v", reply, rpcErr, err)
+	}
+
+	reply, rpcErr, err = b.Do(context.Background(), "getblock", rawArguments(`"`+genesisHash+`"`))
+	if nil != err || "null" != string(reply) || !strings.Contains(string(rpcErr), "Block not found") {
+		t.Errorf("reply: %s  rpc error: %s  error: %v", reply, rpcErr, err)
+	}
+
+	_, _, err = b.Do(context.Background(), "stop", nil)
+	if !errors.Is(err, ErrInvalidMethod) {
+		t.Errorf("error: %v  expected: %v", err, ErrInvalidMethod)
+	}
+	if calls := backend.receivedFor("stop"); 0 != len(calls) {
+		t.Errorf("invalid call forwarded: %v", calls)
+	}
+}
+
+func TestBackendBypassesQueue(t *testing.T) {
+	backend := newHeldBackend(t, "getpeerinfo")
+	conn := backend.connect(t, WithMaxInFlight(1, true))
+	t.Cleanup(func() {
+		setMaxInFlight(0, false)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		RemoteCall("getpeerinfo", nil)
+		close(done)
+	}()
+	eventually(t, "a call to start", func() bool {
+		running, _ := backend.counts()
+		return 1 == running
+	})
+
+	_, _, err := RemoteCall("getblockhash", rawArguments(`8`))
+	if !errors.Is(err, ErrTooManyInFlight) {
+		t.Errorf("queued error: %v  expected: %v", err, ErrTooManyInFlight)
+	}
+	_, _, err = conn.Do(context.Background(), "getblockhash", rawArguments(`8`))
+	if nil != err {
+		t.Errorf("direct error: %v", err)
+	}
+	if calls := backend.receivedFor("getblockhash"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+
+	backend.done()
+	<-done
+}
+
+func TestArgumentCountError(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t)
+
+	for _, item := range []struct {
+		method    string
+		arguments []json.RawMessage
+		sentinel  error
+		got       int
+		min       int
+		max       int
+		message   string
+	}{
+		{"getblockhash", nil, ErrTooFewArguments, 0, 1, 1, `method "getblockhash" takes 1 arguments, got 0`},
+		{"getblockhash", rawArguments(`1`, `2`), ErrTooManyArguments, 2, 1, 1, `method "getblockhash" takes 1 arguments, got 2`},
+		{"getpeerinfo", rawArguments(`1`), ErrTooManyArguments, 1, 0, 0, `method "getpeerinfo" takes 0 arguments, got 1`},
+		{"getrawtransaction", rawArguments(`"`+testTxID+`"`, `true`, `"`+genesisHash+`"`, `1`), ErrTooManyArguments, 4, 1, 3, `method "getrawtransaction" takes 1 to 3 arguments, got 4`},
+		{"gettxout", rawArguments(`"` + testTxID + `"`), ErrTooFewArguments, 1, 2, 3, `method "gettxout" takes 2 to 3 arguments, got 1`},
+	} {
+		_, _, err := RemoteCall(item.method, item.arguments)
+		if !errors.Is(err, item.sentinel) {
+			t.Errorf("%s error: %v  expected: %v", item.method, err, item.sentinel)
+			continue
+		}
+		var countErr *ArgumentCountError
+		if !errors.As(err, &countErr) {
+			t.Errorf("%s error: %v", item.method, err)
+			continue
+		}
+		if item.method != countErr.Method || item.got != countErr.Got || item.min != countErr.Min || item.max != countErr.Max {
+			t.Errorf("%s error: %+v", item.method, countErr)
+		}
+		if !strings.HasSuffix(err.Error(), item.message) {
+			t.Errorf("%s message: %s", item.method, err)
+		}
+	}
+
+	// the handler passes the details on to the client
+	w := postCall(pageHandler("test", "", "", false, false), `{"id":1,"method":"getblockhash","params":[]}`)
+	if !strings.Contains(w.Body.String(), `takes 1 arguments, got 0`) {
+		t.Errorf("handler reply: %s", w.Body.String())
+	}
+
+	if calls := backend.received(); 0 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestUpstreamMethodNames(t *testing.T) {
+	emptyCache(t)
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockhash" == method {
+			return nil, &RPCError{Code: -32601, Message: "Method not found"}
+		}
+		return nil, nil
+	})
+	conn := backend.connect(t, WithUpstreamMethodNames(map[string]string{"getblockhash": "chain_getblockhash"}))
+
+	// validated under the canonical name, sent under the new one
+	_, rpcErr, err := RemoteCall("getblockhash", rawArguments("7"))
+	if nil != err || "null" != string(rpcErr) {
+		t.Fatalf("error: %v  rpc error: %s", err, rpcErr)
+	}
+	if _, _, err := RemoteCall("getblockhash", rawArguments(`"seven"`)); !errors.Is(err, ErrInvalidArgumentType) {
+		t.Errorf("error: %v  expected: %v", err, ErrInvalidArgumentType)
+	}
+	if _, _, err := RemoteCall("chain_getblockhash", rawArguments("7")); !errors.Is(err, ErrInvalidMethod) {
+		t.Errorf("error: %v  expected: %v", err, ErrInvalidMethod)
+	}
+
+	// batches are renamed too
+	results := conn.Batch(context.Background(), []BatchCall{{Method: "getblockhash", Params: []interface{}{8}}})
+	if nil != results[0].Err || nil != results[0].Error {
+		t.Errorf("batch result: %+v", results[0])
+	}
+
+	if calls := backend.receivedFor("chain_getblockhash"); 2 != len(calls) || "chain_getblockhash[7]" != calls[0] || "chain_getblockhash[8]" != calls[1] {
+		t.Errorf("calls: %v", calls)
+	}
+	if calls := backend.receivedFor("getblockhash"); 0 != len(calls) {
+		t.Errorf("canonical name sent: %v", calls)
+	}
+}
+
+func TestIDBaseAndStep(t *testing.T) {
+	for _, item := range []struct {
+		name       string
+		options    []Option
+		base, step uint64
+	}{
+		{"default", nil, 0, 1},
+		{"configured", []Option{WithIDBase(1003), WithIDStep(10)}, 1003, 10},
+	} {
+		t.Run(item.name, func(t *testing.T) {
+			backend := newFakeBitcoind(t, 200000, nil)
+			var ids []uint64
+			backend.replyID = func(method string, id json.RawMessage) json.RawMessage {
+				if "getnettotals" == method || "getblockhash" == method {
+					n, err := strconv.ParseUint(string(id), 10, 64)
+					if nil != err {
+						t.Errorf("id: %s", id)
+					}
+					backend.Lock()
+					ids = append(ids, n)
+					backend.Unlock()
+				}
+				return id
+			}
+			conn := backend.connect(t, item.options...)
+
+			for i := 0; i < 3; i++ {
+				_, _, err := conn.Do(context.Background(), "getnettotals", nil)
+				if nil != err {
+					t.Fatalf("call error: %v", err)
+				}
+			}
+			calls := []BatchCall{}
+			for i := 0; i < 3; i++ {
+				calls = append(calls, BatchCall{Method: "getblockhash", Params: []interface{}{i}})
+			}
+			for _, result := range conn.Batch(context.Background(), calls) {
+				if nil != result.Err || nil != result.Error {
+					t.Fatalf("batch result: %+v", result)
+				}
+			}
+
+			backend.Lock()
+			defer backend.Unlock()
+			if 6 != len(ids) {
+				t.Fatalf("ids: %v", ids)
+			}
+			for i, id := range ids {
+				if id <= item.base || item.base%item.step != id%item.step {
+					t.Errorf("id %d outside the id space: %v", id, ids)
+				}
+				if 0 != i && ids[i-1]+item.step != id {
+					t.Errorf("ids not stepped by %d: %v", item.step, ids)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errors
+var (
+	ErrMethodDeprecated = errors.New("method deprecated")
+)
+
+// bitcoind RPC error codes
+const (
+	rpcInvalidAddressOrKey  = -5
+	rpcInvalidParameter     = -8
+	rpcVerifyError          = -25
+	rpcVerifyRejected       = -26
+	rpcVerifyAlreadyInChain = -27
+	rpcMethodDeprecated     = -32
+	rpcMethodNotFound       = -32601
+)
+
+// a JSON-RPC error object from bitcoind
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("RPC error %d: %s", e.Code, e.Message)
+}
+
+// all RPC errors match ErrRpcError
+func (e *RPCError) Is(target error) bool {
+	return ErrRpcError == target
+}
+
+// the error object of a reply body, nil if there is none
+func parseRPCError(body []byte) *RPCError {
+	var reply struct {
+		Error *RPCError `json:"error"`
+	}
+	if nil != json.Unmarshal(body, &reply) {
+		return nil
+	}
+	return reply.Error
+}
+
+// convert the raw error slot of a reply to an error, an *RPCError
+// if it can be decoded or else ErrRpcError
+func rpcErrorFrom(raw json.RawMessage) error {
+	var rpcErr RPCError
+	if nil != json.Unmarshal(raw, &rpcErr) {
+		return ErrRpcError
+	}
+	return &rpcErr
+}
+
+// check if the error is due to the method being deprecated or removed,
+// by code or by the hints bitcoind gives in the message
+func (e *RPCError) deprecated() bool {
+	return rpcMethodDeprecated == e.Code ||
+		strings.Contains(e.Message, "-deprecatedrpc") ||
+		strings.Contains(e.Message, "was removed in")
+}
+
+// a call failed because the daemon deprecated or removed the method
+type MethodDeprecatedError struct {
+	Method  string
+	Message string // from bitcoind, usually with the migration hint
+}
+
+func (e *MethodDeprecatedError) Error() string {
+	return fmt.Sprintf("method %q deprecated: %s", e.Method, e.Message)
+}
+
+func (e *MethodDeprecatedError) Unwrap() error {
+	return ErrMethodDeprecated
+}
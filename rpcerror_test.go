@@ -0,0 +1,107 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestMethodDeprecated(t *testing.T) {
+	for _, item := range []struct {
+		name       string
+		rpcErr     RPCError
+		deprecated bool
+	}{
+		{"code", RPCError{Code: rpcMethodDeprecated, Message: "getpeerinfo is deprecated"}, true},
+		{"flag", RPCError{Code: -1, Message: "Using getpeerinfo is deprecated, start with -deprecatedrpc=getpeerinfo"}, true},
+		{"removed", RPCError{Code: -32601, Message: "getpeerinfo was removed in v0.18"}, true},
+		{"other", RPCError{Code: -5, Message: "Peer not found"}, false},
+	} {
+		t.Run(item.name, func(t *testing.T) {
+			rpcErr := item.rpcErr
+			backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+				if "getpeerinfo" == method {
+					return nil, &rpcErr
+				}
+				return nil, nil
+			})
+			backend.connect(t)
+
+			_, reply, err := RemoteCall("getpeerinfo", nil)
+			if !item.deprecated {
+				if nil != err || "null" == string(reply) {
+					t.Errorf("reply: %s  error: %v", reply, err)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrMethodDeprecated) {
+				t.Fatalf("error: %v  expected: %v", err, ErrMethodDeprecated)
+			}
+			var deprecated *MethodDeprecatedError
+			if !errors.As(err, &deprecated) || "getpeerinfo" != deprecated.Method || rpcErr.Message != deprecated.Message {
+				t.Errorf("error: %+v", deprecated)
+			}
+			if calls := backend.receivedFor("getpeerinfo"); 1 != len(calls) {
+				t.Errorf("calls: %v", calls)
+			}
+		})
+	}
+}
+
+// a backend failing getblockhash above height 100 with an RPC error
+func heightErrorBackend(t *testing.T) *fakeBitcoind {
+	return newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockhash" != method {
+			return nil, nil
+		}
+		if "101" == string(params[0]) {
+			return nil, &RPCError{Code: rpcInvalidParameter, Message: "Block height out of range"}
+		}
+		return genesisHash, nil
+	})
+}
+
+func TestRemoteCallRPCErrorSlot(t *testing.T) {
+	backend := heightErrorBackend(t)
+	backend.connect(t)
+
+	result, rpcErr, err := RemoteCall("getblockhash", rawArguments(`101`))
+	if nil != err || "null" != string(result) {
+		t.Fatalf("result: %s  error: %v", result, err)
+	}
+	var decoded RPCError
+	if nil != json.Unmarshal(rpcErr, &decoded) || rpcInvalidParameter != decoded.Code {
+		t.Errorf("rpc error: %s", rpcErr)
+	}
+}
+
+func TestRemoteCallResultSingleError(t *testing.T) {
+	backend := heightErrorBackend(t)
+	backend.connect(t)
+
+	result, err := RemoteCallResult(context.Background(), "getblockhash", rawArguments(`101`))
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) || rpcInvalidParameter != rpcErr.Code || "Block height out of range" != rpcErr.Message {
+		t.Errorf("error: %v", err)
+	}
+	if !errors.Is(err, ErrRpcError) || nil != result {
+		t.Errorf("result: %s  error: %v", result, err)
+	}
+
+	result, err = RemoteCallResult(context.Background(), "getblockhash", rawArguments(`0`))
+	if nil != err || `"`+genesisHash+`"` != string(result) {
+		t.Errorf("result: %s  error: %v", result, err)
+	}
+
+	// local failures come through the same path
+	_, err = RemoteCallResult(context.Background(), "getblockhash", rawArguments(`"x"`))
+	if nil == err || errors.As(err, &rpcErr) {
+		t.Errorf("invalid argument error: %v", err)
+	}
+}
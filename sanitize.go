@@ -0,0 +1,81 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// message for codes without one of their own
+const sanitizedDefault = "request failed"
+
+// generic messages by RPC error code
+var sanitizedMessages = struct {
+	sync.RWMutex
+	messages map[int]string
+}{
+	messages: map[int]string{
+		rpcInvalidAddressOrKey:  "invalid address or key",
+		rpcInvalidParameter:     "invalid parameter",
+		rpcVerifyError:          "transaction verification failed",
+		rpcVerifyRejected:       "transaction rejected",
+		rpcVerifyAlreadyInChain: "transaction already in block chain",
+		rpcMethodNotFound:       "method not found",
+	},
+}
+
+// replace the generic messages given to untrusted callers by RPC
+// error code, codes not in the map get "request failed"
+func SetSanitizedMessages(messages map[int]string) {
+	sanitizedMessages.Lock()
+	defer sanitizedMessages.Unlock()
+
+	sanitizedMessages.messages = make(map[int]string, len(messages))
+	for code, message := range messages {
+		sanitizedMessages.messages[code] = message
+	}
+}
+
+type sanitizeKey struct{}
+
+// mark calls made with the context as from an untrusted caller, the
+// message of an RPC error is then replaced by a generic one for its
+// code, which is kept; call logging still records the full message
+func WithSanitizedErrors(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sanitizeKey{}, true)
+}
+
+// check if errors for calls with the context are sanitized
+func sanitizing(ctx context.Context) bool {
+	sanitize, _ := ctx.Value(sanitizeKey{}).(bool)
+	return sanitize
+}
+
+// rewrite a raw RPC error with the generic message for its code
+func sanitizeError(raw json.RawMessage) json.RawMessage {
+
+	var rpcErr RPCError
+	if nil != json.Unmarshal(raw, &rpcErr) {
+		rpcErr.Code = 0 // not decodable, keep nothing of it
+	}
+
+	sanitizedMessages.RLock()
+	message, ok := sanitizedMessages.messages[rpcErr.Code]
+	sanitizedMessages.RUnlock()
+	if !ok {
+		message = sanitizedDefault
+	}
+
+	sanitized, err := json.Marshal(RPCError{
+		Code:    rpcErr.Code,
+		Message: message,
+	})
+	if nil != err {
+		return raw
+	}
+	return sanitized
+}
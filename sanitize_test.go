@@ -0,0 +1,94 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// an error message with detail not for untrusted callers
+const detailedMessage = "No such mempool or blockchain transaction. Use gettransaction for wallet transactions (datadir /home/bitcoin/.bitcoin)"
+
+// a backend failing getrawtransaction with the detailed message and
+// getblockhash with an unusual code
+func detailedErrorBackend(t *testing.T) *fakeBitcoind {
+	return newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		switch method {
+		case "getrawtransaction":
+			return nil, &RPCError{Code: rpcInvalidAddressOrKey, Message: detailedMessage}
+		case "getblockhash":
+			return nil, &RPCError{Code: -99, Message: "internal detail"}
+		}
+		return nil, nil
+	})
+}
+
+func TestSanitizedErrors(t *testing.T) {
+	logger := &testLogger{}
+	backend := detailedErrorBackend(t)
+	backend.connect(t, WithLogger(logger), WithCallLogging(1000))
+
+	arguments := rawArguments(`"` + testTxID + `"`)
+	_, rpcErr, err := RemoteCallContext(WithSanitizedErrors(context.Background()), "getrawtransaction", arguments)
+	if nil != err || `{"code":-5,"message":"invalid address or key"}` != string(rpcErr) {
+		t.Errorf("rpc error: %s  error: %v", rpcErr, err)
+	}
+
+	// logged in full
+	lines := logger.callLines("getrawtransaction")
+	if 1 != len(lines) || !strings.Contains(lines[0], "/home/bitcoin/.bitcoin") {
+		t.Errorf("lines: %v", lines)
+	}
+
+	// trusted callers see it all
+	_, rpcErr, err = RemoteCall("getrawtransaction", arguments)
+	var full RPCError
+	if nil != err || nil != json.Unmarshal(rpcErr, &full) || detailedMessage != full.Message {
+		t.Errorf("rpc error: %s  error: %v", rpcErr, err)
+	}
+
+	// codes without a message of their own
+	_, rpcErr, _ = RemoteCallContext(WithSanitizedErrors(context.Background()), "getblockhash", rawArguments(`5`))
+	if `{"code":-99,"message":"request failed"}` != string(rpcErr) {
+		t.Errorf("rpc error: %s", rpcErr)
+	}
+}
+
+func TestSetSanitizedMessages(t *testing.T) {
+	backend := detailedErrorBackend(t)
+	backend.connect(t)
+	sanitizedMessages.RLock()
+	defaults := sanitizedMessages.messages
+	sanitizedMessages.RUnlock()
+	t.Cleanup(func() {
+		SetSanitizedMessages(defaults)
+	})
+	SetSanitizedMessages(map[int]string{rpcInvalidAddressOrKey: "not found"})
+
+	_, rpcErr, _ := RemoteCallContext(WithSanitizedErrors(context.Background()), "getrawtransaction", rawArguments(`"`+testTxID+`"`))
+	if `{"code":-5,"message":"not found"}` != string(rpcErr) {
+		t.Errorf("rpc error: %s", rpcErr)
+	}
+}
+
+func TestHandlerSanitized(t *testing.T) {
+	backend := detailedErrorBackend(t)
+	backend.connect(t)
+	handler := pageHandler("test", "", "", false, true)
+
+	w := postCall(handler, `{"id":1,"method":"getrawtransaction","params":["`+testTxID+`"]}`)
+	if strings.Contains(w.Body.String(), "bitcoin") || !strings.Contains(w.Body.String(), "invalid address or key") {
+		t.Errorf("body: %s", w.Body)
+	}
+
+	// errors from the proxy itself are hidden too
+	w = postCall(handler, `{"id":2,"method":"getblockhash","params":["high"]}`)
+	if !strings.Contains(w.Body.String(), `"`+sanitizedDefault+`"`) {
+		t.Errorf("body: %s", w.Body)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// actions accepted by scanblocks
+var scanBlocksActions = map[string]bool{
+	"start":  true,
+	"status": true,
+	"abort":  true,
+}
+
+// check the arguments of scanblocks:
+//
+//	action ( [scanobjects,...] start_height stop_height "filtertype" {options} )
+//
+// only start takes the further arguments, null leaves a middle
+// argument at its default
+func scanBlocksParams(arguments []json.RawMessage) ([]interface{}, error) {
+
+	count := len(arguments)
+	err := checkArgumentCount("scanblocks", count, 1, 6)
+	if nil != err {
+		return nil, err
+	}
+
+	action, err := getString(arguments[0])
+	if nil != err {
+		return nil, err
+	}
+	if !scanBlocksActions[action] {
+		return nil, ErrInvalidArgumentType
+	}
+	if "start" != action {
+		err = checkArgumentCount("scanblocks", count, 1, 1)
+		if nil != err {
+			return nil, err
+		}
+		return []interface{}{action}, nil
+	}
+
+	err = checkArgumentCount("scanblocks", count, 2, 6)
+	if nil != err {
+		return nil, err
+	}
+	scanObjects := bytes.TrimSpace(arguments[1])
+	if 0 == len(scanObjects) || '[' != scanObjects[0] {
+		return nil, ErrInvalidArgumentType
+	}
+
+	// raw so that it is sent as the array, not as base64 bytes
+	params := []interface{}{action, json.RawMessage(scanObjects)}
+
+	// start_height and stop_height
+	for _, argument := range arguments[2:min(count, 4)] {
+		if isNull(bytes.TrimSpace(argument)) {
+			params = append(params, nil)
+			continue
+		}
+		height, err := getNumber(argument)
+		if nil != err {
+			return nil, err
+		}
+		params = append(params, height)
+	}
+
+	if count >= 5 {
+		if isNull(bytes.TrimSpace(arguments[4])) {
+			params = append(params, nil)
+		} else {
+			filterType, err := getString(arguments[4])
+			if nil != err {
+				return nil, err
+			}
+			params = append(params, filterType)
+		}
+	}
+
+	// options, e.g. {"filter_false_positives": true}
+	if 6 == count {
+		options := bytes.TrimSpace(arguments[5])
+		if 0 == len(options) || '{' != options[0] {
+			return nil, ErrInvalidArgumentType
+		}
+		params = append(params, json.RawMessage(options))
+	}
+
+	return params, nil
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestScanBlocksForwardsArguments(t *testing.T) {
+	backend := newFakeBitcoind(t, 250000, nil)
+	conn := backend.connect(t)
+
+	var reply, rpcErr json.RawMessage
+	arguments := rawArguments(`"start"`, `["addr(bcrt1qtest)"]`, `10`, `null`, `"basic"`, `{"filter_false_positives":true}`)
+	err := conn.processCall(context.Background(), "scanblocks", arguments, &reply, &rpcErr)
+	if nil != err {
+		t.Fatalf("scanblocks error: %v", err)
+	}
+
+	expected := `scanblocks["start",["addr(bcrt1qtest)"],10,null,"basic",{"filter_false_positives":true}]`
+	calls := backend.receivedFor("scanblocks")
+	if 1 != len(calls) || expected != calls[0] {
+		t.Errorf("forwarded: %v  expected: %s", calls, expected)
+	}
+}
+
+func TestScanBlocksParams(t *testing.T) {
+	tests := []struct {
+		arguments []string
+		valid     bool
+	}{
+		{[]string{`"status"`}, true},
+		{[]string{`"abort"`}, true},
+		{[]string{`"status"`, `[]`}, false},
+		{[]string{`"restart"`}, false},
+		{[]string{`"start"`}, false},
+		{[]string{`"start"`, `"addr(x)"`}, false},
+		{[]string{`"start"`, `[]`, `1`, `2`, `"basic"`}, true},
+		{[]string{`"start"`, `[]`, `1`, `2`, `"basic"`, `[]`}, false},
+		{[]string{`"start"`, `[]`, `1`, `2`, `"basic"`, `{}`, `{}`}, false},
+	}
+	for _, test := range tests {
+		_, err := scanBlocksParams(rawArguments(test.arguments...))
+		if test.valid != (nil == err) {
+			t.Errorf("arguments: %v  error: %v", test.arguments, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// errors
+var (
+	ErrCallShed = errors.New("call shed: backend latency over threshold")
+)
+
+const (
+	latencyWindow    = 100              // number of recent calls in the percentile
+	latencySampleAge = 30 * time.Second // older durations are not counted
+)
+
+// one backend call duration
+type latencySample struct {
+	duration time.Duration
+	at       time.Time
+}
+
+// rolling record of backend call durations
+type latencyTracker struct {
+	samples [latencyWindow]latencySample
+	next    int
+	count   int
+}
+
+// fail calls with a priority below shedPriorityBelow immediately
+// while the p99 latency of recent calls on the connection is over
+// p99Threshold so that the backend can recover
+//
+// only durations from the last latencySampleAge count, so shedding
+// ends when it leaves no calls to measure, and health probes are
+// measured as well
+func WithLatencyShedding(p99Threshold time.Duration, shedPriorityBelow Priority) Option {
+	return func(conn *RemoteConnection) {
+		conn.shedThreshold = p99Threshold
+		conn.shedBelow = shedPriorityBelow
+	}
+}
+
+// add a duration
+func (conn *RemoteConnection) recordLatency(d time.Duration) {
+	if 0 == conn.shedThreshold {
+		return
+	}
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	l := &conn.latency
+	l.samples[l.next] = latencySample{
+		duration: d,
+		at:       time.Now(),
+	}
+	l.next = (l.next + 1) % latencyWindow
+	if l.count < latencyWindow {
+		l.count += 1
+	}
+}
+
+// the p99 of the durations that are not too old, zero if none are
+func (l *latencyTracker) p99(now time.Time) time.Duration {
+	recent := make([]time.Duration, 0, l.count)
+	for _, sample := range l.samples[:l.count] {
+		if now.Sub(sample.at) < latencySampleAge {
+			recent = append(recent, sample.duration)
+		}
+	}
+	if 0 == len(recent) {
+		return 0
+	}
+	sort.Slice(recent, func(i, j int) bool { return recent[i] < recent[j] })
+	return recent[(len(recent)*99-1)/100]
+}
+
+// check if a call should be shed rather than sent
+func (conn *RemoteConnection) shed(call *Call) bool {
+	if 0 == conn.shedThreshold || call.Priority >= conn.shedBelow {
+		return false
+	}
+
+	conn.RLock()
+	defer conn.RUnlock()
+	return conn.latency.p99(time.Now()) > conn.shedThreshold
+}
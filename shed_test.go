@@ -0,0 +1,69 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLatencyShedding(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	conn := backend.connect(t, WithLatencyShedding(100*time.Millisecond, PriorityNormal))
+
+	for i := 0; i < 10; i += 1 {
+		conn.recordLatency(time.Second)
+	}
+	if !conn.shed(&Call{Priority: PriorityLow}) {
+		t.Error("low priority call not shed")
+	}
+	if conn.shed(&Call{Priority: PriorityNormal}) {
+		t.Error("normal priority call shed")
+	}
+
+	ctx := WithPriority(context.Background(), PriorityLow)
+	_, _, err := RemoteCallContext(ctx, "getbestblockhash", nil)
+	if !errors.Is(err, ErrCallShed) {
+		t.Errorf("error: %v  expected: %v", err, ErrCallShed)
+	}
+}
+
+func TestLatencySheddingEnds(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	conn := backend.connect(t, WithLatencyShedding(100*time.Millisecond, PriorityNormal))
+
+	for i := 0; i < 10; i += 1 {
+		conn.recordLatency(time.Second)
+	}
+
+	// with only shed calls nothing new is measured, the old durations expire
+	conn.Lock()
+	for i := range conn.latency.samples {
+		conn.latency.samples[i].at = time.Now().Add(-latencySampleAge)
+	}
+	conn.Unlock()
+
+	if conn.shed(&Call{Priority: PriorityLow}) {
+		t.Error("still shedding with only expired durations")
+	}
+}
+
+func TestLatencySheddingMeasuresProbes(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	conn := backend.connect(t, WithLatencyShedding(time.Second, PriorityNormal), WithHealthProbe("getblockcount", time.Hour))
+
+	err := conn.probe()
+	if nil != err {
+		t.Fatalf("probe error: %v", err)
+	}
+	conn.RLock()
+	count := conn.latency.count
+	conn.RUnlock()
+	if 1 != count {
+		t.Errorf("%d durations recorded", count)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"time"
+)
+
+// a consistent copy of the state of a connection
+//
+// ChainInfo is nil before bootstrap
+type ConnectionSnapshot struct {
+	URL                 string
+	Chain               string
+	Version             uint64
+	LatestBlockNumber   uint64
+	LatestBlockTime     time.Time
+	ChainInfo           *ChainInfo
+	Health              HealthStatus
+	ConsecutiveFailures int
+	LastSuccess         time.Time
+	InRotation          bool
+}
+
+// copy the state updated by the poller, the health probe and callers
+// under a single lock, so related fields are never from different updates
+func (conn *RemoteConnection) Snapshot() ConnectionSnapshot {
+	conn.RLock()
+	defer conn.RUnlock()
+
+	snapshot := ConnectionSnapshot{
+		URL:                 conn.url,
+		Chain:               conn.chain,
+		Version:             conn.version,
+		LatestBlockNumber:   conn.latestBlockNumber,
+		LatestBlockTime:     conn.latestBlockTime,
+		Health:              conn.health.status,
+		ConsecutiveFailures: conn.health.consecutive,
+		LastSuccess:         conn.health.lastSuccess,
+		InRotation:          conn.inRotation(),
+	}
+	if nil != conn.chainInfo {
+		info := *conn.chainInfo
+		snapshot.ChainInfo = &info
+	}
+	return snapshot
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSnapshotConcurrent(t *testing.T) {
+	height := int64(100)
+	rising := func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		switch method {
+		case "getblockcount":
+			return atomic.AddInt64(&height, 1), nil
+		case "getblockchaininfo":
+			return map[string]interface{}{"chain": "regtest", "blocks": atomic.LoadInt64(&height), "pruned": false}, nil
+		}
+		return nil, nil
+	}
+	blue := newFakeBitcoind(t, 200000, rising)
+	green := newFakeBitcoind(t, 200000, rising)
+	conn := blue.connect(t, WithTipPoller(time.Millisecond))
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	// repoint the connection while the poller updates it
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		targets := []string{green.URL, blue.URL}
+		for i := 0; ; i += 1 {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			conn.SetUpstream(targets[i%2], "user", "password")
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	for i := 0; i < 4; i += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				snapshot := conn.Snapshot()
+				if blue.URL != snapshot.URL && green.URL != snapshot.URL {
+					t.Errorf("url: %s", snapshot.URL)
+				}
+				if "regtest" != snapshot.Chain || 200000 != snapshot.Version || nil == snapshot.ChainInfo {
+					t.Errorf("snapshot: %+v", snapshot)
+					return
+				}
+				blocks, err := strconv.ParseInt(snapshot.ChainInfo.Blocks.String(), 10, 64)
+				if nil != err || blocks < 100 || blocks > atomic.LoadInt64(&height) {
+					t.Errorf("blocks: %s", snapshot.ChainInfo.Blocks)
+				}
+
+				// a copy, not the connection's own
+				snapshot.ChainInfo.Chain = "changed"
+				time.Sleep(100 * time.Microsecond)
+			}
+		}()
+	}
+
+	eventually(t, "the poller to update the tip", func() bool {
+		return conn.Snapshot().LatestBlockNumber > 103
+	})
+	close(stop)
+	wg.Wait()
+
+	if "regtest" != conn.Snapshot().ChainInfo.Chain {
+		t.Error("snapshot shares the chain info")
+	}
+}
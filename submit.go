@@ -0,0 +1,239 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// errors
+var (
+	ErrPackageRejected = errors.New("package rejected")
+	ErrUnknownVersion  = errors.New("bitcoind version not known")
+)
+
+// sendrawtransaction takes a maximum fee rate from this version,
+// before it is an allowhighfees bool
+const bitcoinMaxFeeRateVersion = 190000
+
+// treat a transaction bitcoind already has, in the mempool or in a
+// block, as successfully sent so a broadcast can safely be repeated
+func WithIdempotentSubmit() Option {
+	return func(conn *RemoteConnection) {
+		conn.idempotentSubmit = true
+	}
+}
+
+// broadcast a raw transaction directly on this connection
+//
+// maxFeeRate is in BTC/kvB, zero keeps the daemon default and it is
+// not sent to daemons too old to accept it; a rejection is an *RPCError
+func (conn *RemoteConnection) SendRawTransaction(ctx context.Context, rawHex string, maxFeeRate float64) (string, error) {
+
+	err := conn.ensureBootstrap(ctx)
+	if nil != err {
+		return "", err
+	}
+
+	// never drop the fee cap because the version is not known
+	params := []interface{}{rawHex}
+	if 0 != maxFeeRate {
+		version := conn.daemonVersion()
+		if 0 == version {
+			return "", ErrUnknownVersion
+		}
+		if version >= bitcoinMaxFeeRateVersion {
+			params = append(params, maxFeeRate)
+		}
+	}
+
+	var txid string
+	err = conn.call(ctx, "sendrawtransaction", params, &txid)
+	var rpcErr *RPCError
+	if conn.idempotentSubmit && errors.As(err, &rpcErr) && rpcErr.alreadyKnown() {
+		raw, decodeErr := hex.DecodeString(rawHex)
+		if nil != decodeErr {
+			return "", err
+		}
+		txid, ok := transactionID(raw)
+		if !ok {
+			return "", err
+		}
+		return txid, nil
+	} else if nil != err {
+		return "", err
+	}
+	return txid, nil
+}
+
+// check if a rejection is only because the transaction is not new
+func (e *RPCError) alreadyKnown() bool {
+	return rpcVerifyAlreadyInChain == e.Code ||
+		strings.Contains(e.Message, "txn-already-known") ||
+		strings.Contains(e.Message, "txn-already-in-mempool")
+}
+
+// one sendrawtransaction in flight, shared by identical submissions
+type submission struct {
+	hex    string
+	done   chan struct{}
+	result json.RawMessage
+	rpcErr json.RawMessage
+	err    error
+}
+
+// concurrent sendrawtransaction calls by txid
+var submitTracker = struct {
+	sync.Mutex
+	enabled     bool
+	submissions map[string]*submission
+}{
+	submissions: make(map[string]*submission),
+}
+
+// share one backend call between concurrent RemoteCall submissions of
+// the same raw transaction, e.g. from retrying clients; all get its
+// result, with a transaction bitcoind already has counting as sent
+//
+// submissions are keyed by txid and only joined when the raw hex is
+// identical too, so differently witnessed copies are sent separately
+func SetSubmitCoalescing(enabled bool) {
+	submitTracker.Lock()
+	submitTracker.enabled = enabled
+	submitTracker.Unlock()
+}
+
+// join an identical sendrawtransaction in flight or start it, false
+// if the call is not one that can be coalesced
+func joinSubmission(ctx context.Context, queues *chainQueues, arguments []json.RawMessage) (*submission, bool) {
+
+	var rawHex string
+	if 1 != len(arguments) || nil != json.Unmarshal(arguments[0], &rawHex) {
+		return nil, false
+	}
+	raw, err := hex.DecodeString(rawHex)
+	if nil != err {
+		return nil, false
+	}
+	txid, ok := transactionID(raw)
+	if !ok {
+		return nil, false
+	}
+
+	submitTracker.Lock()
+	if !submitTracker.enabled {
+		submitTracker.Unlock()
+		return nil, false
+	}
+	s := submitTracker.submissions[txid]
+	if nil != s && s.hex != rawHex {
+		submitTracker.Unlock()
+		return nil, false
+	}
+	if nil == s {
+		s = &submission{
+			hex:  rawHex,
+			done: make(chan struct{}),
+		}
+		submitTracker.submissions[txid] = s
+
+		// not cancelled with this caller as others may be waiting
+		go func(ctx context.Context) {
+			result, rpcErr, err := queueCall(ctx, queues.writes.in, queues.writes.done(), "sendrawtransaction", arguments)
+			if nil == err && !isNull(rpcErr) {
+				var e *RPCError
+				if errors.As(rpcErrorFrom(rpcErr), &e) && e.alreadyKnown() {
+					result, rpcErr = json.RawMessage(`"`+txid+`"`), jsonNull
+				}
+			}
+
+			submitTracker.Lock()
+			delete(submitTracker.submissions, txid)
+			submitTracker.Unlock()
+
+			s.result, s.rpcErr, s.err = result, rpcErr, err
+			close(s.done)
+		}(context.WithoutCancel(ctx))
+	}
+	submitTracker.Unlock()
+
+	return s, true
+}
+
+// wait for the result of a shared submission
+func (s *submission) wait(ctx context.Context) (json.RawMessage, json.RawMessage, error) {
+	select {
+	case <-s.done:
+		return s.result, s.rpcErr, s.err
+	case <-ctx.Done():
+		return jsonNull, jsonNull, ctx.Err()
+	}
+}
+
+// submit a child transaction with its unconfirmed parents, e.g. a CPFP
+// child paying for a parent below the mempool minimum fee
+//
+// daemons with submitpackage get them as one package, so the fees are
+// judged together; older daemons get each parent and then the child
+// by sendrawtransaction, where a parent bitcoind already has is not an
+// error but a parent that cannot pay for itself is rejected
+func (conn *RemoteConnection) SubmitWithParents(ctx context.Context, childHex string, parentHexes []string) error {
+
+	err := conn.ensureBootstrap(ctx)
+	if nil != err {
+		return err
+	}
+
+	if nil != conn.requireVersion("submitpackage") {
+		for _, parentHex := range parentHexes {
+			_, err := conn.SendRawTransaction(ctx, parentHex, 0)
+			var rpcErr *RPCError
+			if errors.As(err, &rpcErr) && rpcErr.alreadyKnown() {
+				continue
+			} else if nil != err {
+				return err
+			}
+		}
+		_, err := conn.SendRawTransaction(ctx, childHex, 0)
+		return err
+	}
+
+	// parents first, topologically sorted, and the child last
+	transactions := append(append([]string{}, parentHexes...), childHex)
+	var reply struct {
+		PackageMsg string `json:"package_msg"`
+		TxResults  map[string]struct {
+			TxID  string `json:"txid"`
+			Error string `json:"error"`
+		} `json:"tx-results"`
+	}
+	err = conn.call(ctx, "submitpackage", []interface{}{transactions}, &reply)
+	if nil != err {
+		return err
+	}
+	if "success" == reply.PackageMsg {
+		return nil
+	}
+
+	// name the transactions that failed, in a stable order
+	rejected := []string{}
+	for _, result := range reply.TxResults {
+		if "" != result.Error {
+			rejected = append(rejected, result.TxID+": "+result.Error)
+		}
+	}
+	sort.Strings(rejected)
+	if 0 == len(rejected) {
+		return fmt.Errorf("%w: %s", ErrPackageRejected, reply.PackageMsg)
+	}
+	return fmt.Errorf("%w: %s (%s)", ErrPackageRejected, reply.PackageMsg, strings.Join(rejected, ", "))
+}
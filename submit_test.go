@@ -0,0 +1,307 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a minimal transaction and its txid
+const (
+	testTransaction = "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff00ffffffff0100000000000000000000000000"
+	testTxID        = "2fb7d2ab4ea206f3491ae234583c124d5087b6267308288e1359a6052fc477e1"
+)
+
+// a backend that already has every transaction
+func alreadyInChain(method string, params []json.RawMessage) (interface{}, *RPCError) {
+	if "sendrawtransaction" == method {
+		return nil, &RPCError{Code: rpcVerifyAlreadyInChain, Message: "Transaction already in block chain"}
+	}
+	return nil, nil
+}
+
+func TestSendRawTransactionFeeRate(t *testing.T) {
+	tests := []struct {
+		version  uint64
+		expected string
+	}{
+		{150000, `sendrawtransaction["00"]`},
+		{190000, `sendrawtransaction["00",0.1]`},
+	}
+	for _, test := range tests {
+		backend := newFakeBitcoind(t, test.version, nil)
+		conn := backend.connect(t)
+		_, err := conn.SendRawTransaction(context.Background(), "00", 0.1)
+		if nil != err {
+			t.Fatalf("version: %d  error: %v", test.version, err)
+		}
+		calls := backend.receivedFor("sendrawtransaction")
+		if 1 != len(calls) || test.expected != calls[0] {
+			t.Errorf("version: %d  sent: %v  expected: %s", test.version, calls, test.expected)
+		}
+	}
+}
+
+func TestSendRawTransactionUnknownVersion(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	conn := backend.connect(t)
+
+	// as if a bootstrap had not recorded a version
+	conn.Lock()
+	conn.version = 0
+	conn.Unlock()
+
+	_, err := conn.SendRawTransaction(context.Background(), "00", 0.1)
+	if !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("error: %v  expected: %v", err, ErrUnknownVersion)
+	}
+	if calls := backend.receivedFor("sendrawtransaction"); 0 != len(calls) {
+		t.Errorf("sent without the fee cap: %v", calls)
+	}
+}
+
+func TestSendRawTransactionIdempotent(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, alreadyInChain)
+	conn := backend.connect(t, WithIdempotentSubmit())
+
+	txid, err := conn.SendRawTransaction(context.Background(), testTransaction, 0)
+	if nil != err {
+		t.Fatalf("send error: %v", err)
+	}
+	if testTxID != txid {
+		t.Errorf("txid: %s  expected: %s", txid, testTxID)
+	}
+	if calls := backend.received(); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestSendRawTransactionAlreadyKnownIsError(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, alreadyInChain)
+	conn := backend.connect(t)
+
+	_, err := conn.SendRawTransaction(context.Background(), testTransaction, 0)
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) || rpcVerifyAlreadyInChain != rpcErr.Code {
+		t.Errorf("error: %v", err)
+	}
+}
+
+// a backend holding sendrawtransaction until released, then replying
+// with reply or rejecting with rpcErr
+type heldSubmitBackend struct {
+	*fakeBitcoind
+	release chan struct{}
+}
+
+func newHeldSubmitBackend(t *testing.T, reply interface{}, rpcErr *RPCError) *heldSubmitBackend {
+	h := &heldSubmitBackend{
+		release: make(chan struct{}),
+	}
+	h.fakeBitcoind = newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "sendrawtransaction" != method {
+			return nil, nil
+		}
+		<-h.release
+		return reply, rpcErr
+	})
+	t.Cleanup(h.done)
+	return h
+}
+
+func (h *heldSubmitBackend) done() {
+	select {
+	case <-h.release:
+	default:
+		close(h.release)
+	}
+}
+
+// send submissions of the raw transactions at once, releasing the
+// backend once they all had time to join one in flight
+func submitConcurrently(t *testing.T, backend *heldSubmitBackend, transactions ...string) []string {
+	SetSubmitCoalescing(true)
+	t.Cleanup(func() {
+		SetSubmitCoalescing(false)
+	})
+
+	results := make([]string, len(transactions))
+	var wg sync.WaitGroup
+	for i, transaction := range transactions {
+		wg.Add(1)
+		go func(i int, transaction string) {
+			defer wg.Done()
+			result, rpcErr, err := RemoteCall("sendrawtransaction", rawArguments(`"`+transaction+`"`))
+			results[i] = fmt.Sprintf("%s %s %v", result, rpcErr, err)
+		}(i, transaction)
+	}
+	eventually(t, "a submission to be sent", func() bool {
+		return 0 != len(backend.receivedFor("sendrawtransaction"))
+	})
+	time.Sleep(50 * time.Millisecond)
+	backend.done()
+	wg.Wait()
+	return results
+}
+
+func TestSubmitCoalescing(t *testing.T) {
+	backend := newHeldSubmitBackend(t, testTxID, nil)
+	backend.connect(t)
+	backend.connect(t)
+
+	transactions := make([]string, 10)
+	for i := range transactions {
+		transactions[i] = testTransaction
+	}
+	for _, result := range submitConcurrently(t, backend, transactions...) {
+		if `"`+testTxID+`" null <nil>` != result {
+			t.Errorf("result: %s", result)
+		}
+	}
+	if calls := backend.receivedFor("sendrawtransaction"); 1 != len(calls) {
+		t.Errorf("%d submissions sent", len(calls))
+	}
+}
+
+func TestSubmitCoalescingAlreadyKnown(t *testing.T) {
+	backend := newHeldSubmitBackend(t, nil, &RPCError{Code: rpcVerifyError, Message: "txn-already-in-mempool"})
+	backend.connect(t)
+
+	for _, result := range submitConcurrently(t, backend, testTransaction, testTransaction, testTransaction) {
+		if `"`+testTxID+`" null <nil>` != result {
+			t.Errorf("result: %s", result)
+		}
+	}
+	if calls := backend.receivedFor("sendrawtransaction"); 1 != len(calls) {
+		t.Errorf("%d submissions sent", len(calls))
+	}
+}
+
+func TestSubmitCoalescingDistinct(t *testing.T) {
+	backend := newHeldSubmitBackend(t, testTxID, nil)
+	backend.connect(t)
+	backend.connect(t)
+	backend.connect(t)
+
+	// a different lock time is a different transaction, and a witness
+	// gives the same txid but different bytes
+	other := testTransaction[:len(testTransaction)-8] + "01000000"
+	witnessed := testTransaction[:8] + "0001" + testTransaction[8:len(testTransaction)-8] + "010151" + "00000000"
+	if txid, ok := transactionID(mustDecodeHex(t, witnessed)); !ok || testTxID != txid {
+		t.Fatalf("witnessed txid: %s", txid)
+	}
+
+	submitConcurrently(t, backend, testTransaction, other, witnessed)
+	sent := map[string]int{}
+	for _, call := range backend.receivedFor("sendrawtransaction") {
+		sent[call] += 1
+	}
+	for _, transaction := range []string{testTransaction, other, witnessed} {
+		if 1 != sent[`sendrawtransaction["`+transaction+`"]`] {
+			t.Errorf("sent: %v", sent)
+		}
+	}
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	raw, err := hex.DecodeString(s)
+	if nil != err {
+		t.Fatalf("hex: %v", err)
+	}
+	return raw
+}
+
+func TestSubmitWithParentsPackage(t *testing.T) {
+	backend := newFakeBitcoind(t, 280000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "submitpackage" == method {
+			return map[string]interface{}{"package_msg": "success"}, nil
+		}
+		return nil, nil
+	})
+	conn := backend.connect(t)
+
+	err := conn.SubmitWithParents(context.Background(), "cc", []string{"aa", "bb"})
+	if nil != err {
+		t.Fatalf("submit error: %v", err)
+	}
+	calls := backend.received()
+	if 1 != len(calls) || `submitpackage[["aa","bb","cc"]]` != calls[0] {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestSubmitWithParentsPackageRejected(t *testing.T) {
+	backend := newFakeBitcoind(t, 280000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "submitpackage" == method {
+			return map[string]interface{}{
+				"package_msg": "transaction failed",
+				"tx-results": map[string]interface{}{
+					"w1": map[string]interface{}{"txid": "t1"},
+					"w2": map[string]interface{}{"txid": "t2", "error": "min relay fee not met"},
+				},
+			}, nil
+		}
+		return nil, nil
+	})
+	conn := backend.connect(t)
+
+	err := conn.SubmitWithParents(context.Background(), "cc", []string{"aa"})
+	if !errors.Is(err, ErrPackageRejected) {
+		t.Fatalf("submit error: %v", err)
+	}
+	if "package rejected: transaction failed (t2: min relay fee not met)" != err.Error() {
+		t.Errorf("error: %v", err)
+	}
+}
+
+func TestSubmitWithParentsFallback(t *testing.T) {
+	backend := newFakeBitcoind(t, 270000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "sendrawtransaction" != method {
+			return nil, nil
+		}
+		// the first parent is already in the mempool
+		if `"aa"` == string(params[0]) {
+			return nil, &RPCError{Code: rpcVerifyError, Message: "txn-already-in-mempool"}
+		}
+		return "txid", nil
+	})
+	conn := backend.connect(t)
+
+	err := conn.SubmitWithParents(context.Background(), "cc", []string{"aa", "bb"})
+	if nil != err {
+		t.Fatalf("submit error: %v", err)
+	}
+	calls := fmt.Sprint(backend.received())
+	if `[sendrawtransaction["aa"] sendrawtransaction["bb"] sendrawtransaction["cc"]]` != calls {
+		t.Errorf("calls: %s", calls)
+	}
+}
+
+func TestSubmitWithParentsFallbackRejected(t *testing.T) {
+	backend := newFakeBitcoind(t, 270000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "sendrawtransaction" == method {
+			return nil, &RPCError{Code: rpcVerifyError, Message: "min relay fee not met"}
+		}
+		return nil, nil
+	})
+	conn := backend.connect(t)
+
+	err := conn.SubmitWithParents(context.Background(), "cc", []string{"aa", "bb"})
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) || "min relay fee not met" != rpcErr.Message {
+		t.Fatalf("submit error: %v", err)
+	}
+	calls := fmt.Sprint(backend.received())
+	if `[sendrawtransaction["aa"]]` != calls {
+		t.Errorf("calls: %s", calls)
+	}
+}
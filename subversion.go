@@ -0,0 +1,50 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// errors
+var (
+	ErrUnexpectedSubversion = errors.New("unexpected bitcoind subversion")
+)
+
+// only accept a daemon whose getnetworkinfo subversion matches the
+// regular expression, e.g. `^/Satoshi:2[5-9]\.` for a particular
+// implementation and release range
+func WithExpectedSubversion(pattern string) Option {
+	return func(conn *RemoteConnection) {
+		conn.expectedSubversion = pattern
+	}
+}
+
+// check the subversion at bootstrap if a pattern was given
+func (conn *RemoteConnection) checkSubversion(ctx context.Context) error {
+	if "" == conn.expectedSubversion {
+		return nil
+	}
+
+	expected, err := regexp.Compile(conn.expectedSubversion)
+	if nil != err {
+		return fmt.Errorf("%w: invalid pattern: %v", ErrUnexpectedSubversion, err)
+	}
+
+	var info struct {
+		SubVersion string `json:"subversion"`
+	}
+	err = conn.directCall(ctx, "getnetworkinfo", []interface{}{}, &info)
+	if nil != err {
+		return err
+	}
+	if !expected.MatchString(info.SubVersion) {
+		return fmt.Errorf("%w: %q does not match %q", ErrUnexpectedSubversion, info.SubVersion, conn.expectedSubversion)
+	}
+	return nil
+}
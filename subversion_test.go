@@ -0,0 +1,47 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExpectedSubversion(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+
+	for _, pattern := range []string{`^/Satoshi:`, `test/$`} {
+		conn, err := NewRemoteConnection(backend.URL, "user", "password", "regtest", nil, WithExpectedSubversion(pattern))
+		if nil != err {
+			t.Errorf("%s error: %v", pattern, err)
+			continue
+		}
+		conn.Destroy()
+	}
+
+	for _, pattern := range []string{`^/Knots:`, `^/Satoshi:2[5-9]\.`, `(`} {
+		_, err := NewRemoteConnection(backend.URL, "user", "password", "regtest", nil, WithExpectedSubversion(pattern))
+		if !errors.Is(err, ErrUnexpectedSubversion) {
+			t.Errorf("%s error: %v  expected: %v", pattern, err, ErrUnexpectedSubversion)
+		}
+		if "(" != pattern && (nil == err || !strings.Contains(err.Error(), "/Satoshi:test/")) {
+			t.Errorf("%s error does not name the subversion: %v", pattern, err)
+		}
+	}
+}
+
+func TestExpectedSubversionLazy(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.connect(t, WithLazyBootstrap(), WithExpectedSubversion(`^/Knots:`))
+
+	_, _, err := RemoteCall("getpeerinfo", nil)
+	if !errors.Is(err, ErrUnexpectedSubversion) {
+		t.Errorf("error: %v  expected: %v", err, ErrUnexpectedSubversion)
+	}
+	if calls := backend.receivedFor("getpeerinfo"); 0 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errors
+var (
+	ErrDeadlineExceeded = errors.New("deadline too close for another attempt")
+)
+
+// expected round trip times of methods
+var expectedDurations = struct {
+	sync.RWMutex
+	durations map[string]time.Duration
+}{
+	durations: make(map[string]time.Duration),
+}
+
+// limit each backend attempt on the connection to d, zero for no limit
+func WithRequestTimeout(d time.Duration) Option {
+	return func(conn *RemoteConnection) {
+		conn.requestTimeout = d
+	}
+}
+
+type timeoutKey struct{}
+
+// replace the connection request timeout for calls made with the
+// context, e.g. to allow a long scanblocks to finish
+func WithCallTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutKey{}, d)
+}
+
+// the per-attempt timeout for a call, zero if none
+func (conn *RemoteConnection) callTimeout(ctx context.Context) time.Duration {
+	if d, ok := ctx.Value(timeoutKey{}).(time.Duration); ok {
+		return d
+	}
+	return conn.requestTimeout
+}
+
+// expect an attempt of method to take about d, so RemoteCall fails with
+// ErrDeadlineExceeded instead of starting an attempt when less than d
+// of the context deadline remains; zero removes the expectation
+func SetMethodExpectedDuration(method string, d time.Duration) {
+	expectedDurations.Lock()
+	defer expectedDurations.Unlock()
+
+	if d <= 0 {
+		delete(expectedDurations.durations, method)
+		return
+	}
+	expectedDurations.durations[method] = d
+}
+
+// check there is time left for another attempt of a method
+func enoughTime(ctx context.Context, method string) bool {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return true
+	}
+	expectedDurations.RLock()
+	expected := expectedDurations.durations[method]
+	expectedDurations.RUnlock()
+	return time.Until(deadline) >= expected
+}
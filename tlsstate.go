@@ -0,0 +1,42 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// the negotiated TLS state of the last successful request, e.g. the
+// version, cipher suite and peer certificates; nil when the backend
+// is not using TLS or no request has succeeded yet
+//
+// it is refreshed by every successful request so it follows reconnects
+func (conn *RemoteConnection) TLSState() *tls.ConnectionState {
+	conn.RLock()
+	defer conn.RUnlock()
+	if nil == conn.tlsState {
+		return nil
+	}
+	state := *conn.tlsState
+	return &state
+}
+
+// send a request, recording the TLS state of the connection it used
+// if the backend accepted it
+func (conn *RemoteConnection) doRecordingTLS(request *http.Request) (*http.Response, error) {
+
+	response, err := conn.client.Do(request)
+	if nil != err {
+		return nil, err
+	}
+
+	if http.StatusOK == response.StatusCode {
+		conn.Lock()
+		conn.tlsState = response.TLS
+		conn.Unlock()
+	}
+	return response, nil
+}
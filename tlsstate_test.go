@@ -0,0 +1,115 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// start a fake backend serving TLS no newer than version, trusted by roots
+func tlsBackend(t *testing.T, version uint16, roots *x509.CertPool) *fakeBitcoind {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.Server = httptest.NewUnstartedServer(http.HandlerFunc(backend.serve))
+	backend.Server.TLS = &tls.Config{
+		MaxVersion: version,
+	}
+	backend.StartTLS()
+	t.Cleanup(backend.Server.Close)
+	roots.AddCert(backend.Certificate())
+	return backend
+}
+
+func TestTLSState(t *testing.T) {
+	roots := x509.NewCertPool()
+	backend := tlsBackend(t, tls.VersionTLS12, roots)
+	conn, err := NewRemoteConnection(backend.URL, "user", "password", "regtest", &tls.Config{RootCAs: roots})
+	if nil != err {
+		t.Fatalf("connect error: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.DestroyWithTimeout(time.Second)
+	})
+
+	state := conn.TLSState()
+	if nil == state {
+		t.Fatal("no TLS state")
+	}
+	if tls.VersionTLS12 != state.Version || !state.HandshakeComplete || 0 == state.CipherSuite {
+		t.Errorf("version: %x  handshake: %v  cipher: %x", state.Version, state.HandshakeComplete, state.CipherSuite)
+	}
+	if 0 == len(state.PeerCertificates) || !state.PeerCertificates[0].Equal(backend.Certificate()) {
+		t.Errorf("peer certificates: %d", len(state.PeerCertificates))
+	}
+}
+
+// the state follows the connection to a new target
+func TestTLSStateUpdated(t *testing.T) {
+	roots := x509.NewCertPool()
+	old := tlsBackend(t, tls.VersionTLS12, roots)
+	replacement := tlsBackend(t, tls.VersionTLS13, roots)
+	conn, err := NewRemoteConnection(old.URL, "user", "password", "regtest", &tls.Config{RootCAs: roots})
+	if nil != err {
+		t.Fatalf("connect error: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.DestroyWithTimeout(time.Second)
+	})
+
+	if state := conn.TLSState(); nil == state || tls.VersionTLS12 != state.Version {
+		t.Fatalf("state: %+v", state)
+	}
+	err = conn.SetUpstream(replacement.URL, "user", "password")
+	if nil != err {
+		t.Fatalf("swap error: %v", err)
+	}
+	state := conn.TLSState()
+	if nil == state || tls.VersionTLS13 != state.Version || !state.PeerCertificates[0].Equal(replacement.Certificate()) {
+		t.Errorf("state: %+v", state)
+	}
+}
+
+func TestTLSStatePlain(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	conn := backend.connect(t)
+
+	if state := conn.TLSState(); nil != state {
+		t.Errorf("state: %+v", state)
+	}
+}
+
+// a rejected request leaves the state of the last successful one
+func TestTLSStateOnlySuccessful(t *testing.T) {
+	roots := x509.NewCertPool()
+	old := tlsBackend(t, tls.VersionTLS12, roots)
+	rejecting := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	rejecting.TLS = &tls.Config{MinVersion: tls.VersionTLS13}
+	rejecting.StartTLS()
+	t.Cleanup(rejecting.Close)
+	roots.AddCert(rejecting.Certificate())
+
+	conn, err := NewRemoteConnection(old.URL, "user", "password", "regtest", &tls.Config{RootCAs: roots})
+	if nil != err {
+		t.Fatalf("connect error: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.DestroyWithTimeout(time.Second)
+	})
+
+	err = conn.SetUpstream(rejecting.URL, "user", "password")
+	if nil == err {
+		t.Fatal("swap to a rejecting backend succeeded")
+	}
+	state := conn.TLSState()
+	if nil == state || tls.VersionTLS12 != state.Version || !state.PeerCertificates[0].Equal(old.Certificate()) {
+		t.Errorf("state: %+v", state)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// decoded transaction as returned by verbose getrawtransaction
+//
+// block fields are only present for confirmed transactions
+type Transaction struct {
+	TxID          string              `json:"txid"`
+	Hash          string              `json:"hash"`
+	Version       int32               `json:"version"`
+	Size          uint64              `json:"size"`
+	VSize         uint64              `json:"vsize"`
+	Weight        uint64              `json:"weight"`
+	LockTime      uint32              `json:"locktime"`
+	Vin           []TransactionInput  `json:"vin"`
+	Vout          []TransactionOutput `json:"vout"`
+	BlockHash     string              `json:"blockhash"`
+	Confirmations uint64              `json:"confirmations"`
+	Time          int64               `json:"time"`
+	BlockTime     int64               `json:"blocktime"`
+}
+
+// a transaction input, Coinbase is only set for coinbase inputs
+type TransactionInput struct {
+	TxID        string    `json:"txid"`
+	Vout        uint32    `json:"vout"`
+	ScriptSig   ScriptSig `json:"scriptSig"`
+	TxInWitness []string  `json:"txinwitness"`
+	Sequence    uint32    `json:"sequence"`
+	Coinbase    string    `json:"coinbase"`
+}
+
+// a transaction output, Value is in BTC
+type TransactionOutput struct {
+	Value        json.Number  `json:"value"`
+	N            uint32       `json:"n"`
+	ScriptPubKey ScriptPubKey `json:"scriptPubKey"`
+}
+
+// input script
+type ScriptSig struct {
+	Asm string `json:"asm"`
+	Hex string `json:"hex"`
+}
+
+// output script
+//
+// newer bitcoind give a single address,
+// older versions give reqSigs and an addresses list
+type ScriptPubKey struct {
+	Asm       string   `json:"asm"`
+	Hex       string   `json:"hex"`
+	Type      string   `json:"type"`
+	Address   string   `json:"address"`
+	ReqSigs   int      `json:"reqSigs"`
+	Addresses []string `json:"addresses"`
+}
+
+// fetch a transaction as both raw hex and decoded from a single verbose call
+func GetRawTransactionBoth(txid string) (string, *Transaction, error) {
+
+	hash, err := json.Marshal(txid)
+	if nil != err {
+		return "", nil, err
+	}
+
+	result, rpcErr, err := RemoteCall("getrawtransaction", []json.RawMessage{hash, json.RawMessage("1")})
+	if nil != err {
+		return "", nil, err
+	}
+
+	var reply struct {
+		Transaction
+		Hex string `json:"hex"`
+	}
+	err = decodeResult(result, rpcErr, &reply)
+	if nil != err {
+		return "", nil, err
+	}
+	return reply.Hex, &reply.Transaction, nil
+}
+
+// an unspent transaction output from gettxout, Value is in satoshis
+type TxOut struct {
+	BestBlock     string       `json:"bestblock"`
+	Confirmations uint64       `json:"confirmations"`
+	Value         int64        `json:"-"`
+	ScriptPubKey  ScriptPubKey `json:"scriptPubKey"`
+	Coinbase      bool         `json:"coinbase"`
+}
+
+// fetch an unspent output, nil if it is spent or does not exist
+func (conn *RemoteConnection) GetTxOut(ctx context.Context, txid string, vout uint32, includeMempool bool) (*TxOut, error) {
+
+	var reply *struct {
+		TxOut
+		Value json.Number `json:"value"` // BTC
+	}
+	err := conn.call(ctx, "gettxout", []interface{}{txid, vout, includeMempool}, &reply)
+	if nil != err {
+		return nil, err
+	}
+	if nil == reply {
+		return nil, nil
+	}
+
+	out := reply.TxOut
+	out.Value, err = btcToSatoshis(reply.Value)
+	if nil != err {
+		return nil, err
+	}
+	return &out, nil
+}
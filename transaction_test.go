@@ -0,0 +1,133 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// a verbose getrawtransaction reply for testTransaction
+const testVerboseTransaction = `{
+  "txid": "` + testTxID + `", "hash": "` + testTxID + `",
+  "version": 1, "size": 60, "vsize": 60, "weight": 240, "locktime": 0,
+  "vin": [{"txid": "0000000000000000000000000000000000000000000000000000000000000001", "vout": 0,
+           "scriptSig": {"asm": "", "hex": ""}, "sequence": 4294967295}],
+  "vout": [{"value": 0.00001000, "n": 0,
+            "scriptPubKey": {"asm": "OP_TRUE", "hex": "51", "type": "nonstandard"}}],
+  "hex": "` + testTransaction + `",
+  "blockhash": "00000000000000000000000000000000000000000000000000000000000000aa",
+  "confirmations": 3, "time": 1600000000, "blocktime": 1600000000
+}`
+
+// a backend answering getrawtransaction with a verbose reply
+func transactionBackend(t *testing.T) *fakeBitcoind {
+	return newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getrawtransaction" == method {
+			return json.RawMessage(testVerboseTransaction), nil
+		}
+		return nil, nil
+	})
+}
+
+func TestGetRawTransactionBoth(t *testing.T) {
+	backend := transactionBackend(t)
+	backend.connect(t)
+
+	raw, tx, err := GetRawTransactionBoth(testTxID)
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if testTransaction != raw {
+		t.Errorf("hex: %s", raw)
+	}
+	if testTxID != tx.TxID || 60 != tx.Size || 1 != len(tx.Vin) || 1 != len(tx.Vout) || 3 != tx.Confirmations {
+		t.Errorf("transaction: %+v", tx)
+	}
+	if "0.00001000" != tx.Vout[0].Value.String() || "51" != tx.Vout[0].ScriptPubKey.Hex {
+		t.Errorf("output: %+v", tx.Vout[0])
+	}
+
+	// both come from a single verbose call
+	calls := backend.receivedFor("getrawtransaction")
+	if 1 != len(calls) || `getrawtransaction["`+testTxID+`",true]` != calls[0] {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+// a backend answering gettxout from a map of txid:vout to replies,
+// anything missing is spent
+func txOutBackend(t *testing.T, outputs map[string]string) *fakeBitcoind {
+	return newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "gettxout" != method {
+			return nil, nil
+		}
+		var txid string
+		json.Unmarshal(params[0], &txid)
+		if reply, ok := outputs[txid+":"+string(params[1])]; ok {
+			return json.RawMessage(reply), nil
+		}
+		return json.RawMessage("null"), nil
+	})
+}
+
+func TestGetTxOutUnspent(t *testing.T) {
+	backend := txOutBackend(t, map[string]string{
+		testTxID + ":1": `{"bestblock": "00000000000000000000000000000000000000000000000000000000000000aa",
+		  "confirmations": 6, "value": 20999999.97690000,
+		  "scriptPubKey": {"asm": "OP_TRUE", "hex": "51", "type": "nonstandard"}, "coinbase": false}`,
+	})
+	conn := backend.connect(t)
+
+	out, err := conn.GetTxOut(context.Background(), testTxID, 1, true)
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if nil == out {
+		t.Fatal("unspent output missing")
+	}
+
+	// no float rounding on the conversion
+	if 2099999997690000 != out.Value {
+		t.Errorf("value: %d  expected: 2099999997690000", out.Value)
+	}
+	if 6 != out.Confirmations || out.Coinbase || "51" != out.ScriptPubKey.Hex || "aa" != out.BestBlock[62:] {
+		t.Errorf("output: %+v", out)
+	}
+	if calls := backend.receivedFor("gettxout"); 1 != len(calls) || `gettxout["`+testTxID+`",1,true]` != calls[0] {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestGetTxOutSpent(t *testing.T) {
+	backend := txOutBackend(t, nil)
+	conn := backend.connect(t)
+
+	out, err := conn.GetTxOut(context.Background(), testTxID, 0, false)
+	if nil != err || nil != out {
+		t.Errorf("output: %+v  error: %v", out, err)
+	}
+	if calls := backend.receivedFor("gettxout"); 1 != len(calls) || `gettxout["`+testTxID+`",0,false]` != calls[0] {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestGetTxOutCoinbase(t *testing.T) {
+	backend := txOutBackend(t, map[string]string{
+		testTxID + ":0": `{"bestblock": "00000000000000000000000000000000000000000000000000000000000000aa",
+		  "confirmations": 101, "value": 50.00000000,
+		  "scriptPubKey": {"asm": "", "hex": "0014", "type": "witness_v0_keyhash", "address": "bcrt1q"}, "coinbase": true}`,
+	})
+	conn := backend.connect(t)
+
+	out, err := conn.GetTxOut(context.Background(), testTxID, 0, true)
+	if nil != err || nil == out {
+		t.Fatalf("output: %+v  error: %v", out, err)
+	}
+	if !out.Coinbase || 5000000000 != out.Value || 101 != out.Confirmations || "bcrt1q" != out.ScriptPubKey.Address {
+		t.Errorf("output: %+v", out)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+type blockHashHintKey struct{}
+
+// retry a bare getrawtransaction that failed for want of the
+// transaction index with the block hash added, if one is known
+//
+// the hash comes from WithBlockHashHint on the call's context or else
+// from lookup, which may be nil; the proxy cannot find the block of an
+// arbitrary transaction itself, without an index only mempool
+// transactions and those with a known block can be fetched
+func WithBlockHashRetry(lookup func(ctx context.Context, txid string) (string, bool)) Option {
+	return func(conn *RemoteConnection) {
+		conn.blockHashRetry = true
+		conn.blockHashLookup = lookup
+	}
+}
+
+// give the hash of the block containing the transaction of a
+// getrawtransaction made with the context, for WithBlockHashRetry
+func WithBlockHashHint(ctx context.Context, blockHash string) context.Context {
+	return context.WithValue(ctx, blockHashHintKey{}, blockHash)
+}
+
+// the block hash to retry a transaction lookup with, if any
+func (conn *RemoteConnection) blockHashFor(ctx context.Context, txid string) (string, bool) {
+	if hint, ok := ctx.Value(blockHashHintKey{}).(string); ok && "" != hint {
+		return hint, true
+	}
+	if nil == conn.blockHashLookup {
+		return "", false
+	}
+	return conn.blockHashLookup(ctx, txid)
+}
+
+// check if a raw error slot is the failure of a lookup outside the
+// mempool because the daemon has no transaction index
+func needsTxIndex(raw json.RawMessage) bool {
+	if 0 == len(raw) || isNull(raw) {
+		return false
+	}
+	var rpcErr RPCError
+	if nil != json.Unmarshal(raw, &rpcErr) {
+		return false
+	}
+	return rpcErr.txIndexRequired()
+}
+
+// check if a lookup failed for want of the transaction index
+func (e *RPCError) txIndexRequired() bool {
+	return rpcInvalidAddressOrKey == e.Code && strings.Contains(e.Message, "txindex")
+}
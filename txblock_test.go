@@ -0,0 +1,112 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// the block holding testTransaction
+const testTxBlock = "00000000000000000000000000000000000000000000000000000000000000aa"
+
+// a backend without a transaction index, finding testTransaction only
+// when given its block
+func noTxIndexBackend(t *testing.T) *fakeBitcoind {
+	return newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getrawtransaction" != method {
+			return nil, nil
+		}
+		if 3 == len(params) && `"`+testTxBlock+`"` == string(params[2]) {
+			return testTransaction, nil
+		}
+		if 3 == len(params) {
+			return nil, &RPCError{Code: rpcInvalidAddressOrKey, Message: "No such transaction found in the provided block"}
+		}
+		return nil, &RPCError{Code: rpcInvalidAddressOrKey, Message: "No such mempool transaction. Use -txindex or provide a block hash to enable blockchain transaction queries"}
+	})
+}
+
+func TestBlockHashRetryHint(t *testing.T) {
+	backend := noTxIndexBackend(t)
+	backend.connect(t, WithBlockHashRetry(nil))
+
+	ctx := WithBlockHashHint(context.Background(), testTxBlock)
+	result, rpcErr, err := RemoteCallContext(ctx, "getrawtransaction", rawArguments(`"`+testTxID+`"`))
+	if nil != err || "null" != string(rpcErr) || `"`+testTransaction+`"` != string(result) {
+		t.Fatalf("result: %s  rpc error: %s  error: %v", result, rpcErr, err)
+	}
+	calls := backend.receivedFor("getrawtransaction")
+	if 2 != len(calls) || `getrawtransaction["`+testTxID+`",false,"`+testTxBlock+`"]` != calls[1] {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestBlockHashRetryLookup(t *testing.T) {
+	backend := noTxIndexBackend(t)
+	looked := ""
+	backend.connect(t, WithBlockHashRetry(func(ctx context.Context, txid string) (string, bool) {
+		looked = txid
+		return testTxBlock, true
+	}))
+
+	result, _, err := RemoteCall("getrawtransaction", rawArguments(`"`+testTxID+`"`))
+	if nil != err || `"`+testTransaction+`"` != string(result) {
+		t.Errorf("result: %s  error: %v", result, err)
+	}
+	if testTxID != looked {
+		t.Errorf("looked up: %q", looked)
+	}
+}
+
+func TestBlockHashRetryUnknown(t *testing.T) {
+	backend := noTxIndexBackend(t)
+	backend.connect(t, WithBlockHashRetry(func(ctx context.Context, txid string) (string, bool) {
+		return "", false
+	}))
+
+	// with no block to try the original error is kept
+	_, rpcErr, err := RemoteCall("getrawtransaction", rawArguments(`"`+testTxID+`"`))
+	var reply RPCError
+	if nil != err || nil != json.Unmarshal(rpcErr, &reply) || !reply.txIndexRequired() {
+		t.Errorf("rpc error: %s  error: %v", rpcErr, err)
+	}
+	if calls := backend.receivedFor("getrawtransaction"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestBlockHashRetryOff(t *testing.T) {
+	backend := noTxIndexBackend(t)
+	backend.connect(t)
+
+	ctx := WithBlockHashHint(context.Background(), testTxBlock)
+	_, rpcErr, err := RemoteCallContext(ctx, "getrawtransaction", rawArguments(`"`+testTxID+`"`))
+	if nil != err || !needsTxIndex(rpcErr) {
+		t.Errorf("rpc error: %s  error: %v", rpcErr, err)
+	}
+	if calls := backend.receivedFor("getrawtransaction"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestNeedsTxIndex(t *testing.T) {
+	for _, item := range []struct {
+		raw      string
+		expected bool
+	}{
+		{``, false},
+		{`null`, false},
+		{`{"code":-5,"message":"No such mempool transaction. Use -txindex or provide a block hash"}`, true},
+		{`{"code":-5,"message":"No such mempool or blockchain transaction"}`, false},
+		{`{"code":-8,"message":"txindex"}`, false},
+		{`"txindex"`, false},
+	} {
+		if actual := needsTxIndex(json.RawMessage(item.raw)); item.expected != actual {
+			t.Errorf("%s: %v  expected: %v", item.raw, actual, item.expected)
+		}
+	}
+}
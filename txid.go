@@ -0,0 +1,116 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// compute the txid of a serialised transaction, the reversed double
+// SHA-256 of the serialisation without any segwit marker and witnesses
+//
+// false if the data is not a complete transaction
+func transactionID(raw []byte) (string, bool) {
+
+	stripped, ok := stripWitness(raw)
+	if !ok {
+		return "", false
+	}
+	first := sha256.Sum256(stripped)
+	hash := sha256.Sum256(first[:])
+	for i, j := 0, len(hash)-1; i < j; i, j = i+1, j-1 {
+		hash[i], hash[j] = hash[j], hash[i]
+	}
+	return hex.EncodeToString(hash[:]), true
+}
+
+// the legacy serialisation of a transaction
+func stripWitness(raw []byte) ([]byte, bool) {
+
+	r := txReader{data: raw}
+	if !r.skip(4) { // version
+		return nil, false
+	}
+	segwit := len(raw) > 6 && 0 == raw[4] && 1 == raw[5]
+	if segwit {
+		r.offset += 2
+	}
+
+	start := r.offset
+	inputs, ok := r.compactSize()
+	if !ok {
+		return nil, false
+	}
+	for i := uint64(0); i < inputs; i += 1 {
+		if !r.skip(36) || !r.skipScript() || !r.skip(4) { // outpoint, script, sequence
+			return nil, false
+		}
+	}
+	outputs, ok := r.compactSize()
+	if !ok {
+		return nil, false
+	}
+	for i := uint64(0); i < outputs; i += 1 {
+		if !r.skip(8) || !r.skipScript() { // value, script
+			return nil, false
+		}
+	}
+	end := r.offset
+
+	if segwit {
+		for i := uint64(0); i < inputs; i += 1 {
+			items, ok := r.compactSize()
+			if !ok {
+				return nil, false
+			}
+			for j := uint64(0); j < items; j += 1 {
+				if !r.skipScript() {
+					return nil, false
+				}
+			}
+		}
+	}
+	if !r.skip(4) || r.offset != len(raw) { // lock time
+		return nil, false
+	}
+
+	if !segwit {
+		return raw, true
+	}
+	stripped := make([]byte, 0, 8+end-start)
+	stripped = append(stripped, raw[:4]...)
+	stripped = append(stripped, raw[start:end]...)
+	return append(stripped, raw[len(raw)-4:]...), true
+}
+
+// bounds checked reading of a transaction
+type txReader struct {
+	data   []byte
+	offset int
+}
+
+func (r *txReader) skip(n uint64) bool {
+	if n > uint64(len(r.data)-r.offset) {
+		return false
+	}
+	r.offset += int(n)
+	return true
+}
+
+func (r *txReader) compactSize() (uint64, bool) {
+	n, size := compactSize(r.data[r.offset:])
+	if 0 == size {
+		return 0, false
+	}
+	r.offset += size
+	return n, true
+}
+
+// a length prefixed script or witness item
+func (r *txReader) skipScript() bool {
+	n, ok := r.compactSize()
+	return ok && r.skip(n)
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+)
+
+// repoint the connection, e.g. for a blue/green daemon upgrade
+//
+// requests already sent complete against the old target and new ones
+// use the new target; the URL is checked as for NewRemoteConnection
+// and the new target is then probed once, returning the probe error
+// (the swap is kept so that the health tracking can follow it)
+func (conn *RemoteConnection) SetUpstream(url string, username string, password string) error {
+
+	u, err := parseEndpoint(url)
+	if nil != err {
+		return err
+	}
+	username, password = takeCredentials(u, username, password)
+	if "" != conn.wallet {
+		u = walletEndpoint(u, conn.wallet)
+	}
+
+	conn.Lock()
+	conn.url = u.String()
+	conn.username = username
+	conn.password = password
+	conn.Unlock()
+
+	return conn.checkUpstream()
+}
+
+// a single lightweight call whose outcome is recorded in the health
+func (conn *RemoteConnection) checkUpstream() error {
+	method := conn.probeMethod
+	if "" == method {
+		method = "getblockcount"
+	}
+	var reply json.RawMessage
+	var rpcErr json.RawMessage
+	return conn.remoteCall(conn.ctx, method, []interface{}{}, &reply, &rpcErr)
+}
+
+// the current target and credentials
+func (conn *RemoteConnection) upstream() (string, string, string) {
+	conn.RLock()
+	defer conn.RUnlock()
+	return conn.url, conn.username, conn.password
+}
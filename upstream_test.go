@@ -0,0 +1,90 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSetUpstream(t *testing.T) {
+	emptyCache(t)
+	blue := newHeldBackend(t, "getpeerinfo")
+	green := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getpeerinfo" == method {
+			return []string{"green"}, nil
+		}
+		return nil, nil
+	})
+	conn := blue.connect(t)
+
+	// a call left running on the old target, sent directly so the
+	// queued calls below are not stuck behind it
+	held := make(chan error, 1)
+	go func() {
+		_, _, err := conn.Do(context.Background(), "getpeerinfo", nil)
+		held <- err
+	}()
+	eventually(t, "the call on the old target to start", func() bool {
+		running, _ := blue.counts()
+		return 1 == running
+	})
+
+	err := conn.SetUpstream(green.URL, "green", "secret")
+	if nil != err {
+		t.Fatalf("swap error: %v", err)
+	}
+
+	// checked on swap
+	if calls := green.receivedFor("getblockcount"); 1 != len(calls) {
+		t.Errorf("health check calls: %v", calls)
+	}
+	if "green:secret" != green.credentials() {
+		t.Errorf("credentials: %q", green.credentials())
+	}
+
+	// new calls go to the new target
+	result, _, err := RemoteCall("getpeerinfo", nil)
+	if nil != err || `["green"]` != string(result) {
+		t.Errorf("result: %s  error: %v", result, err)
+	}
+
+	// and the call in flight completes against the old one
+	blue.done()
+	select {
+	case err := <-held:
+		if nil != err {
+			t.Errorf("in flight error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("in flight call did not return")
+	}
+	if calls := blue.receivedFor("getpeerinfo"); 1 != len(calls) {
+		t.Errorf("old target calls: %v", calls)
+	}
+	if calls := green.receivedFor("getpeerinfo"); 1 != len(calls) {
+		t.Errorf("new target calls: %v", calls)
+	}
+}
+
+func TestSetUpstreamInvalid(t *testing.T) {
+	emptyCache(t)
+	backend := newFakeBitcoind(t, 200000, nil)
+	conn := backend.connect(t)
+
+	if err := conn.SetUpstream("ftp://localhost:8332", "user", "password"); nil == err {
+		t.Error("invalid url accepted")
+	}
+
+	// still pointing at the original
+	if _, _, err := RemoteCall("getnettotals", nil); nil != err {
+		t.Errorf("error: %v", err)
+	}
+	if calls := backend.receivedFor("getnettotals"); 1 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}
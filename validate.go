@@ -0,0 +1,61 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+)
+
+// check a successful result, returning either the result to use
+// (which may be transformed) or an error to reject it
+//
+// a missing or null result is passed as JSON null
+type ResponseValidator func(method string, result json.RawMessage) (json.RawMessage, error)
+
+// check every successful result of method with validator
+func WithResponseValidator(method string, validator ResponseValidator) Option {
+	return func(conn *RemoteConnection) {
+		if nil == conn.validators {
+			conn.validators = make(map[string]ResponseValidator)
+		}
+		conn.validators[method] = validator
+	}
+}
+
+// run any validator for the method over a decoded reply
+func (conn *RemoteConnection) validate(method string, reply interface{}) error {
+
+	validator := conn.validators[method]
+	if nil == validator {
+		return nil
+	}
+
+	// proxied calls keep the raw result, typed replies are re-encoded
+	raw, isRaw := reply.(*json.RawMessage)
+	var result json.RawMessage
+	if isRaw {
+		result = *raw
+	} else {
+		buffer, err := json.Marshal(reply)
+		if nil != err {
+			return err
+		}
+		result = buffer
+	}
+	if 0 == len(result) {
+		result = jsonNull
+	}
+
+	result, err := validator(method, result)
+	if nil != err {
+		return err
+	}
+
+	if isRaw {
+		*raw = result
+		return nil
+	}
+	return json.Unmarshal(result, reply)
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseValidator(t *testing.T) {
+	errNullHash := errors.New("null block hash")
+	backend := newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		if "getblockhash" == method && "8" == string(params[0]) {
+			return "00AA", nil
+		}
+		return nil, nil
+	})
+	backend.connect(t, WithResponseValidator("getblockhash", func(method string, result json.RawMessage) (json.RawMessage, error) {
+		if isNull(result) {
+			return nil, errNullHash
+		}
+		return bytes.ToLower(result), nil
+	}))
+	setTestTries(t, 1)
+
+	result, _, err := RemoteCall("getblockhash", rawArguments(`8`))
+	if nil != err {
+		t.Fatalf("error: %v", err)
+	}
+	if `"00aa"` != string(result) {
+		t.Errorf("result: %s", result)
+	}
+
+	_, _, err = RemoteCall("getblockhash", rawArguments(`9`))
+	if !errors.Is(err, errNullHash) {
+		t.Errorf("error: %v  expected: %v", err, errNullHash)
+	}
+
+	// other methods are not checked
+	result, _, err = RemoteCall("getpeerinfo", nil)
+	if nil != err || !isNull(result) {
+		t.Errorf("result: %s  error: %v", result, err)
+	}
+}
+
+// replies without an error member are still results to validate
+func TestResponseValidatorNoErrorMember(t *testing.T) {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var request fakeRequest
+		json.Unmarshal(body, &request)
+		switch {
+		case "getblockhash" == request.Method && "8" == string(request.Params[0]):
+			fmt.Fprintf(w, `{"id":%s,"result":"00AA"}`, request.ID)
+		case "getblockhash" == request.Method:
+			fmt.Fprintf(w, `{"id":%s,"result":null,"error":{"code":-8,"message":"Block height out of range"}}`, request.ID)
+		default:
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			backend.serve(w, r)
+		}
+	}))
+	t.Cleanup(backend.Server.Close)
+
+	validated := 0
+	conn := backend.connect(t, WithResponseValidator("getblockhash", func(method string, result json.RawMessage) (json.RawMessage, error) {
+		validated += 1
+		return bytes.ToLower(result), nil
+	}))
+	setTestTries(t, 1)
+
+	result, rpcErr, err := RemoteCall("getblockhash", rawArguments(`8`))
+	if nil != err || !isNull(rpcErr) || `"00aa"` != string(result) {
+		t.Errorf("result: %s  rpc error: %s  error: %v", result, rpcErr, err)
+	}
+
+	var hash string
+	err = conn.call(context.Background(), "getblockhash", []interface{}{8}, &hash)
+	if nil != err || "00aa" != hash {
+		t.Errorf("direct result: %q  error: %v", hash, err)
+	}
+
+	// nor is an error reply
+	_, rpcErr, err = RemoteCall("getblockhash", rawArguments(`9`))
+	if nil != err || isNull(rpcErr) {
+		t.Errorf("rpc error: %s  error: %v", rpcErr, err)
+	}
+	if 2 != validated {
+		t.Errorf("%d results validated", validated)
+	}
+}
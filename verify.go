@@ -0,0 +1,50 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errors
+var (
+	ErrResultHashMismatch = errors.New("result hash does not match request")
+)
+
+// check that verbose getblock, getblockheader and getrawtransaction
+// results are for the requested hash, guarding against a faulty
+// backend; raw hex results cannot be checked
+func WithHashVerification() Option {
+	return func(conn *RemoteConnection) {
+		conn.verifyHashes = true
+	}
+}
+
+// compare the hash field of a verbose result with the requested hash
+// a null result (when there is an RPC error) is not checked
+func (conn *RemoteConnection) verifyHash(result json.RawMessage, field string, hash string) error {
+
+	if !conn.verifyHashes || isNull(result) {
+		return nil
+	}
+
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(result, &fields)
+	if nil != err {
+		return ErrIncomprehesibleResponse
+	}
+	var actual string
+	err = json.Unmarshal(fields[field], &actual)
+	if nil != err {
+		return fmt.Errorf("%w: no %s in result", ErrResultHashMismatch, field)
+	}
+	if !strings.EqualFold(actual, hash) {
+		return fmt.Errorf("%w: requested %s received %s", ErrResultHashMismatch, hash, actual)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// a backend answering every block request with the genesis block
+func genesisBackend(t *testing.T) *fakeBitcoind {
+	return newFakeBitcoind(t, 200000, func(method string, params []json.RawMessage) (interface{}, *RPCError) {
+		switch method {
+		case "getblock":
+			if 2 == len(params) && "0" == string(params[1]) {
+				return "0100000000", nil
+			}
+			return json.RawMessage(genesisHeader), nil
+		case "getblockheader":
+			return json.RawMessage(genesisHeader), nil
+		}
+		return nil, nil
+	})
+}
+
+func TestHashVerificationMismatch(t *testing.T) {
+	backend := genesisBackend(t)
+	backend.connect(t, WithHashVerification())
+
+	other := `"` + strings.Repeat("11", 32) + `"`
+	for _, call := range []struct {
+		method    string
+		arguments []json.RawMessage
+	}{
+		{"getblock", rawArguments(other)},
+		{"getblock", rawArguments(other, `2`)},
+		{"getblockheader", rawArguments(other)},
+	} {
+		_, _, err := RemoteCall(call.method, call.arguments)
+		if !errors.Is(err, ErrResultHashMismatch) {
+			t.Errorf("%s %s error: %v  expected: %v", call.method, call.arguments, err, ErrResultHashMismatch)
+		}
+	}
+
+	// raw hex cannot be checked
+	_, _, err := RemoteCall("getblock", rawArguments(other, `0`))
+	if nil != err {
+		t.Errorf("raw block error: %v", err)
+	}
+}
+
+func TestHashVerificationMatch(t *testing.T) {
+	backend := genesisBackend(t)
+	backend.connect(t, WithHashVerification())
+
+	for _, hash := range []string{genesisHash, strings.ToUpper(genesisHash)} {
+		_, _, err := RemoteCall("getblock", rawArguments(`"`+hash+`"`))
+		if nil != err {
+			t.Errorf("%s error: %v", hash, err)
+		}
+	}
+}
+
+func TestHashVerificationOff(t *testing.T) {
+	backend := genesisBackend(t)
+	backend.connect(t)
+
+	_, _, err := RemoteCall("getblock", rawArguments(`"`+strings.Repeat("22", 32)+`"`))
+	if nil != err {
+		t.Errorf("error: %v", err)
+	}
+}
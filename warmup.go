@@ -0,0 +1,77 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// open n keep-alive connections to the backend once the bootstrap
+// checks pass, when connecting or with WithLazyBootstrap on the first
+// call, and again when a health probe brings the connection back, so
+// that the first calls do not wait for connection set up and a TLS
+// handshake
+func WithWarmup(n int) Option {
+	return func(conn *RemoteConnection) {
+		conn.warmup = n
+	}
+}
+
+// let the transport keep the warm connections idle
+func (conn *RemoteConnection) keepWarmConnections() {
+	if conn.warmup <= http.DefaultMaxIdleConnsPerHost {
+		return
+	}
+	transport, ok := conn.client.Transport.(*http.Transport)
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+		conn.client.Transport = transport
+	}
+	transport.MaxIdleConnsPerHost = conn.warmup
+}
+
+// make the warm-up requests all at once and hold each reply until all
+// have arrived, so every request needs its own connection, then read
+// them to the end to leave the connections idle for reuse
+//
+// best effort, a failed request only means one connection fewer
+func (conn *RemoteConnection) warmUp(ctx context.Context) {
+	if conn.warmup <= 0 {
+		return
+	}
+
+	responses := make([]*http.Response, conn.warmup)
+	var wg sync.WaitGroup
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			arguments := bitcoinArguments{
+				ID:         conn.nextIDs(1),
+				Method:     conn.upstreamMethod("getblockcount"),
+				Parameters: []interface{}{},
+			}
+			response, err := conn.post(ctx, arguments)
+			if nil != err {
+				if nil != conn.logger {
+					conn.logger.Debugf("warm-up request error: %v", err)
+				}
+				return
+			}
+			responses[i] = response
+		}(i)
+	}
+	wg.Wait()
+
+	for _, response := range responses {
+		if nil != response {
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+		}
+	}
+}
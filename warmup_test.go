@@ -0,0 +1,74 @@
+// Copyright (c) 2014-2016 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// a fake backend counting the connections opened to it
+func countingBackend(t *testing.T, opened *int64) *fakeBitcoind {
+	backend := newFakeBitcoind(t, 200000, nil)
+	backend.Close()
+	backend.Server = httptest.NewUnstartedServer(http.HandlerFunc(backend.serve))
+	backend.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if http.StateNew == state {
+			atomic.AddInt64(opened, 1)
+		}
+	}
+	backend.Start()
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestWarmup(t *testing.T) {
+	var opened int64
+	backend := countingBackend(t, &opened)
+	conn := backend.connect(t, WithWarmup(4))
+
+	// all open once connected, before any call is made
+	warm := atomic.LoadInt64(&opened)
+	if warm < 4 {
+		t.Fatalf("only %d connections opened", warm)
+	}
+	if calls := backend.receivedFor("getblockcount"); 4 != len(calls) {
+		t.Errorf("%d warm-up requests", len(calls))
+	}
+
+	// concurrent calls reuse the warm connections
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			_, _, err := conn.Do(context.Background(), "getnettotals", nil)
+			errs <- err
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; nil != err {
+			t.Fatalf("call error: %v", err)
+		}
+	}
+	if n := atomic.LoadInt64(&opened); warm != n {
+		t.Errorf("%d connections opened by the calls", n-warm)
+	}
+}
+
+func TestWarmupOff(t *testing.T) {
+	var opened int64
+	backend := countingBackend(t, &opened)
+	backend.connect(t)
+
+	if n := atomic.LoadInt64(&opened); 1 != n {
+		t.Errorf("%d connections opened", n)
+	}
+	if calls := backend.received(); 0 != len(calls) {
+		t.Errorf("calls: %v", calls)
+	}
+}